@@ -3,25 +3,50 @@ package cache
 import (
 	"cache/lru"
 	"image"
+	"time"
+
+	"image/codec"
+	"warehouse/storage"
 )
 
-const numberOfImagesToCache = 50
+// DefaultCapacity is the default memory budget of the image cache in bytes.
+const DefaultCapacity = 512 << 20
+
+// valueOverhead approximates the memory held by a cache entry besides the
+// pixel buffers: the image header, the list element and the table slot.
+const valueOverhead = 256
 
 type Cache struct {
 	lru *lru.LRUCache
 }
 
 type Value struct {
-	image *image.Image
+	entry *Entry
+	size  int
+}
+
+// Entry is a decoded original together with what is known about it.
+type Entry struct {
+	Image *image.Image
+	// Format is the format name reported by the decoder.
+	Format string
+	// Source describes the original the image was decoded from, when the
+	// storage supports revalidating it, and Validated tells when the
+	// source was last confirmed to be current.
+	Source    *storage.Object
+	Validated time.Time
 }
 
+// Size returns the decoded pixel footprint of the cached image in bytes.
 func (v *Value) Size() int {
-	return 1
+	return v.size
 }
 
-func New() *Cache {
+// New creates an image cache which holds at most capacity bytes of
+// decoded pixel data.
+func New(capacity int64) *Cache {
 	return &Cache{
-		lru: lru.NewLRUCache(numberOfImagesToCache),
+		lru: lru.NewLRUCache(capacity),
 	}
 }
 
@@ -31,10 +56,80 @@ func (cache *Cache) Get(key string) *image.Image {
 		return nil
 	}
 
-	return value.(*Value).image
+	return value.(*Value).entry.Image
 }
 
 func (cache *Cache) Set(key string, image *image.Image) {
-	value := &Value{image}
+	cache.SetEntry(key, &Entry{Image: image, Validated: time.Now()})
+}
+
+// GetEntry returns the cached original stored under key, or nil.
+func (cache *Cache) GetEntry(key string) *Entry {
+	value, ok := cache.lru.Get(key)
+	if !ok {
+		return nil
+	}
+
+	return value.(*Value).entry
+}
+
+// SetEntry caches an original. Entries must not be modified once set.
+func (cache *Cache) SetEntry(key string, entry *Entry) {
+	value := &Value{entry, Size(entry.Image)}
 	cache.lru.Set(key, value)
 }
+
+// Stats returns the number of cached images, the bytes they occupy and
+// the memory budget of the cache.
+func (cache *Cache) Stats() (length, size, capacity int64) {
+	length, size, capacity, _ = cache.lru.Stats()
+	return
+}
+
+// Size returns the number of bytes an image occupies in memory. The pixel
+// buffers of the concrete image types and of the frames of animations are
+// measured exactly, other images are estimated at 16-bit RGBA precision.
+func Size(img *image.Image) int {
+	if img == nil || *img == nil {
+		return valueOverhead
+	}
+
+	var size int
+	switch m := (*img).(type) {
+	case *image.YCbCr:
+		size = len(m.Y) + len(m.Cb) + len(m.Cr)
+	case *image.NYCbCrA:
+		size = len(m.Y) + len(m.Cb) + len(m.Cr) + len(m.A)
+	case *image.RGBA:
+		size = len(m.Pix)
+	case *image.RGBA64:
+		size = len(m.Pix)
+	case *image.NRGBA:
+		size = len(m.Pix)
+	case *image.NRGBA64:
+		size = len(m.Pix)
+	case *image.Gray:
+		size = len(m.Pix)
+	case *image.Gray16:
+		size = len(m.Pix)
+	case *image.Alpha:
+		size = len(m.Pix)
+	case *image.Alpha16:
+		size = len(m.Pix)
+	case *image.CMYK:
+		size = len(m.Pix)
+	case *image.Paletted:
+		size = len(m.Pix) + 4*len(m.Palette)
+	case *codec.Animation:
+		for _, frame := range m.GIF.Image {
+			size += len(frame.Pix) + 4*len(frame.Palette)
+		}
+		bounds := m.Bounds()
+		size += 4 * bounds.Dx() * bounds.Dy()
+	default:
+		bounds := m.Bounds()
+		size = 8 * bounds.Dx() * bounds.Dy()
+	}
+
+	return size + valueOverhead
+}
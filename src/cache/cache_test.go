@@ -1,21 +1,28 @@
 package cache_test
 
 import (
-	"io/ioutil"
+	"image"
+	"image/color"
 	"log"
+	"os"
 
 	"cache"
 	"testing"
 	"warehouse/reader"
+	"warehouse/storage"
 )
 
 func TestCachePopulating(t *testing.T) {
-	cache := cache.New()
+	cache := cache.New(cache.DefaultCapacity)
 
 	log.Printf("BUILDER: Started building image cache...")
 
 	log.Printf("BUILDER: Reading images from \"%s\" folder ...", reader.Warehouse)
-	files, err := ioutil.ReadDir("./../../../" + reader.Warehouse)
+	if _, err := os.Stat("./../../../" + reader.Warehouse); os.IsNotExist(err) {
+		t.Skipf("no \"%s\" folder to populate the cache from", reader.Warehouse)
+	}
+	store := storage.NewLocal("./../../../" + reader.Warehouse)
+	files, err := store.List()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -24,13 +31,69 @@ func TestCachePopulating(t *testing.T) {
 	log.Printf("BUILDER: Populating image cache...")
 
 	for _, file := range files {
-		filename := file.Name()
-		populate(cache, filename)
+		populate(cache, store, file.Name)
 	}
 
 	log.Printf("BUILDER: Finished building image cache.")
 }
 
-func populate(cache *cache.Cache, filename string) {
-	cache.Set(filename, reader.Decode(filename))
+func populate(cache *cache.Cache, store storage.Storage, filename string) {
+	image, _, err := reader.Decode(store, filename)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	cache.Set(filename, image)
+}
+
+func TestSizeMatchesPixelFootprint(t *testing.T) {
+	rect := image.Rect(0, 0, 40, 30)
+	tests := []struct {
+		img    image.Image
+		pixels int
+	}{
+		{image.NewYCbCr(rect, image.YCbCrSubsampleRatio420), 40*30 + 2*20*15},
+		{image.NewRGBA(rect), 4 * 40 * 30},
+		{image.NewRGBA64(rect), 8 * 40 * 30},
+		{image.NewGray(rect), 40 * 30},
+		{image.NewPaletted(rect, make([]color.Color, 16)), 40*30 + 4*16},
+	}
+
+	for _, test := range tests {
+		img := test.img
+		size := cache.Size(&img)
+		if size <= test.pixels {
+			t.Errorf("Size(%T) = %v, want more than %v", test.img, size, test.pixels)
+		}
+		if size > test.pixels+1024 {
+			t.Errorf("Size(%T) = %v, overhead exceeds 1KiB", test.img, size)
+		}
+	}
+}
+
+func TestCapacityIsCountedInBytes(t *testing.T) {
+	big := image.Image(image.NewRGBA(image.Rect(0, 0, 100, 100)))
+	icon := image.Image(image.NewRGBA(image.Rect(0, 0, 4, 4)))
+
+	c := cache.New(int64(cache.Size(&big) + 3*cache.Size(&icon)))
+	c.Set("icon1", &icon)
+	c.Set("icon2", &icon)
+	c.Set("icon3", &icon)
+	c.Set("big", &big)
+
+	length, size, capacity := c.Stats()
+	if size > capacity {
+		t.Errorf("cache size %v exceeds its capacity %v", size, capacity)
+	}
+	if length != 4 {
+		t.Errorf("cache holds %v images, want 4", length)
+	}
+
+	c.Set("icon4", &icon)
+	if c.Get("icon1") != nil {
+		t.Error("least recently used image was not evicted")
+	}
+	if c.Get("big") == nil {
+		t.Error("big image was evicted although the budget allows it")
+	}
 }
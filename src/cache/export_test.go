@@ -0,0 +1,11 @@
+package cache
+
+import "time"
+
+// SetNow replaces the clock renditions are aged by and returns a function
+// restoring it.
+func SetNow(f func() time.Time) (restore func()) {
+	saved := now
+	now = f
+	return func() { now = saved }
+}
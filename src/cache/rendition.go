@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"cache/lru"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRenditionCapacity is the default memory budget of the rendition
+// cache in bytes.
+const DefaultRenditionCapacity = 128 << 20
+
+// now returns the current time; tests replace it to age renditions.
+var now = time.Now
+
+// RenditionCache holds encoded renditions keyed by the transformation that
+// produced them, so repeated requests skip decoding, resizing and encoding.
+type RenditionCache struct {
+	lru    *lru.LRUCache
+	maxAge time.Duration
+
+	hits   int64
+	misses int64
+}
+
+// Rendition is an encoded image ready to be served.
+type Rendition struct {
+	Data        []byte
+	ContentType string
+	// Header holds further response headers describing the rendition,
+	// such as the region a smart crop kept.
+	Header http.Header
+
+	created time.Time
+}
+
+// Size returns the number of encoded bytes and header bytes plus the
+// entry overhead.
+func (r *Rendition) Size() int {
+	size := cap(r.Data) + valueOverhead
+	for name, values := range r.Header {
+		size += len(name)
+		for _, value := range values {
+			size += len(value)
+		}
+	}
+	return size
+}
+
+// NewRenditionCache creates a rendition cache which holds at most capacity
+// bytes of encoded output.
+func NewRenditionCache(capacity int64) *RenditionCache {
+	return &RenditionCache{
+		lru: lru.NewLRUCache(capacity),
+	}
+}
+
+// SetMaxAge makes renditions older than maxAge count as misses, so they
+// are rebuilt from a revalidated original. Zero keeps them until evicted.
+func (cache *RenditionCache) SetMaxAge(maxAge time.Duration) {
+	cache.maxAge = maxAge
+}
+
+// Get returns the rendition stored under key, or nil on a miss.
+func (cache *RenditionCache) Get(key string) *Rendition {
+	value, ok := cache.lru.Get(key)
+	if !ok || cache.maxAge > 0 && now().Sub(value.(*Rendition).created) > cache.maxAge {
+		atomic.AddInt64(&cache.misses, 1)
+		return nil
+	}
+
+	atomic.AddInt64(&cache.hits, 1)
+	return value.(*Rendition)
+}
+
+// Set caches a rendition. Renditions must not be modified once set.
+func (cache *RenditionCache) Set(key string, rendition *Rendition) {
+	rendition.created = now()
+	cache.lru.Set(key, rendition)
+}
+
+// Stats returns the number of cached renditions, the bytes they occupy,
+// the memory budget of the cache and the hit and miss counters.
+func (cache *RenditionCache) Stats() (length, size, capacity, hits, misses int64) {
+	length, size, capacity, _ = cache.lru.Stats()
+	return length, size, capacity, atomic.LoadInt64(&cache.hits), atomic.LoadInt64(&cache.misses)
+}
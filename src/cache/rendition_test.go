@@ -0,0 +1,65 @@
+package cache_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"cache"
+)
+
+func TestRenditionCacheCountsHitsAndMisses(t *testing.T) {
+	c := cache.NewRenditionCache(cache.DefaultRenditionCapacity)
+	data := []byte("encoded")
+
+	if c.Get("a.jpg?w=10") != nil {
+		t.Error("empty cache returned a rendition")
+	}
+	c.Set("a.jpg?w=10", &cache.Rendition{Data: data, ContentType: "image/jpeg"})
+	if got := c.Get("a.jpg?w=10"); got == nil || !bytes.Equal(got.Data, data) || got.ContentType != "image/jpeg" {
+		t.Errorf("Get returned %+v, want %q", got, data)
+	}
+
+	length, size, _, hits, misses := c.Stats()
+	if length != 1 || size < int64(len(data)) {
+		t.Errorf("Stats() length = %v, size = %v", length, size)
+	}
+	if hits != 1 || misses != 1 {
+		t.Errorf("Stats() hits = %v, misses = %v, want 1 and 1", hits, misses)
+	}
+}
+
+func TestRenditionCacheCapacity(t *testing.T) {
+	c := cache.NewRenditionCache(3000)
+	c.Set("first", &cache.Rendition{Data: make([]byte, 1000)})
+	c.Set("second", &cache.Rendition{Data: make([]byte, 1000)})
+	c.Set("third", &cache.Rendition{Data: make([]byte, 1000)})
+
+	if c.Get("first") != nil {
+		t.Error("oldest rendition was not evicted")
+	}
+	if _, size, capacity, _, _ := c.Stats(); size > capacity {
+		t.Errorf("cache size %v exceeds its capacity %v", size, capacity)
+	}
+}
+
+func TestRenditionCacheMaxAge(t *testing.T) {
+	clock := time.Now()
+	defer cache.SetNow(func() time.Time { return clock })()
+
+	c := cache.NewRenditionCache(cache.DefaultRenditionCapacity)
+	c.SetMaxAge(10 * time.Millisecond)
+	c.Set("a.jpg?w=10", &cache.Rendition{Data: []byte("encoded")})
+
+	if c.Get("a.jpg?w=10") == nil {
+		t.Error("fresh rendition was not returned")
+	}
+	clock = clock.Add(10 * time.Millisecond)
+	if c.Get("a.jpg?w=10") == nil {
+		t.Error("rendition expired at exactly its max age")
+	}
+	clock = clock.Add(time.Nanosecond)
+	if c.Get("a.jpg?w=10") != nil {
+		t.Error("expired rendition was returned")
+	}
+}
@@ -0,0 +1,91 @@
+// Package singleflight collapses concurrent calls for the same key into a
+// single execution whose result is shared by all callers.
+package singleflight
+
+import "sync"
+
+// call is an in-flight or completed Do call. A call whose function
+// panicked holds the value it panicked with.
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+
+	// dups counts the callers waiting on the call, guarded by Group.mu.
+	dups int
+
+	panicked bool
+	panicVal interface{}
+}
+
+// waitHook is called when a duplicate caller starts waiting, for tests.
+var waitHook = func() {}
+
+// Group represents a class of work and forms a namespace in which units of
+// work can be executed with duplicate suppression. The zero value is ready
+// to use.
+type Group struct {
+	mu sync.Mutex
+	m  map[string]*call
+}
+
+// Do executes and returns the results of the given function, making sure
+// that only one execution is in-flight for a given key at a time. If a
+// duplicate comes in, the duplicate caller waits for the original to
+// complete and receives the same results, including the error. If the
+// function panics, the key is released and every caller panics with the
+// same value.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		waitHook()
+		c.wg.Wait()
+		if c.panicked {
+			panic(c.panicVal)
+		}
+		return c.val, c.err
+	}
+	c := new(call)
+	c.wg.Add(1)
+	g.m[key] = c
+	g.mu.Unlock()
+
+	g.doCall(c, key, fn)
+	return c.val, c.err
+}
+
+// Dups returns the number of duplicate callers waiting on the in-flight
+// call for key, or zero if no call for key is in flight.
+func (g *Group) Dups(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.m[key]; ok {
+		return c.dups
+	}
+	return 0
+}
+
+// doCall runs fn for c, then wakes the duplicates and forgets key even if
+// fn panics.
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.panicked, c.panicVal = true, r
+		}
+		c.wg.Done()
+
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+
+		if c.panicked {
+			panic(c.panicVal)
+		}
+	}()
+	c.val, c.err = fn()
+}
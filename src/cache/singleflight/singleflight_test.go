@@ -0,0 +1,154 @@
+package singleflight
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if got, want := v.(string), "bar"; got != want {
+		t.Errorf("Do = %v; want %v", got, want)
+	}
+	if err != nil {
+		t.Errorf("Do error = %v", err)
+	}
+}
+
+func TestDoErr(t *testing.T) {
+	var g Group
+	someErr := errors.New("some error")
+	v, err := g.Do("key", func() (interface{}, error) {
+		return nil, someErr
+	})
+	if err != someErr {
+		t.Errorf("Do error = %v; want someErr", err)
+	}
+	if v != nil {
+		t.Errorf("unexpected non-nil value %#v", v)
+	}
+}
+
+// startDups runs do with fn once and, as soon as fn runs, n-1 more times
+// at once, returning when all duplicates wait for the first call.
+func startDups(t *testing.T, n int, fn func() (interface{}, error), do func(fn func() (interface{}, error))) *sync.WaitGroup {
+	waiting := make(chan struct{})
+	waitHook = func() { waiting <- struct{}{} }
+	t.Cleanup(func() { waitHook = func() {} })
+
+	entered := make(chan struct{})
+	var once sync.Once
+	first := func() (interface{}, error) {
+		once.Do(func() { close(entered) })
+		return fn()
+	}
+	var wg sync.WaitGroup
+	wg.Add(n)
+	call := func() {
+		defer wg.Done()
+		do(first)
+	}
+	go call()
+	<-entered
+	for i := 1; i < n; i++ {
+		go call()
+	}
+	for i := 1; i < n; i++ {
+		<-waiting
+	}
+	return &wg
+}
+
+func TestDoDupSuppress(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+
+	wg := startDups(t, 100, fn, func(fn func() (interface{}, error)) {
+		v, err := g.Do("key", fn)
+		if err != nil {
+			t.Errorf("Do error: %v", err)
+		}
+		if v.(string) != "bar" {
+			t.Errorf("got %q; want %q", v, "bar")
+		}
+	})
+	if got := g.Dups("key"); got != 99 {
+		t.Errorf("Dups = %d; want 99", got)
+	}
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("number of calls = %d; want 1", got)
+	}
+	if got := g.Dups("key"); got != 0 {
+		t.Errorf("Dups after the call = %d; want 0", got)
+	}
+}
+
+func TestDoDupSuppressSharesError(t *testing.T) {
+	var g Group
+	var calls int32
+	someErr := errors.New("some error")
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil, someErr
+	}
+
+	wg := startDups(t, 50, fn, func(fn func() (interface{}, error)) {
+		if _, err := g.Do("key", fn); err != someErr {
+			t.Errorf("Do error = %v; want someErr", err)
+		}
+	})
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("number of calls = %d; want 1", got)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	var panics int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		panic("boom")
+	}
+
+	const n = 10
+	wg := startDups(t, n, fn, func(fn func() (interface{}, error)) {
+		defer func() {
+			if r := recover(); r == "boom" {
+				atomic.AddInt32(&panics, 1)
+			} else {
+				t.Errorf("Do panicked with %v; want boom", r)
+			}
+		}()
+		g.Do("key", fn)
+	})
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&panics); got != n {
+		t.Errorf("number of panics = %d; want %d", got, n)
+	}
+	// The key is free again.
+	if v, err := g.Do("key", func() (interface{}, error) { return "bar", nil }); v != "bar" || err != nil {
+		t.Errorf("Do after a panic = %v, %v; want bar", v, err)
+	}
+}
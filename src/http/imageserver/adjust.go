@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/url"
+	"strconv"
+
+	"image/codec"
+	"image/resizer"
+)
+
+// parseAdjustments reads the color adjustments of a query: ?brightness=,
+// ?contrast= and ?saturation= in percent from -100 to 100, ?gamma= from
+// 0.1 to 10 and ?hue= in degrees from -360 to 360.
+func parseAdjustments(query url.Values) (resizer.Adjustments, error) {
+	var a resizer.Adjustments
+	params := []struct {
+		name     string
+		min, max float64
+		scale    float64
+		field    *float64
+	}{
+		{"brightness", -100, 100, 0.01, &a.Brightness},
+		{"contrast", -100, 100, 0.01, &a.Contrast},
+		{"gamma", 0.1, 10, 1, &a.Gamma},
+		{"saturation", -100, 100, 0.01, &a.Saturation},
+		{"hue", -360, 360, 1, &a.Hue},
+	}
+	for _, p := range params {
+		value := query.Get(p.name)
+		if value == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(v >= p.min && v <= p.max) {
+			return a, fmt.Errorf("%w: %s %q is not between %g and %g", errBadRequest, p.name, value, p.min, p.max)
+		}
+		*p.field = v * p.scale
+	}
+	return a, nil
+}
+
+// adjust applies the color adjustments of t to img. Animations have the
+// palettes of their frames adjusted instead of their pixels.
+func adjust(t transformation, img *image.Image) *image.Image {
+	if t.adjustments == (resizer.Adjustments{}) {
+		return img
+	}
+	if a, ok := (*img).(*codec.Animation); ok {
+		return mapPalettes(a, t.adjustments.Color)
+	}
+	return resizer.Adjust(img, t.adjustments)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"image/resizer"
+)
+
+func TestParseAdjustments(t *testing.T) {
+	tests := []struct {
+		query string
+		want  resizer.Adjustments
+		ok    bool
+	}{
+		{"", resizer.Adjustments{}, true},
+		{"brightness=20&contrast=-50", resizer.Adjustments{Brightness: 0.2, Contrast: -0.5}, true},
+		{"gamma=2.2&saturation=100&hue=-90", resizer.Adjustments{Gamma: 2.2, Saturation: 1, Hue: -90}, true},
+		{"brightness=101", resizer.Adjustments{}, false},
+		{"gamma=0", resizer.Adjustments{}, false},
+		{"hue=NaN", resizer.Adjustments{}, false},
+		{"saturation=lots", resizer.Adjustments{}, false},
+	}
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		got, err := parseAdjustments(query)
+		if (err == nil) != test.ok || test.ok && got != test.want {
+			t.Errorf("parseAdjustments(%q) = %+v, %v", test.query, got, err)
+		}
+	}
+}
+
+func TestImageHandlerAdjustments(t *testing.T) {
+	serveFromMemory(map[string][]byte{"turned.jpg": encodeTurnedJPEG(t), "anim.gif": encodeTestAnimation(t)})
+
+	tests := []struct {
+		target string
+		status int
+		// dark and light are the gray levels of the two halves of the
+		// upright image.
+		dark, light uint8
+	}{
+		{"/turned.jpg", http.StatusOK, 0, 255},
+		{"/turned.jpg?brightness=20", http.StatusOK, 51, 255},
+		{"/turned.jpg?contrast=-50", http.StatusOK, 64, 191},
+		{"/turned.jpg?brightness=-10&contrast=-100", http.StatusOK, 128, 128},
+		{"/turned.jpg?brightness=-50&gamma=2", http.StatusOK, 0, 180},
+		{"/turned.jpg?brightness=200", http.StatusBadRequest, 0, 0},
+		{"/turned.jpg?gamma=-1", http.StatusBadRequest, 0, 0},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		img, err := jpeg.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []struct {
+			at   image.Point
+			want uint8
+		}{{image.Pt(4, 2), test.dark}, {image.Pt(4, 13), test.light}} {
+			got := color.GrayModel.Convert(img.At(p.at.X, p.at.Y)).(color.Gray).Y
+			if d := int(got) - int(p.want); d < -3 || d > 3 {
+				t.Errorf("GET %s: pixel %v = %d, want %d", test.target, p.at, got, p.want)
+			}
+		}
+	}
+
+	// Animations keep their pixels and change their palettes.
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/anim.gif?saturation=-100", nil))
+	g, err := gif.DecodeAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, gr, b, _ := g.Image[0].At(5, 5).RGBA()
+	if r != gr || gr != b || r>>8 != 76 {
+		t.Errorf("desaturated red frame is %d, %d, %d, want gray 76", r>>8, gr>>8, b>>8)
+	}
+
+	if (transformation{adjustments: resizer.Adjustments{Hue: 10}}).key() == (transformation{}).key() {
+		t.Error("the adjustments are missing from the key")
+	}
+	if (transformation{adjustments: resizer.Adjustments{Gamma: 1}}).key() != (transformation{}).key() {
+		t.Error("a gamma of 1 changes the key")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/http"
+
+	"image/codec"
+	"image/resizer"
+)
+
+// resize scales img into the box requested by t according to its fit
+// mode, gravity, filter and choice of linear light. Animations are scaled
+// frame by frame and centered by smart gravity. A smart crop reports the
+// region of the original it kept in the X-Smart-Crop header, as x,y,w,h.
+func resize(t transformation, img *image.Image) (*image.Image, http.Header) {
+	if a, ok := (*img).(*codec.Animation); ok {
+		return resizeAnimation(t.fit.Geometry(uint(t.width), uint(t.height), a.Bounds()).Anchor(t.gravity), resizer.Options{Filter: t.filter, Linear: t.linear}, a), nil
+	}
+	options := resizer.Options{Fit: t.fit, Gravity: t.gravity, Background: t.background, Filter: t.filter, Linear: t.linear}
+	if t.fit == resizer.Cover && t.gravity == resizer.Smart {
+		result, window := resizer.SmartCover(uint(t.width), uint(t.height), img, options)
+		header := http.Header{}
+		header.Set("X-Smart-Crop", fmt.Sprintf("%d,%d,%d,%d", window.Min.X, window.Min.Y, window.Dx(), window.Dy()))
+		return result, header
+	}
+	return resizer.ResizeWith(uint(t.width), uint(t.height), img, options), nil
+}
+
+// resizeAnimation scales every frame of an animation with the filter of
+// options, in linear light if they ask for it, lays it out as g describes,
+// scaling its position on the canvas along, and maps the result back to
+// the palette of the frame. Delays, disposal methods and the loop count
+// are kept. Padding added by g stays transparent, and the delay of a frame
+// cropped away entirely is added to the one before it.
+func resizeAnimation(g resizer.Geometry, options resizer.Options, a *codec.Animation) *image.Image {
+	var result image.Image = a
+	bounds := a.Bounds()
+	canvas := g.Canvas
+	if int(g.Width) == bounds.Dx() && int(g.Height) == bounds.Dy() && canvas.Size() == bounds.Size() {
+		return &result
+	}
+
+	scaleX := float64(g.Width) / float64(bounds.Dx())
+	scaleY := float64(g.Height) / float64(bounds.Dy())
+	scale := func(v int, factor float64) int {
+		return int(math.Round(float64(v) * factor))
+	}
+	scaledCanvas := image.Rect(0, 0, int(g.Width), int(g.Height))
+
+	out := *a.GIF
+	out.Config.Width, out.Config.Height = canvas.Dx(), canvas.Dy()
+	out.Image, out.Delay, out.Disposal = nil, nil, nil
+	for i, frame := range a.GIF.Image {
+		r := frame.Bounds().Sub(bounds.Min)
+		scaled := image.Rect(scale(r.Min.X, scaleX), scale(r.Min.Y, scaleY), scale(r.Max.X, scaleX), scale(r.Max.Y, scaleY))
+		// Keep every frame at least a pixel wide and on the scaled image.
+		if scaled.Dx() == 0 {
+			scaled.Max.X++
+		}
+		if scaled.Dy() == 0 {
+			scaled.Max.Y++
+		}
+		if scaled.Max.X > scaledCanvas.Max.X {
+			scaled = scaled.Sub(image.Pt(scaled.Max.X-scaledCanvas.Max.X, 0))
+		}
+		if scaled.Max.Y > scaledCanvas.Max.Y {
+			scaled = scaled.Sub(image.Pt(0, scaled.Max.Y-scaledCanvas.Max.Y))
+		}
+		scaled = scaled.Intersect(scaledCanvas).Add(g.Offset)
+
+		visible := scaled.Intersect(canvas)
+		if visible.Empty() && len(out.Image) > 0 {
+			out.Delay[len(out.Delay)-1] += a.GIF.Delay[i]
+			continue
+		} else if visible.Empty() {
+			// An animation starts with a frame, if only a pixel of one.
+			visible = image.Rect(0, 0, 1, 1).Add(canvas.Min)
+		}
+
+		var src image.Image = frame
+		resized := resizer.ResizeWith(uint(scaled.Dx()), uint(scaled.Dy()), &src, options)
+		paletted := image.NewPaletted(visible, frame.Palette)
+		draw.Draw(paletted, visible, *resized, (*resized).Bounds().Min.Add(visible.Min.Sub(scaled.Min)), draw.Src)
+		out.Image = append(out.Image, paletted)
+		out.Delay = append(out.Delay, a.GIF.Delay[i])
+		if len(a.GIF.Disposal) > i {
+			out.Disposal = append(out.Disposal, a.GIF.Disposal[i])
+		}
+	}
+
+	result = codec.NewAnimation(&out)
+	return &result
+}
+
+// cropAnimation cuts the part r out of every frame of an animation and
+// moves it to the origin of a canvas of the size of r. Frames share the
+// pixels of the original. The delay of a frame cropped away entirely is
+// added to the one before it.
+func cropAnimation(a *codec.Animation, r image.Rectangle) *image.Image {
+	out := *a.GIF
+	out.Config.Width, out.Config.Height = r.Dx(), r.Dy()
+	out.Image, out.Delay, out.Disposal = nil, nil, nil
+	for i, frame := range a.GIF.Image {
+		visible := frame.Bounds().Intersect(r)
+		if visible.Empty() && len(out.Image) > 0 {
+			out.Delay[len(out.Delay)-1] += a.GIF.Delay[i]
+			continue
+		} else if visible.Empty() {
+			// An animation starts with a frame, if only a pixel of one.
+			visible = image.Rect(0, 0, 1, 1).Add(r.Min)
+			frame = image.NewPaletted(visible, frame.Palette)
+		}
+
+		cropped := *frame.SubImage(visible).(*image.Paletted)
+		cropped.Rect = cropped.Rect.Sub(r.Min)
+		out.Image = append(out.Image, &cropped)
+		out.Delay = append(out.Delay, a.GIF.Delay[i])
+		if len(a.GIF.Disposal) > i {
+			out.Disposal = append(out.Disposal, a.GIF.Disposal[i])
+		}
+	}
+
+	var result image.Image = codec.NewAnimation(&out)
+	return &result
+}
+
+// extractFrame returns frame n of img, counting from 1, as a still image.
+// Still images have a single frame; n == 0 returns img unchanged.
+func extractFrame(img *image.Image, n int) (*image.Image, error) {
+	if n == 0 {
+		return img, nil
+	}
+
+	frames := 1
+	if a, ok := (*img).(*codec.Animation); ok {
+		if n <= a.Len() {
+			frame := a.Frame(n - 1)
+			return &frame, nil
+		}
+		frames = a.Len()
+	} else if n == 1 {
+		return img, nil
+	}
+	return nil, fmt.Errorf("%w: frame %d of an image with %d frames", errBadRequest, n-1, frames)
+}
+
+// mapPalettes maps the palette colors of every frame of an animation
+// through f, leaving the pixels alone.
+func mapPalettes(a *codec.Animation, f func(color.Color) color.Color) *image.Image {
+	out := *a.GIF
+	out.Image = make([]*image.Paletted, len(a.GIF.Image))
+	palettes := make(map[*color.Color]color.Palette)
+	for i, frame := range a.GIF.Image {
+		if len(frame.Palette) == 0 {
+			out.Image[i] = frame
+			continue
+		}
+		// Frames sharing a palette share the mapped one too.
+		key := &frame.Palette[0]
+		palette, ok := palettes[key]
+		if !ok {
+			palette = make(color.Palette, len(frame.Palette))
+			for j, c := range frame.Palette {
+				palette[j] = f(c)
+			}
+			palettes[key] = palette
+		}
+		mapped := *frame
+		mapped.Palette = palette
+		out.Image[i] = &mapped
+	}
+	var result image.Image = codec.NewAnimation(&out)
+	return &result
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"image/codec"
+)
+
+// encodeTestAnimation returns a 40x20 GIF whose frames are red, green and
+// blue, the last one covering only the right half of the canvas.
+func encodeTestAnimation(t *testing.T) []byte {
+	palette := color.Palette{color.Transparent, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}}
+	g := &gif.GIF{
+		Delay:     []int{10, 20, 30},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalNone},
+		LoopCount: 5,
+		Config:    image.Config{ColorModel: palette, Width: 40, Height: 20},
+	}
+	for i, r := range []image.Rectangle{image.Rect(0, 0, 40, 20), image.Rect(0, 0, 40, 20), image.Rect(20, 0, 40, 20)} {
+		frame := image.NewPaletted(r, palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i + 1)
+		}
+		g.Image = append(g.Image, frame)
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := gif.EncodeAll(buffer, g); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestResizeAnimation(t *testing.T) {
+	serveFromMemory(map[string][]byte{"anim.gif": encodeTestAnimation(t)})
+
+	w := httptest.NewRecorder()
+	makeHandler(imageHandler)(w, httptest.NewRequest("GET", "/anim.gif?w=20", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" {
+		t.Fatalf("status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	g, err := gif.DecodeAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Config.Width != 20 || g.Config.Height != 10 {
+		t.Errorf("canvas is %dx%d, want 20x10", g.Config.Width, g.Config.Height)
+	}
+	if len(g.Image) != 3 || g.LoopCount != 5 {
+		t.Fatalf("%d frames with loop count %d, want 3 frames with loop count 5", len(g.Image), g.LoopCount)
+	}
+	for i, delay := range []int{10, 20, 30} {
+		if g.Delay[i] != delay {
+			t.Errorf("frame %d delay = %d, want %d", i, g.Delay[i], delay)
+		}
+	}
+	if g.Disposal[1] != gif.DisposalBackground {
+		t.Errorf("frame 1 disposal = %d, want %d", g.Disposal[1], gif.DisposalBackground)
+	}
+	if r := g.Image[2].Bounds(); r != image.Rect(10, 0, 20, 10) {
+		t.Errorf("frame 2 covers %v, want the right half of the canvas", r)
+	}
+	if got := g.Image[2].At(15, 5); got != (color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("frame 2 is %v, want blue", got)
+	}
+}
+
+func TestExtractFrame(t *testing.T) {
+	serveFromMemory(map[string][]byte{
+		"anim.gif":  encodeTestAnimation(t),
+		"photo.jpg": encodeTestImage(t, codec.JPEG),
+	})
+
+	tests := []struct {
+		target      string
+		status      int
+		contentType string
+	}{
+		{"/anim.gif?frame=1", http.StatusOK, "image/png"},
+		{"/anim.gif?frame=2&fmt=jpeg", http.StatusOK, "image/jpeg"},
+		{"/anim.gif?frame=2&w=10", http.StatusOK, "image/png"},
+		{"/anim.gif?frame=3", http.StatusBadRequest, ""},
+		{"/anim.gif?frame=-1", http.StatusBadRequest, ""},
+		{"/anim.gif?frame=last", http.StatusBadRequest, ""},
+		{"/photo.jpg?frame=0", http.StatusOK, "image/jpeg"},
+		{"/photo.jpg?frame=1", http.StatusBadRequest, ""},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); test.status == http.StatusOK && got != test.contentType {
+			t.Errorf("GET %s: Content-Type = %q, want %q", test.target, got, test.contentType)
+		}
+	}
+
+	// Frame 2 draws blue over the right half of the cleared canvas.
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/anim.gif?frame=2", nil))
+	frame, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := frame.At(5, 5).RGBA(); a != 0 {
+		t.Errorf("cleared half of frame 2 is opaque")
+	}
+	if r, g, b, _ := frame.At(30, 5).RGBA(); r != 0 || g != 0 || b != 0xffff {
+		t.Errorf("right half of frame 2 is not blue")
+	}
+}
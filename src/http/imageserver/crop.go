@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"image/codec"
+	"image/resizer"
+)
+
+// maxCropPixels bounds the pixel values of a crop region, so its corners
+// can be computed without overflowing.
+const maxCropPixels = 1 << 24
+
+// cropRegion is a rectangle of the original given as x, y, width and
+// height. Each value is in pixels or, when marked, in percent of the width
+// or height of the original.
+type cropRegion struct {
+	pixels   [4]int
+	percents [4]float64
+	percent  [4]bool
+}
+
+// parseCrop reads a crop region such as "10,20,300,200" or
+// "10%,0,80%,100%".
+func parseCrop(value string) (*cropRegion, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("%w: crop %q is not x,y,w,h", errBadRequest, value)
+	}
+
+	c := &cropRegion{}
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		var ok bool
+		if strings.HasSuffix(part, "%") {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+			ok = err == nil && v >= 0 && v <= 100 && (i < 2 || v > 0)
+			c.percents[i], c.percent[i] = v, true
+		} else {
+			n, err := strconv.Atoi(part)
+			ok = err == nil && n >= 0 && n <= maxCropPixels && (i < 2 || n > 0)
+			c.pixels[i] = n
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid crop %q", errBadRequest, value)
+		}
+	}
+	return c, nil
+}
+
+// rect returns the region within bounds.
+func (c *cropRegion) rect(bounds image.Rectangle) image.Rectangle {
+	size := [4]int{bounds.Dx(), bounds.Dy(), bounds.Dx(), bounds.Dy()}
+	v := c.pixels
+	for i := range v {
+		if c.percent[i] {
+			v[i] = int(math.Round(c.percents[i] * float64(size[i]) / 100))
+		}
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]).Add(bounds.Min)
+}
+
+func (c *cropRegion) String() string {
+	parts := make([]string, 4)
+	for i := range parts {
+		if c.percent[i] {
+			parts[i] = strconv.FormatFloat(c.percents[i], 'g', -1, 64) + "%"
+		} else {
+			parts[i] = strconv.Itoa(c.pixels[i])
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// cropImage crops img to the region, clipped to the image. Animations are
+// cropped frame by frame.
+func cropImage(c *cropRegion, img *image.Image) (*image.Image, error) {
+	bounds := (*img).Bounds()
+	r := c.rect(bounds).Intersect(bounds)
+	if r.Empty() {
+		return nil, fmt.Errorf("%w: crop %v is outside the %dx%d image", errBadRequest, c, bounds.Dx(), bounds.Dy())
+	}
+	if r == bounds {
+		return img, nil
+	}
+	if a, ok := (*img).(*codec.Animation); ok {
+		return cropAnimation(a, r), nil
+	}
+	return resizer.Crop(img, r), nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCrop(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+	tests := []struct {
+		value string
+		want  image.Rectangle
+		ok    bool
+	}{
+		{"10,20,30,40", image.Rect(10, 20, 40, 60), true},
+		{" 0, 0, 200, 100 ", image.Rect(0, 0, 200, 100), true},
+		{"10%,10%,50%,50%", image.Rect(20, 10, 120, 60), true},
+		{"25%,0,12.5%,100%", image.Rect(50, 0, 75, 100), true},
+		{"10,20,30", image.Rectangle{}, false},
+		{"10,20,0,40", image.Rectangle{}, false},
+		{"-10,20,30,40", image.Rectangle{}, false},
+		{"10,20,30,101%", image.Rectangle{}, false},
+		{"1.5,20,30,40", image.Rectangle{}, false},
+		{"a,b,c,d", image.Rectangle{}, false},
+	}
+	for _, test := range tests {
+		c, err := parseCrop(test.value)
+		if (err == nil) != test.ok {
+			t.Errorf("parseCrop(%q) error = %v", test.value, err)
+			continue
+		}
+		if test.ok && c.rect(bounds) != test.want {
+			t.Errorf("parseCrop(%q) covers %v, want %v", test.value, c.rect(bounds), test.want)
+		}
+	}
+}
+
+func TestImageHandlerCrop(t *testing.T) {
+	// The left half of the image is black, the right half white.
+	halves := image.NewGray(image.Rect(0, 0, 60, 30))
+	for y := 0; y < 30; y++ {
+		for x := 30; x < 60; x++ {
+			halves.Pix[y*halves.Stride+x] = 0xff
+		}
+	}
+	buffer := new(bytes.Buffer)
+	png.Encode(buffer, halves)
+	serveFromMemory(map[string][]byte{"halves.png": buffer.Bytes(), "anim.gif": encodeTestAnimation(t)})
+
+	tests := []struct {
+		target string
+		status int
+		size   image.Point
+		// gray is the expected value of the middle pixel.
+		gray uint8
+	}{
+		{"/halves.png?crop=0,0,20,30", http.StatusOK, image.Pt(20, 30), 0},
+		{"/halves.png?crop=40,5,100,100", http.StatusOK, image.Pt(20, 25), 0xff},
+		{"/halves.png?crop=50%25,0,50%25,100%25", http.StatusOK, image.Pt(30, 30), 0xff},
+		{"/halves.png?crop=0,0,20,30&w=10", http.StatusOK, image.Pt(10, 15), 0},
+		{"/halves.png?w=30&h=30&fit=cover&gravity=west", http.StatusOK, image.Pt(30, 30), 0},
+		{"/halves.png?w=30&h=30&fit=cover&gravity=east", http.StatusOK, image.Pt(30, 30), 0xff},
+		{"/halves.png?crop=60,0,10,10", http.StatusBadRequest, image.Point{}, 0},
+		{"/halves.png?crop=0,0,10", http.StatusBadRequest, image.Point{}, 0},
+		{"/halves.png?crop=1,0,9223372036854775807,10", http.StatusBadRequest, image.Point{}, 0},
+		{"/halves.png?crop=0,0,10,16777217", http.StatusBadRequest, image.Point{}, 0},
+		{"/halves.png?crop=0,0,NaN%25,10", http.StatusBadRequest, image.Point{}, 0},
+		{"/halves.png?gravity=up", http.StatusBadRequest, image.Point{}, 0},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Errorf("GET %s: %v", test.target, err)
+			continue
+		}
+		b := img.Bounds()
+		if b.Size() != test.size {
+			t.Errorf("GET %s: size = %v, want %v", test.target, b.Size(), test.size)
+		}
+		middle := color.GrayModel.Convert(img.At(b.Min.X+b.Dx()/2, b.Min.Y+b.Dy()/2)).(color.Gray)
+		if d := int(middle.Y) - int(test.gray); d < -8 || d > 8 {
+			t.Errorf("GET %s: middle pixel = %d, want %d", test.target, middle.Y, test.gray)
+		}
+	}
+
+	// Cropping the right quarter keeps the blue frame, moved to the origin.
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/anim.gif?crop=30,0,10,20", nil))
+	g, err := gif.DecodeAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Config.Width != 10 || g.Config.Height != 20 || len(g.Image) != 3 {
+		t.Fatalf("cropped animation is %dx%d with %d frames", g.Config.Width, g.Config.Height, len(g.Image))
+	}
+	if r := g.Image[2].Bounds(); r != image.Rect(0, 0, 10, 20) {
+		t.Errorf("frame 2 covers %v, want the whole canvas", r)
+	}
+
+	// The left quarter lies outside the blue frame, whose delay moves to
+	// the frame before.
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/anim.gif?crop=0,0,10,20", nil))
+	if g, err = gif.DecodeAll(w.Body); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 2 || g.Delay[1] != 50 {
+		t.Errorf("%d frames with delays %v, want 2 frames with delays [10 50]", len(g.Image), g.Delay)
+	}
+}
+
+func TestImageHandlerSmartCrop(t *testing.T) {
+	// A patch of skin tone sits near the right edge of a gray image.
+	img := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for i := 0; i < len(img.Pix); i += 4 {
+		x := i / 4 % 300
+		if x >= 240 && x < 280 {
+			copy(img.Pix[i:], []uint8{224, 172, 138, 255})
+		} else {
+			copy(img.Pix[i:], []uint8{128, 128, 128, 255})
+		}
+	}
+	buffer := new(bytes.Buffer)
+	png.Encode(buffer, img)
+	serveFromMemory(map[string][]byte{"wide.png": buffer.Bytes()})
+
+	handler := makeHandler(imageHandler)
+	var crops []string
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/wide.png?w=50&h=50&fit=cover&gravity=smart", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d", w.Code)
+		}
+		crops = append(crops, w.Header().Get("X-Smart-Crop"))
+	}
+	var x, y, width, height int
+	if _, err := fmt.Sscanf(crops[0], "%d,%d,%d,%d", &x, &y, &width, &height); err != nil {
+		t.Fatalf("X-Smart-Crop = %q: %v", crops[0], err)
+	}
+	if x > 240 || x+width < 280 || y != 0 || width != 100 || height != 100 {
+		t.Errorf("X-Smart-Crop = %q, want a 100x100 region around the patch", crops[0])
+	}
+	if crops[1] != crops[0] {
+		t.Errorf("cached rendition reports X-Smart-Crop %q, want %q", crops[1], crops[0])
+	}
+	if _, _, _, hits, _ := renditionCache.Stats(); hits != 1 {
+		t.Errorf("rendition cache hits = %d, want 1", hits)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/wide.png?w=50&h=50&fit=cover", nil))
+	if got := w.Header().Get("X-Smart-Crop"); got != "" {
+		t.Errorf("center crop reports X-Smart-Crop %q", got)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"image/codec"
+	"image/resizer"
+)
+
+// sharpening is an unsharp mask: amount is the strength, radius the
+// standard deviation of its blur in pixels and threshold the smallest
+// difference, out of 255, that is sharpened.
+type sharpening struct {
+	amount, radius, threshold float64
+}
+
+// parseSharpen reads an unsharp mask given as amount[,radius[,threshold]].
+// The radius defaults to 1 and the threshold to 0.
+func parseSharpen(value string) (*sharpening, error) {
+	parts := strings.Split(value, ",")
+	s := &sharpening{radius: 1}
+	fields := []*float64{&s.amount, &s.radius, &s.threshold}
+	if len(parts) > len(fields) {
+		return nil, fmt.Errorf("%w: sharpen %q is not amount,radius,threshold", errBadRequest, value)
+	}
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(v) {
+			return nil, fmt.Errorf("%w: invalid sharpen %q", errBadRequest, value)
+		}
+		*fields[i] = v
+	}
+	if s.amount <= 0 || s.amount > 10 || s.radius <= 0 || s.radius > 50 || s.threshold < 0 || s.threshold > 255 {
+		return nil, fmt.Errorf("%w: sharpen %q is out of range", errBadRequest, value)
+	}
+	return s, nil
+}
+
+// String returns the canonical form of the mask, or "" for none.
+func (s *sharpening) String() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("%g,%g,%g", s.amount, s.radius, s.threshold)
+}
+
+// parseBlur reads the standard deviation of a Gaussian blur in pixels.
+func parseBlur(value string) (float64, error) {
+	sigma, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(sigma > 0 && sigma <= 50) {
+		return 0, fmt.Errorf("%w: blur %q is not between 0 and 50", errBadRequest, value)
+	}
+	return sigma, nil
+}
+
+// applyEffects runs the blur and sharpening of t on the resized image.
+// Every frame of an animation is filtered on its own and mapped back to
+// its palette.
+func applyEffects(t transformation, img *image.Image) *image.Image {
+	if t.blur == 0 && t.sharpen == nil {
+		return img
+	}
+	effects := func(img *image.Image) *image.Image {
+		img = resizer.Blur(img, t.blur)
+		if s := t.sharpen; s != nil {
+			img = resizer.Sharpen(img, s.amount, s.radius, s.threshold)
+		}
+		return img
+	}
+
+	a, ok := (*img).(*codec.Animation)
+	if !ok {
+		return effects(img)
+	}
+	out := *a.GIF
+	out.Image = make([]*image.Paletted, len(a.GIF.Image))
+	for i, frame := range a.GIF.Image {
+		var src image.Image = frame
+		filtered := effects(&src)
+		paletted := image.NewPaletted(frame.Bounds(), frame.Palette)
+		draw.Draw(paletted, paletted.Rect, *filtered, (*filtered).Bounds().Min, draw.Src)
+		out.Image[i] = paletted
+	}
+	var result image.Image = codec.NewAnimation(&out)
+	return &result
+}
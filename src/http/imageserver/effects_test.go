@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseSharpen(t *testing.T) {
+	tests := []struct {
+		value string
+		want  sharpening
+		ok    bool
+	}{
+		{"1.5", sharpening{amount: 1.5, radius: 1}, true},
+		{"2,0.8", sharpening{amount: 2, radius: 0.8}, true},
+		{"0.5, 2, 10", sharpening{amount: 0.5, radius: 2, threshold: 10}, true},
+		{"0", sharpening{}, false},
+		{"11", sharpening{}, false},
+		{"1,0", sharpening{}, false},
+		{"1,1,256", sharpening{}, false},
+		{"1,1,1,1", sharpening{}, false},
+		{"NaN", sharpening{}, false},
+		{"much", sharpening{}, false},
+	}
+	for _, test := range tests {
+		got, err := parseSharpen(test.value)
+		if (err == nil) != test.ok || test.ok && *got != test.want {
+			t.Errorf("parseSharpen(%q) = %v, %v", test.value, got, err)
+		}
+	}
+}
+
+func TestImageHandlerEffects(t *testing.T) {
+	// A step from dark gray to light gray in the middle.
+	step := image.NewGray(image.Rect(0, 0, 20, 4))
+	for i := range step.Pix {
+		step.Pix[i] = 60
+		if i%20 >= 10 {
+			step.Pix[i] = 190
+		}
+	}
+	buffer := new(bytes.Buffer)
+	png.Encode(buffer, step)
+	serveFromMemory(map[string][]byte{"step.png": buffer.Bytes(), "anim.gif": encodeTestAnimation(t)})
+
+	tests := []struct {
+		target string
+		status int
+		// left and right are the signs of the change of the pixels on
+		// either side of the step.
+		left, right int
+	}{
+		{"/step.png?fmt=png", http.StatusOK, 0, 0},
+		{"/step.png?blur=2", http.StatusOK, 1, -1},
+		{"/step.png?sharpen=1", http.StatusOK, -1, 1},
+		{"/step.png?sharpen=1,1,200", http.StatusOK, 0, 0},
+		{"/step.png?blur=0", http.StatusBadRequest, 0, 0},
+		{"/step.png?blur=51", http.StatusBadRequest, 0, 0},
+		{"/step.png?sharpen=1,x", http.StatusBadRequest, 0, 0},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		left := color.GrayModel.Convert(img.At(9, 2)).(color.Gray).Y
+		right := color.GrayModel.Convert(img.At(10, 2)).(color.Gray).Y
+		if sign(int(left)-60) != test.left || sign(int(right)-190) != test.right {
+			t.Errorf("GET %s: step from 60 to 190 changed to %d to %d", test.target, left, right)
+		}
+	}
+
+	// Every frame of an animation is blurred into its own palette.
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/anim.gif?blur=1", nil))
+	g, err := gif.DecodeAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, _ := gif.DecodeAll(bytes.NewReader(encodeTestAnimation(t)))
+	if len(g.Image) != len(original.Image) {
+		t.Fatalf("blurred animation has %d frames, want %d", len(g.Image), len(original.Image))
+	}
+	for i, frame := range g.Image {
+		if frame.Bounds() != original.Image[i].Bounds() {
+			t.Errorf("frame %d covers %v, want %v", i, frame.Bounds(), original.Image[i].Bounds())
+		}
+	}
+
+	if (transformation{blur: 1}).key() == (transformation{}).key() {
+		t.Error("the blur is missing from the key")
+	}
+	if (transformation{sharpen: &sharpening{amount: 1, radius: 1}}).key() == (transformation{}).key() {
+		t.Error("the sharpening is missing from the key")
+	}
+}
+
+func sign(v int) int {
+	switch {
+	case v < 0:
+		return -1
+	case v > 0:
+		return 1
+	}
+	return 0
+}
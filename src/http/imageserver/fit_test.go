@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"image/resizer"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		value string
+		want  color.NRGBA
+		ok    bool
+	}{
+		{"ff0000", color.NRGBA{R: 0xff, A: 0xff}, true},
+		{"#00FF0080", color.NRGBA{G: 0xff, A: 0x80}, true},
+		{"fff", color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, true},
+		{"#0008", color.NRGBA{A: 0x88}, true},
+		{"transparent", color.NRGBA{}, true},
+		{"red", color.NRGBA{}, false},
+		{"ff00", color.NRGBA{R: 0xff, G: 0xff}, true},
+		{"ff000", color.NRGBA{}, false},
+		{"#gg0000", color.NRGBA{}, false},
+	}
+	for _, test := range tests {
+		got, err := parseColor(test.value)
+		if (err == nil) != test.ok || test.ok && got != test.want {
+			t.Errorf("parseColor(%q) = %v, %v", test.value, got, err)
+		}
+	}
+}
+
+func TestImageHandlerFit(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	landscape := image.NewNRGBA(image.Rect(0, 0, 60, 30))
+	for i := range landscape.Pix {
+		landscape.Pix[i] = 0xff
+	}
+	png.Encode(buffer, landscape)
+	serveFromMemory(map[string][]byte{"wide.png": buffer.Bytes(), "anim.gif": encodeTestAnimation(t)})
+
+	tests := []struct {
+		target string
+		status int
+		size   image.Point
+	}{
+		{"/wide.png?w=40&h=40", http.StatusOK, image.Pt(40, 40)},
+		{"/wide.png?w=40&h=40&fit=fill", http.StatusOK, image.Pt(40, 40)},
+		{"/wide.png?w=40&h=40&fit=contain", http.StatusOK, image.Pt(40, 40)},
+		{"/wide.png?w=40&h=40&fit=cover", http.StatusOK, image.Pt(40, 40)},
+		{"/wide.png?w=40&h=40&fit=inside", http.StatusOK, image.Pt(40, 20)},
+		{"/wide.png?w=40&h=40&fit=outside", http.StatusOK, image.Pt(80, 40)},
+		{"/wide.png?w=120&h=120&fit=inside", http.StatusOK, image.Pt(60, 30)},
+		{"/wide.png?w=20&fit=COVER", http.StatusOK, image.Pt(20, 10)},
+		{"/anim.gif?w=20&h=20&fit=contain", http.StatusOK, image.Pt(20, 20)},
+		{"/anim.gif?w=20&h=20&fit=cover", http.StatusOK, image.Pt(20, 20)},
+		{"/wide.png?w=40&h=40&fit=stretch", http.StatusBadRequest, image.Point{}},
+		{"/wide.png?w=40&h=40&fit=contain&bg=blue", http.StatusBadRequest, image.Point{}},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		config, _, err := image.DecodeConfig(w.Body)
+		if err != nil {
+			t.Errorf("GET %s: %v", test.target, err)
+		} else if size := image.Pt(config.Width, config.Height); size != test.size {
+			t.Errorf("GET %s: size = %v, want %v", test.target, size, test.size)
+		}
+	}
+
+	// The white image is letterboxed in red.
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/wide.png?w=40&h=40&fit=contain&bg=f00", nil))
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := color.NRGBAModel.Convert(img.At(20, 2)); got != (color.NRGBA{R: 0xff, A: 0xff}) {
+		t.Errorf("padding is %v, want red", got)
+	}
+	if got := color.NRGBAModel.Convert(img.At(20, 20)); got != (color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}) {
+		t.Errorf("image is %v, want white", got)
+	}
+
+	// The frame covering the right half of the animation moves into the
+	// letterbox.
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/anim.gif?w=20&h=20&fit=contain", nil))
+	g, err := gif.DecodeAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := g.Image[2].Bounds(); r != image.Rect(10, 5, 20, 15) {
+		t.Errorf("frame 2 covers %v, want the right half of the letterboxed image", r)
+	}
+
+	if (transformation{fit: resizer.Fill}).key() == (transformation{fit: resizer.Cover}).key() {
+		t.Error("the fit mode is missing from the key")
+	}
+}
+
+func TestImageHandlerFilter(t *testing.T) {
+	pair := image.NewGray(image.Rect(0, 0, 2, 1))
+	pair.Pix[1] = 0xff
+	buffer := new(bytes.Buffer)
+	png.Encode(buffer, pair)
+	serveFromMemory(map[string][]byte{"pair.png": buffer.Bytes()})
+
+	tests := []struct {
+		target string
+		status int
+		// want is the gray level of the four pixels of the upscaled pair.
+		want [4]uint8
+	}{
+		{"/pair.png?w=4&h=1&filter=nearest", http.StatusOK, [4]uint8{0, 0, 0xff, 0xff}},
+		{"/pair.png?w=4&h=1&filter=box", http.StatusOK, [4]uint8{0, 0, 0xff, 0xff}},
+		{"/pair.png?w=4&h=1&filter=bilinear", http.StatusOK, [4]uint8{0, 63, 191, 0xff}},
+		{"/pair.png?w=4&h=1&filter=lanczos5", http.StatusBadRequest, [4]uint8{}},
+		// In linear light the blend of black and white looks as light as
+		// the mix of the two.
+		{"/pair.png?w=4&h=1&filter=bilinear&linear=1", http.StatusOK, [4]uint8{0, 137, 225, 0xff}},
+		{"/pair.png?w=4&h=1&filter=bilinear&linear=false", http.StatusOK, [4]uint8{0, 63, 191, 0xff}},
+		{"/pair.png?w=4&h=1&linear=maybe", http.StatusBadRequest, [4]uint8{}},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x, want := range test.want {
+			got := color.GrayModel.Convert(img.At(x, 0)).(color.Gray).Y
+			if d := int(got) - int(want); d < -1 || d > 1 {
+				t.Errorf("GET %s: pixel %d = %d, want %d", test.target, x, got, want)
+			}
+		}
+	}
+
+	if (transformation{filter: resizer.Nearest}).key() == (transformation{}).key() {
+		t.Error("the filter is missing from the key")
+	}
+	if (transformation{linear: true}).key() == (transformation{}).key() {
+		t.Error("linear light is missing from the key")
+	}
+}
@@ -2,27 +2,36 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
-	"io"
 	"log"
+	"net"
 	"net/http"
-	"os"
 	"regexp"
 	"runtime"
 	"runtime/debug"
-	"strconv"
 	"strings"
 	"time"
 
 	"cache"
-	"image/resizer"
+	"cache/singleflight"
+	"image/codec"
 	"warehouse/reader"
-
-	"image/jpeg"
-	"image/png"
+	"warehouse/storage"
 )
 
+var cacheSize = flag.Int64("cache-size", cache.DefaultCapacity, "memory budget of the image cache in bytes")
+var storageSpec = flag.String("storage", reader.Warehouse, "where originals are read from: a warehouse directory, zip:archive.zip, tar:archive.tar, s3:bucket/prefix or an upstream URL template such as https://example.com/{name}")
+var extensions = flag.String("extensions", strings.Join(storage.DefaultExtensions, ","), "comma-separated file extensions that may be served")
+var originHosts = flag.String("origin-hosts", "", "comma-separated upstream hosts an URL template storage may fetch from")
+var originTimeout = flag.Duration("origin-timeout", 10*time.Second, "timeout of upstream requests")
+var originMaxSize = flag.Int64("origin-max-size", 64<<20, "largest upstream original in bytes")
+var originMaxAge = flag.Duration("origin-max-age", 5*time.Minute, "how long a fetched original is used before it is revalidated upstream")
+var renditionCacheSize = flag.Int64("rendition-cache-size", cache.DefaultRenditionCapacity, "memory budget of the rendition cache in bytes")
+
 var queryCount int
 var failedQueryCount int
 var startTime = time.Now()
@@ -34,81 +43,223 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Start time: %v\n", startTime)
 	fmt.Fprintf(w, "Running time: %v\n", time.Since(startTime))
 
+	images, used, budget := imgCache.Stats()
+	fmt.Fprintf(w, "Cached images: %v\n", images)
+	fmt.Fprintf(w, "Image cache: %v of %v bytes used\n", used, budget)
+
+	renditions, used, budget, hits, misses := renditionCache.Stats()
+	fmt.Fprintf(w, "Cached renditions: %v\n", renditions)
+	fmt.Fprintf(w, "Rendition cache: %v of %v bytes used\n", used, budget)
+	fmt.Fprintf(w, "Rendition cache hits: %v, misses: %v\n", hits, misses)
+
 	debug.FreeOSMemory()
 }
 
 var imgCache *cache.Cache
+var renditionCache *cache.RenditionCache
 
 func imageHandler(w http.ResponseWriter, r *http.Request, filename string) {
 	defer timeTrack(time.Now(), filename)
 
-	image := getImageByName(filename)
-	if image == nil {
-		failedQueryCount++
-		http.NotFound(w, r)
-		return
+	w.Header().Set("Vary", "Accept")
+
+	t, err := parseTransformation(r, filename)
+	if err == nil {
+		var rendition *cache.Rendition
+		rendition, err = getRendition(t)
+		if err == nil {
+			queryCount++
+			for name, values := range rendition.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Content-Type", rendition.ContentType)
+			http.ServeContent(w, r, filename, startTime, bytes.NewReader(rendition.Data))
+			return
+		}
 	}
 
-	width, _ := strconv.Atoi(r.URL.Query().Get("w"))
-	height, _ := strconv.Atoi(r.URL.Query().Get("h"))
+	log.Println(err)
+	failedQueryCount++
+	status := errorStatus(err)
+	http.Error(w, http.StatusText(status), status)
+}
 
-	if width != 0 || height != 0 {
-		image = resizer.Resize(uint(width), uint(height), image)
+// errorStatus maps an error to the HTTP status reported to the client.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, errBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, codec.ErrUnsupported):
+		return http.StatusUnsupportedMediaType
+	case timeout(err):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, storage.ErrTooLarge), errors.Is(err, storage.ErrUpstream):
+		return http.StatusBadGateway
+	case errors.Is(err, reader.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, reader.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, reader.ErrUnsupportedFormat):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, reader.ErrCorrupt):
+		return http.StatusUnprocessableEntity
 	}
+	return http.StatusInternalServerError
+}
+
+// timeout reports whether err is a deadline expiring, such as the timeout
+// of an upstream request.
+func timeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// store holds the originals served by the imageserver.
+var store storage.Storage
+
+// decode reads an original image from the store unless it still matches
+// the cached original, turning it upright when orient is set.
+var decode = func(filename string, cached *storage.Object, orient bool) (*image.Image, string, *storage.Object, error) {
+	return reader.DecodeIfModified(store, filename, cached, orient)
+}
 
-	file := writeImage(w, image, filename)
+var decodeGroup singleflight.Group
+var renditionGroup singleflight.Group
+
+// getRendition returns the encoded rendition of transformation t. Concurrent
+// misses for the same rendition wait on a single computation.
+func getRendition(t transformation) (*cache.Rendition, error) {
+	key := t.key()
+	if rendition := renditionCache.Get(key); rendition != nil {
+		return rendition, nil
+	}
+
+	rendition, err := renditionGroup.Do(key, func() (interface{}, error) {
+		defer debug.FreeOSMemory()
+
+		original, err := getImageByName(t.filename, !t.ignoreOrientation)
+		if err != nil {
+			return (*cache.Rendition)(nil), err
+		}
+
+		image, err := extractFrame(original.Image, t.frame)
+		if err != nil {
+			return (*cache.Rendition)(nil), err
+		}
+		image = reorient(t.orientation, image)
+		if t.crop != nil {
+			if image, err = cropImage(t.crop, image); err != nil {
+				return (*cache.Rendition)(nil), err
+			}
+		}
+		var header http.Header
+		if t.resizes() {
+			image, header = resize(t, image)
+		}
+		if image, err = rotate(t, image); err != nil {
+			return (*cache.Rendition)(nil), err
+		}
+		image = adjust(t, image)
+		image = recolor(t, image)
+		image = applyEffects(t, image)
+
+		format := t.format
+		if format == "" {
+			format = chooseFormat(t.accepted, originalFormat(t, original.Format))
+		}
+		data, err := writeImage(image, format, encodeOptions(t, *image))
+		if err != nil {
+			return (*cache.Rendition)(nil), err
+		}
 
-	defer debug.FreeOSMemory()
-	queryCount++
+		rendition := &cache.Rendition{Data: data, ContentType: codec.ContentType(format), Header: header}
+		renditionCache.Set(key, rendition)
+		return rendition, nil
+	})
 
-	http.ServeContent(w, r, filename, startTime, file)
+	return rendition.(*cache.Rendition), err
 }
 
-func getImageByName(filename string) *image.Image {
-	image := imgCache.Get(filename)
-	if image == nil {
-		image = reader.Decode(filename)
-		if image == nil {
-			return nil
+// getImageByName returns the decoded original, reading it from the
+// store on a cache miss. Originals fetched from an upstream origin are
+// revalidated once they are older than -origin-max-age. Concurrent misses
+// for the same file wait on a single decode. Unless orient is set, the
+// original is cached as stored, apart from its upright version.
+func getImageByName(filename string, orient bool) (*cache.Entry, error) {
+	key := filename
+	if !orient {
+		key += "?orient=none"
+	}
+	cached := imgCache.GetEntry(key)
+	if cached != nil && (cached.Source == nil || time.Since(cached.Validated) < *originMaxAge) {
+		return cached, nil
+	}
+
+	var source *storage.Object
+	if cached != nil {
+		source = cached.Source
+	}
+
+	v, err := decodeGroup.Do(key, func() (interface{}, error) {
+		image, format, object, err := decode(filename, source, orient)
+		if err == storage.ErrNotModified && cached != nil {
+			entry := &cache.Entry{Image: cached.Image, Format: cached.Format, Source: source, Validated: time.Now()}
+			imgCache.SetEntry(key, entry)
+			return entry, nil
+		}
+		if err != nil {
+			return nil, err
 		}
-		imgCache.Set(filename, image)
+		entry := &cache.Entry{Image: image, Format: format, Source: object, Validated: time.Now()}
+		imgCache.SetEntry(key, entry)
+		return entry, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return image
+	return v.(*cache.Entry), nil
 }
 
-// writeImage encodes an image 'img' in jpeg format and writes it into ResponseWriter.
-func writeImage(w http.ResponseWriter, img *image.Image, filename string) io.ReadSeeker {
+// writeImage encodes an image 'img' in the given format.
+func writeImage(img *image.Image, format string, options *codec.Options) ([]byte, error) {
 	buffer := new(bytes.Buffer)
 
-	if strings.HasSuffix(filename, ".jpg") {
-		if err := jpeg.Encode(buffer, *img, nil); err != nil {
-			log.Println("unable to encode image.")
-			failedQueryCount++
+	if err := codec.Encode(buffer, *img, format, options); err != nil {
+		if err == codec.ErrUnsupported {
+			return nil, fmt.Errorf("%w: %s", err, format)
 		}
-	} else if strings.HasSuffix(filename, ".png") {
-		if err := png.Encode(buffer, *img); err != nil {
-			log.Println("unable to encode image.")
-			failedQueryCount++
-		}
-	} else {
-		log.Println("Unknown file extension")
+		return nil, fmt.Errorf("unable to encode image: %v", err)
 	}
 
-	return bytes.NewReader(buffer.Bytes())
+	return buffer.Bytes(), nil
 }
 
 var validPath = regexp.MustCompile("^/(.*)$")
 
+// makeHandler confines the requested path to the storage root before
+// passing it to fn. Malformed and traversing paths are answered with 400,
+// hidden files and disallowed extensions with 404.
 func makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		m := validPath.FindStringSubmatch(r.URL.Path)
 		if m == nil {
 			failedQueryCount++
-			http.NotFound(w, r)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 			return
 		}
-		fn(w, r, m[1])
+
+		filename, err := storage.CleanName(m[1], strings.Split(*extensions, ","))
+		if err != nil {
+			failedQueryCount++
+			status := http.StatusNotFound
+			if err == storage.ErrInvalidName {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		fn(w, r, filename)
 	}
 }
 
@@ -119,17 +270,59 @@ func initialize() {
 	runtime.GOMAXPROCS(cpus)
 	log.Printf("IMAGESERVER: Setting GOMAXPROCS=%v", cpus)
 
-	imgCache = cache.New()
+	var err error
+	store, err = openStorage(*storageSpec)
+	if err != nil {
+		log.Fatalf("IMAGESERVER: Unable to open storage %q: %v", *storageSpec, err)
+	}
+	log.Printf("IMAGESERVER: Serving originals from %q", *storageSpec)
+
+	imgCache = cache.New(*cacheSize)
+	log.Printf("IMAGESERVER: Image cache budget is %v bytes", *cacheSize)
+
+	renditionCache = cache.NewRenditionCache(*renditionCacheSize)
+	if _, ok := store.(storage.ConditionalStorage); ok {
+		renditionCache.SetMaxAge(*originMaxAge)
+	}
+	log.Printf("IMAGESERVER: Rendition cache budget is %v bytes", *renditionCacheSize)
+
+	if *jpegQuality < 1 || *jpegQuality > 100 {
+		log.Fatalf("IMAGESERVER: JPEG quality %v is not between 1 and 100", *jpegQuality)
+	}
+	qualityClasses, err = parseQualityClasses(*jpegQualityClasses)
+	if err != nil {
+		log.Fatalf("IMAGESERVER: Invalid -jpeg-quality-classes: %v", err)
+	}
+	if _, ok := codec.CompressionLevel(*pngCompression); !ok {
+		log.Fatalf("IMAGESERVER: Unknown PNG compression %q", *pngCompression)
+	}
 
 	log.Printf("IMAGESERVER INITIALIZATION FINISHED")
 }
 
-func startServer() {
-	argsWithProg := os.Args
+// openStorage opens the storage described by spec. URL templates make the
+// imageserver a resizing proxy in front of an upstream origin.
+func openStorage(spec string) (storage.Storage, error) {
+	if !strings.HasPrefix(spec, "http://") && !strings.HasPrefix(spec, "https://") {
+		return storage.New(spec)
+	}
+
+	var hosts []string
+	if *originHosts != "" {
+		hosts = strings.Split(*originHosts, ",")
+	}
+	return storage.NewOrigin(storage.OriginConfig{
+		URLTemplate:  spec,
+		AllowedHosts: hosts,
+		Timeout:      *originTimeout,
+		MaxSize:      *originMaxSize,
+	})
+}
 
+func startServer() {
 	port := "8080"
-	if len(argsWithProg) > 1 {
-		port = argsWithProg[1]
+	if flag.NArg() > 0 {
+		port = flag.Arg(0)
 	}
 
 	defer listenAndServe(port)
@@ -149,6 +342,7 @@ func timeTrack(start time.Time, filename string) {
 }
 
 func main() {
+	flag.Parse()
 	initialize()
 	startServer()
 }
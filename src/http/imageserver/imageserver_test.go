@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/codec"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cache"
+	"cache/singleflight"
+	"warehouse/reader"
+	"warehouse/storage"
+)
+
+// stubDecode replaces the warehouse decoder with one that counts its calls
+// and holds every decode until release is closed, so concurrent requests
+// overlap. A nil img makes every decode fail with reader.ErrNotFound.
+func stubDecode(img image.Image) (calls *int32, release chan struct{}) {
+	calls, release = new(int32), make(chan struct{})
+	resetCaches()
+	decode = func(filename string, cached *storage.Object, orient bool) (*image.Image, string, *storage.Object, error) {
+		atomic.AddInt32(calls, 1)
+		<-release
+		if img == nil {
+			return nil, "", nil, &reader.Error{Filename: filename, Kind: reader.ErrNotFound, Err: errors.New("stub")}
+		}
+		return &img, "png", nil, nil
+	}
+	return calls, release
+}
+
+// runShared runs do n times at once, closes release as soon as the other
+// n-1 callers wait on the in-flight call for key in group and returns
+// when all of them are done.
+func runShared(n int, group *singleflight.Group, key string, release chan struct{}, do func(i int)) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			do(i)
+		}(i)
+	}
+	for group.Dups(key) < n-1 {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+}
+
+func resetCaches() {
+	imgCache = cache.New(cache.DefaultCapacity)
+	renditionCache = cache.NewRenditionCache(cache.DefaultRenditionCapacity)
+}
+
+func TestGetImageByNameDecodesOnce(t *testing.T) {
+	calls, release := stubDecode(image.NewRGBA(image.Rect(0, 0, 64, 48)))
+
+	runShared(64, &decodeGroup, "photo.png", release, func(int) {
+		if original, err := getImageByName("photo.png", true); original == nil || err != nil {
+			t.Errorf("getImageByName returned %v, %v", original, err)
+		}
+	})
+
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("decode ran %d times, want 1", n)
+	}
+}
+
+func TestGetRenditionResizesOnce(t *testing.T) {
+	calls, release := stubDecode(image.NewRGBA(image.Rect(0, 0, 64, 48)))
+	tr := transformation{filename: "photo.png", width: 16, format: "png"}
+
+	results := make([][]byte, 64)
+	runShared(len(results), &renditionGroup, tr.key(), release, func(i int) {
+		data, err := getRendition(tr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		results[i] = data.Data
+	})
+
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("decode ran %d times, want 1", n)
+	}
+	for i := range results {
+		if len(results[i]) == 0 || &results[i][0] != &results[0][0] {
+			t.Fatalf("request %d did not share the single rendition", i)
+		}
+	}
+}
+
+func TestGetRenditionSharesError(t *testing.T) {
+	calls, release := stubDecode(nil)
+	tr := transformation{filename: "missing.png", format: "png"}
+
+	runShared(32, &renditionGroup, tr.key(), release, func(int) {
+		if _, err := getRendition(tr); !errors.Is(err, reader.ErrNotFound) {
+			t.Errorf("getRendition error = %v, want %v", err, reader.ErrNotFound)
+		}
+	})
+
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("decode ran %d times, want 1", n)
+	}
+}
+
+func TestImageHandlerErrorStatus(t *testing.T) {
+	tests := []struct {
+		kind   error
+		status int
+	}{
+		{reader.ErrNotFound, http.StatusNotFound},
+		{reader.ErrPermission, http.StatusForbidden},
+		{reader.ErrUnsupportedFormat, http.StatusUnsupportedMediaType},
+		{reader.ErrCorrupt, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		resetCaches()
+		kind := test.kind
+		decode = func(filename string, cached *storage.Object, orient bool) (*image.Image, string, *storage.Object, error) {
+			return nil, "", nil, &reader.Error{Filename: filename, Kind: kind, Err: errors.New("stub")}
+		}
+
+		w := httptest.NewRecorder()
+		imageHandler(w, httptest.NewRequest("GET", "/broken.jpg", nil), "broken.jpg")
+		if w.Code != test.status {
+			t.Errorf("%v: status = %d, want %d", kind, w.Code, test.status)
+		}
+		if body := strings.TrimSpace(w.Body.String()); body != http.StatusText(test.status) {
+			t.Errorf("%v: body = %q, want %q", kind, body, http.StatusText(test.status))
+		}
+	}
+}
+
+func TestGetImageByNameRevalidatesOrigin(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	png.Encode(buffer, image.NewGray(image.Rect(0, 0, 8, 8)))
+	var fetches, notModified int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(buffer.Bytes())
+	}))
+	defer upstream.Close()
+
+	serveFromOrigin(t, upstream, time.Second)
+	maxAge := *originMaxAge
+	defer func() { *originMaxAge = maxAge }()
+	*originMaxAge = 0
+
+	first, err := getImageByName("photo.png", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := getImageByName("photo.png", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fetches != 2 || notModified != 1 {
+		t.Errorf("upstream saw %d requests, %d not modified; want 2 and 1", fetches, notModified)
+	}
+	if first.Image != second.Image {
+		t.Error("revalidated original was decoded again")
+	}
+}
+
+// serveFromOrigin makes the server read its originals from upstream,
+// giving up on requests after timeout.
+func serveFromOrigin(t *testing.T, upstream *httptest.Server, timeout time.Duration) {
+	origin, err := storage.NewOrigin(storage.OriginConfig{
+		URLTemplate:  upstream.URL + "/{name}",
+		AllowedHosts: []string{"127.0.0.1"},
+		Timeout:      timeout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resetCaches()
+	store = origin
+	decode = func(filename string, cached *storage.Object, orient bool) (*image.Image, string, *storage.Object, error) {
+		return reader.DecodeIfModified(store, filename, cached, orient)
+	}
+}
+
+func TestImageHandlerUpstreamErrors(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unavailable.png":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/hanging.png":
+			<-release
+		case "/stalling.png":
+			w.Write([]byte("\x89PNG\r\n\x1a\n"))
+			w.(http.Flusher).Flush()
+			<-release
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+	serveFromOrigin(t, upstream, 50*time.Millisecond)
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/missing.png", http.StatusNotFound},
+		{"/unavailable.png", http.StatusBadGateway},
+		{"/hanging.png", http.StatusGatewayTimeout},
+		{"/stalling.png", http.StatusGatewayTimeout},
+	}
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+		}
+	}
+}
+
+// serveFromMemory makes the server read its originals from an in-memory
+// storage holding files.
+func serveFromMemory(files map[string][]byte) {
+	memory := storage.NewMemory()
+	for name, data := range files {
+		memory.Put(name, data, time.Now())
+	}
+	resetCaches()
+	store = memory
+	decode = func(filename string, cached *storage.Object, orient bool) (*image.Image, string, *storage.Object, error) {
+		return reader.DecodeIfModified(store, filename, cached, orient)
+	}
+}
+
+func encodeTestImage(t *testing.T, format string) []byte {
+	buffer := new(bytes.Buffer)
+	if err := codec.Encode(buffer, image.NewGray(image.Rect(0, 0, 8, 8)), format, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestHostilePaths(t *testing.T) {
+	data := encodeTestImage(t, codec.PNG)
+	serveFromMemory(map[string][]byte{
+		"photo.png":        data,
+		"folder/photo.png": data,
+		".secret.png":      data,
+		"notes.txt":        []byte("text"),
+	})
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/photo.png", http.StatusOK},
+		{"/folder/photo.png", http.StatusOK},
+		{"/folder/./photo.png", http.StatusOK},
+		{"/missing.png", http.StatusNotFound},
+		{"/../../etc/passwd", http.StatusBadRequest},
+		{"/folder/../../photo.png", http.StatusBadRequest},
+		{"/%2e%2e/%2e%2e/etc/passwd", http.StatusBadRequest},
+		{"/%252e%252e/etc/passwd", http.StatusBadRequest},
+		{"/..%2f..%2fetc%2fpasswd", http.StatusBadRequest},
+		{"/..%5c..%5cwindows%5cwin.ini", http.StatusBadRequest},
+		{"/photo.png%00.txt", http.StatusBadRequest},
+		{"/.secret.png", http.StatusNotFound},
+		{"/folder/.git/config", http.StatusNotFound},
+		{"/notes.txt", http.StatusNotFound},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+		}
+		if test.status != http.StatusOK && strings.TrimSpace(w.Body.String()) != http.StatusText(test.status) {
+			t.Errorf("GET %s: body = %q", test.target, w.Body.String())
+		}
+	}
+}
+
+func TestOutputFormatFollowsContent(t *testing.T) {
+	jpg := encodeTestImage(t, codec.JPEG)
+	serveFromMemory(map[string][]byte{
+		"photo.jpeg":    jpg,
+		"photo.JPG":     jpg,
+		"photo":         jpg,
+		"disguised.png": jpg,
+		"icon.gif":      encodeTestImage(t, codec.GIF),
+		"photo.webp":    encodeTestImage(t, codec.WEBP),
+	})
+
+	tests := []struct {
+		target      string
+		status      int
+		contentType string
+	}{
+		{"/photo.jpeg", http.StatusOK, "image/jpeg"},
+		{"/photo.JPG", http.StatusOK, "image/jpeg"},
+		{"/photo", http.StatusOK, "image/jpeg"},
+		{"/disguised.png", http.StatusOK, "image/jpeg"},
+		{"/icon.gif", http.StatusOK, "image/gif"},
+		{"/photo?fmt=png", http.StatusOK, "image/png"},
+		{"/photo.jpeg?fmt=GIF&w=4", http.StatusOK, "image/gif"},
+		{"/icon.gif?fmt=jpg", http.StatusOK, "image/jpeg"},
+		{"/photo?fmt=webp&w=4", http.StatusOK, "image/webp"},
+		{"/photo.webp", http.StatusOK, "image/webp"},
+		{"/photo.jpeg?fmt=bmp", http.StatusUnsupportedMediaType, ""},
+		{"/photo.jpeg?fmt=../../x", http.StatusUnsupportedMediaType, ""},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("GET %s: Content-Type = %q, want %q", test.target, got, test.contentType)
+		}
+		if sniffed := http.DetectContentType(w.Body.Bytes()); sniffed != test.contentType {
+			t.Errorf("GET %s: body is %s, want %s", test.target, sniffed, test.contentType)
+		}
+	}
+}
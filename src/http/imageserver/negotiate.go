@@ -0,0 +1,119 @@
+package main
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+
+	"image/codec"
+)
+
+// acceptRange is a media range of an Accept header.
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses the media ranges of an Accept header, ignoring
+// malformed ones.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+	return ranges
+}
+
+// preference returns the quality the ranges assign to contentType and how
+// specific the matching range is: 2 for an exact type, 1 for image/*, 0
+// for */*, -1 when nothing matches.
+func preference(ranges []acceptRange, contentType string) (q float64, specificity int) {
+	specificity = -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.mediaType == contentType:
+			s = 2
+		case strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(r.mediaType, "*")):
+			s = 1
+		case r.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q, specificity
+}
+
+// negotiateFormats returns the output formats the client prefers most
+// according to its Accept header, in server preference order. Formats the
+// client names explicitly win over those only matched by a wildcard at the
+// same quality. It returns nil when the client has no preference among the
+// supported formats, so the rendition can keep the format of the original.
+func negotiateFormats(accept string) []string {
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	all := codec.Formats()
+	var best []string
+	bestQ, bestSpecificity := 0.0, -1
+	for _, format := range all {
+		q, specificity := preference(ranges, codec.ContentType(format))
+		if q <= 0 {
+			continue
+		}
+		if q > bestQ || q == bestQ && specificity > bestSpecificity {
+			best, bestQ, bestSpecificity = nil, q, specificity
+		}
+		if q == bestQ && specificity == bestSpecificity {
+			best = append(best, format)
+		}
+	}
+
+	if len(best) == 0 || len(best) == len(all) {
+		return nil
+	}
+	return best
+}
+
+// chooseFormat picks the output format among the negotiated ones, keeping
+// the format of the original when the client accepts it. WebP output is
+// lossless, many times larger and slower to encode than JPEG, so JPEG
+// originals are only served as WebP when ?fmt=webp asks for it.
+func chooseFormat(negotiated []string, original string) string {
+	choice := original
+	for i := len(negotiated) - 1; i >= 0; i-- {
+		format := negotiated[i]
+		if format == original {
+			return format
+		}
+		if original != codec.JPEG || format != codec.WEBP {
+			choice = format
+		}
+	}
+	return choice
+}
+
+// originalFormat is the format a rendition of an original in format falls
+// back to when no other format is requested or negotiated. Frames
+// extracted from a GIF and originals in formats the imageserver cannot
+// encode, such as BMP and TIFF, are served as PNG.
+func originalFormat(t transformation, format string) string {
+	if t.frame > 0 && format == codec.GIF || codec.ContentType(format) == "" {
+		return codec.PNG
+	}
+	return format
+}
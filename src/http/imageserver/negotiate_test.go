@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"image/codec"
+)
+
+func TestNegotiateFormats(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"*/*", ""},
+		{"image/*", ""},
+		{"text/html", ""},
+		{"image/png", "png"},
+		{"image/png;q=0.5, image/gif;q=0.9", "gif"},
+		{"image/png, */*;q=0.8", "png"},
+		{"image/png, image/*", "png"},
+		{"image/gif, image/png", "png|gif"},
+		{"image/jpeg;q=0, */*", "png|gif|webp"},
+		{"image/jpeg;q=0, image/png;q=0, image/gif;q=0", ""},
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", "webp"},
+		{"image/png, image/webp", "png|webp"},
+		{"image/png;q=bogus, image/gif", "gif"},
+		{"IMAGE/PNG", "png"},
+	}
+
+	for _, test := range tests {
+		got := strings.Join(negotiateFormats(test.accept), "|")
+		if got != test.want {
+			t.Errorf("negotiateFormats(%q) = %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestChooseFormat(t *testing.T) {
+	if got := chooseFormat(nil, codec.JPEG); got != codec.JPEG {
+		t.Errorf("chooseFormat without preference = %q, want the original format", got)
+	}
+	if got := chooseFormat([]string{codec.PNG, codec.GIF}, codec.GIF); got != codec.GIF {
+		t.Errorf("chooseFormat = %q, want the accepted original format", got)
+	}
+	if got := chooseFormat([]string{codec.PNG, codec.GIF}, codec.JPEG); got != codec.PNG {
+		t.Errorf("chooseFormat = %q, want the preferred accepted format", got)
+	}
+	if got := chooseFormat([]string{codec.WEBP}, codec.JPEG); got != codec.JPEG {
+		t.Errorf("chooseFormat = %q, want JPEG originals kept from lossless WebP", got)
+	}
+	if got := chooseFormat([]string{codec.PNG, codec.WEBP}, codec.JPEG); got != codec.PNG {
+		t.Errorf("chooseFormat = %q, want the preferred accepted format other than WebP", got)
+	}
+	if got := chooseFormat([]string{codec.WEBP}, codec.PNG); got != codec.WEBP {
+		t.Errorf("chooseFormat = %q, want lossless originals served as WebP", got)
+	}
+}
+
+func TestImageHandlerNegotiatesFormat(t *testing.T) {
+	serveFromMemory(map[string][]byte{"photo.jpg": encodeTestImage(t, codec.JPEG), "photo.png": encodeTestImage(t, codec.PNG)})
+
+	tests := []struct {
+		target, accept, contentType string
+	}{
+		{"/photo.jpg", "", "image/jpeg"},
+		{"/photo.jpg", "image/png", "image/png"},
+		{"/photo.jpg", "image/gif;q=0.5, image/png", "image/png"},
+		{"/photo.jpg", "image/png, image/jpeg", "image/jpeg"},
+		{"/photo.jpg", "text/html, */*;q=0.1", "image/jpeg"},
+		{"/photo.jpg?fmt=gif", "image/png", "image/gif"},
+		{"/photo.jpg", "image/avif,image/webp,image/apng,image/*,*/*;q=0.8", "image/jpeg"},
+		{"/photo.jpg", "image/webp", "image/jpeg"},
+		{"/photo.jpg?fmt=webp", "image/avif,image/webp,image/apng,image/*,*/*;q=0.8", "image/webp"},
+		{"/photo.png", "image/avif,image/webp,image/apng,image/*,*/*;q=0.8", "image/webp"},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s (Accept: %s): status = %d", test.target, test.accept, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("GET %s (Accept: %s): Content-Type = %q, want %q", test.target, test.accept, got, test.contentType)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("GET %s: Vary = %q, want Accept", test.target, got)
+		}
+	}
+
+	if (transformation{format: codec.WEBP}).key() == (transformation{accepted: []string{codec.WEBP}}).key() {
+		t.Error("explicit and negotiated WebP share a key")
+	}
+}
+
+func TestOriginalFormat(t *testing.T) {
+	tests := []struct {
+		frame          int
+		format, output string
+	}{
+		{0, "jpeg", codec.JPEG},
+		{0, "gif", codec.GIF},
+		{1, "gif", codec.PNG},
+		{0, "bmp", codec.PNG},
+		{0, "tiff", codec.PNG},
+	}
+	for _, test := range tests {
+		if got := originalFormat(transformation{frame: test.frame}, test.format); got != test.output {
+			t.Errorf("originalFormat(frame %d, %s) = %q, want %q", test.frame, test.format, got, test.output)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"image/codec"
+	"image/resizer"
+)
+
+// parseRotate reads a clockwise rotation of at most a full turn either
+// way. Multiples of 90 degrees are returned as an orientation, other
+// angles in degrees.
+func parseRotate(value string) (resizer.Orientation, float64, error) {
+	degrees, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(math.Abs(degrees) <= 360) {
+		return resizer.Orientation{}, 0, fmt.Errorf("%w: rotate %q is not between -360 and 360", errBadRequest, value)
+	}
+	if degrees == math.Trunc(degrees) {
+		if o, ok := resizer.Rotation(int(degrees)); ok {
+			return o, 0, nil
+		}
+	}
+	return resizer.Orientation{}, degrees, nil
+}
+
+// parseFlip reads a mirroring: h mirrors horizontally, v vertically and hv
+// both ways.
+func parseFlip(value string) (resizer.Orientation, error) {
+	switch strings.ToLower(value) {
+	case "h":
+		return resizer.Orientation{FlipX: true}, nil
+	case "v":
+		return resizer.Orientation{FlipY: true}, nil
+	case "hv", "vh":
+		return resizer.Orientation{FlipX: true, FlipY: true}, nil
+	}
+	return resizer.Orientation{}, fmt.Errorf("%w: flip %q is not h, v or hv", errBadRequest, value)
+}
+
+// orientationKey returns a short canonical form of an orientation.
+func orientationKey(o resizer.Orientation) string {
+	bits := 0
+	for i, set := range []bool{o.Transpose, o.FlipX, o.FlipY} {
+		if set {
+			bits |= 1 << uint(i)
+		}
+	}
+	return strconv.Itoa(bits)
+}
+
+// reorient turns and mirrors img as o describes. Animations are turned
+// frame by frame, moving every frame along on the canvas.
+func reorient(o resizer.Orientation, img *image.Image) *image.Image {
+	a, ok := (*img).(*codec.Animation)
+	if !ok {
+		return resizer.Reorient(img, o)
+	}
+	if o == (resizer.Orientation{}) {
+		return img
+	}
+
+	bounds := a.Bounds()
+	size := o.Size(bounds.Size())
+	out := *a.GIF
+	out.Config.Width, out.Config.Height = size.X, size.Y
+	out.Image = make([]*image.Paletted, len(a.GIF.Image))
+	for i, frame := range a.GIF.Image {
+		var src image.Image = frame
+		paletted := *(*resizer.Reorient(&src, o)).(*image.Paletted)
+		paletted.Rect = o.Rect(frame.Rect.Sub(bounds.Min), bounds.Size())
+		out.Image[i] = &paletted
+	}
+	var result image.Image = codec.NewAnimation(&out)
+	return &result
+}
+
+// rotate turns img clockwise by t.angle degrees, sampling it with t.filter
+// and filling the uncovered corners with t.background. The image has
+// already been reoriented, so when that mirrored it the angle is reversed,
+// as mirroring a turned image is turning the mirrored image the other way.
+// Animations cannot be turned by angles other than multiples of 90
+// degrees.
+func rotate(t transformation, img *image.Image) (*image.Image, error) {
+	if t.angle == 0 {
+		return img, nil
+	}
+	if _, ok := (*img).(*codec.Animation); ok {
+		return nil, fmt.Errorf("%w: animations only rotate by multiples of 90 degrees", errBadRequest)
+	}
+	angle := t.angle
+	if t.orientation.Mirrors() {
+		angle = -angle
+	}
+	return resizer.Rotate(img, angle, !t.cropRotation, resizer.Options{Filter: t.filter, Background: t.background}), nil
+}
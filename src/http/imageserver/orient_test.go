@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"image/resizer"
+)
+
+// encodeTurnedJPEG returns a 16x8 JPEG, dark on the left and light on the
+// right, whose EXIF orientation asks to turn it 90 degrees clockwise.
+func encodeTurnedJPEG(t *testing.T) []byte {
+	src := image.NewGray(image.Rect(0, 0, 16, 8))
+	for i := range src.Pix {
+		if i%16 >= 8 {
+			src.Pix[i] = 0xff
+		}
+	}
+	buffer := new(bytes.Buffer)
+	if err := jpeg.Encode(buffer, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	segment := []byte{
+		0xff, 0xe1, 0, 34, 'E', 'x', 'i', 'f', 0, 0,
+		'M', 'M', 0, 42, 0, 0, 0, 8,
+		0, 1,
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, 6, 0, 0,
+		0, 0, 0, 0,
+	}
+	data := buffer.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestImageHandlerOrientation(t *testing.T) {
+	serveFromMemory(map[string][]byte{"turned.jpg": encodeTurnedJPEG(t), "anim.gif": encodeTestAnimation(t)})
+
+	tests := []struct {
+		target string
+		status int
+		size   image.Point
+		// dark is where the dark half of the stored image ends up.
+		dark image.Point
+	}{
+		{"/turned.jpg", http.StatusOK, image.Pt(8, 16), image.Pt(4, 2)},
+		{"/turned.jpg?orient=auto", http.StatusOK, image.Pt(8, 16), image.Pt(4, 2)},
+		{"/turned.jpg?orient=none", http.StatusOK, image.Pt(16, 8), image.Pt(2, 4)},
+		{"/turned.jpg?orient=none&rotate=90", http.StatusOK, image.Pt(8, 16), image.Pt(4, 2)},
+		{"/turned.jpg?orient=none&rotate=-90", http.StatusOK, image.Pt(8, 16), image.Pt(4, 13)},
+		{"/turned.jpg?orient=none&flip=h", http.StatusOK, image.Pt(16, 8), image.Pt(13, 4)},
+		{"/turned.jpg?rotate=90&flip=v", http.StatusOK, image.Pt(16, 8), image.Pt(13, 4)},
+		{"/turned.jpg?rotate=180&flip=hv", http.StatusOK, image.Pt(8, 16), image.Pt(4, 2)},
+		{"/turned.jpg?rotate=270&w=4", http.StatusOK, image.Pt(4, 2), image.Pt(0, 1)},
+		{"/turned.jpg?orient=sideways", http.StatusBadRequest, image.Point{}, image.Point{}},
+		{"/turned.jpg?rotate=450", http.StatusBadRequest, image.Point{}, image.Point{}},
+		{"/turned.jpg?rotate=right", http.StatusBadRequest, image.Point{}, image.Point{}},
+		{"/turned.jpg?flip=x", http.StatusBadRequest, image.Point{}, image.Point{}},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		img, err := jpeg.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := img.Bounds().Size(); size != test.size {
+			t.Errorf("GET %s: size = %v, want %v", test.target, size, test.size)
+			continue
+		}
+		if y := color.GrayModel.Convert(img.At(test.dark.X, test.dark.Y)).(color.Gray).Y; y > 0x40 {
+			t.Errorf("GET %s: pixel %v = %d, want dark", test.target, test.dark, y)
+		}
+	}
+
+	// The frame on the right half of the animation moves to the bottom.
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/anim.gif?rotate=90", nil))
+	g, err := gif.DecodeAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Config.Width != 20 || g.Config.Height != 40 {
+		t.Errorf("canvas is %dx%d, want 20x40", g.Config.Width, g.Config.Height)
+	}
+	if r := g.Image[2].Bounds(); r != image.Rect(0, 20, 20, 40) {
+		t.Errorf("frame 2 covers %v, want the bottom half of the canvas", r)
+	}
+
+	if (transformation{angle: 3.5}).key() == (transformation{angle: 3.5, cropRotation: true}).key() {
+		t.Error("the canvas is missing from the key")
+	}
+	if (transformation{ignoreOrientation: true}).key() == (transformation{}).key() {
+		t.Error("the orientation is missing from the key")
+	}
+	rotated, _ := resizer.Rotation(90)
+	if (transformation{orientation: rotated}).key() == (transformation{}).key() {
+		t.Error("the rotation is missing from the key")
+	}
+}
+
+func TestImageHandlerRotate(t *testing.T) {
+	serveFromMemory(map[string][]byte{"turned.jpg": encodeTurnedJPEG(t), "anim.gif": encodeTestAnimation(t)})
+
+	tests := []struct {
+		target string
+		status int
+		size   image.Point
+	}{
+		{"/turned.jpg?rotate=3.5", http.StatusOK, image.Pt(9, 17)},
+		{"/turned.jpg?rotate=-3.5&canvas=expand", http.StatusOK, image.Pt(9, 17)},
+		{"/turned.jpg?rotate=3.5&canvas=crop", http.StatusOK, image.Pt(8, 16)},
+		{"/turned.jpg?rotate=45&w=4&filter=bilinear", http.StatusOK, image.Pt(9, 9)},
+		{"/turned.jpg?rotate=30&canvas=shrink", http.StatusBadRequest, image.Point{}},
+		{"/anim.gif?rotate=10", http.StatusBadRequest, image.Point{}},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		config, _, err := image.DecodeConfig(w.Body)
+		if err != nil {
+			t.Errorf("GET %s: %v", test.target, err)
+		} else if size := image.Pt(config.Width, config.Height); size != test.size {
+			t.Errorf("GET %s: size = %v, want %v", test.target, size, test.size)
+		}
+	}
+
+	// The corners are filled with the background.
+	for target, want := range map[string]color.NRGBA{
+		"/turned.jpg?rotate=30&fmt=png":                {R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+		"/turned.jpg?rotate=30&fmt=png&bg=00f":         {B: 0xff, A: 0xff},
+		"/turned.jpg?rotate=30&fmt=png&bg=transparent": {},
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", target, nil))
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		if got := color.NRGBAModel.Convert(img.At(0, 0)); got != want {
+			t.Errorf("GET %s: corner = %v, want %v", target, got, want)
+		}
+	}
+
+	// The image is rotated before it is flipped.
+	var turned [2]image.Image
+	for i, target := range []string{"/turned.jpg?rotate=30&fmt=png", "/turned.jpg?rotate=30&flip=h&fmt=png"} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", target, nil))
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		turned[i] = img
+	}
+	bounds := turned[0].Bounds()
+	if turned[1].Bounds() != bounds {
+		t.Fatalf("flipping changes the size from %v to %v", bounds, turned[1].Bounds())
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			a := color.GrayModel.Convert(turned[0].At(x, y)).(color.Gray).Y
+			b := color.GrayModel.Convert(turned[1].At(bounds.Max.X-1-x, y)).(color.Gray).Y
+			if d := int(a) - int(b); d < -2 || d > 2 {
+				t.Fatalf("rotate=30&flip=h at (%d, %d) = %d, want the mirror of rotate=30, %d", bounds.Max.X-1-x, y, b, a)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"sort"
+	"strconv"
+	"strings"
+
+	"image/codec"
+)
+
+var jpegQuality = flag.Int("jpeg-quality", codec.DefaultQuality, "default quality of JPEG renditions, from 1 to 100")
+var jpegQualityClasses = flag.String("jpeg-quality-classes", "", "comma-separated size:quality pairs overriding -jpeg-quality for JPEG renditions whose longest side is at most size pixels, e.g. 160:60,480:70")
+var pngCompression = flag.String("png-compression", "default", "default compression of PNG renditions: default, none, fast or best")
+var progressiveMinSize = flag.Int("progressive-min-size", 1024, "encode JPEG renditions whose longest side is at least this many pixels as progressive JPEG; 0 disables")
+
+// qualityClass is the default JPEG quality of renditions whose longest side
+// is at most maxSize pixels.
+type qualityClass struct {
+	maxSize int
+	quality int
+}
+
+// qualityClasses holds the parsed -jpeg-quality-classes, smallest first.
+var qualityClasses []qualityClass
+
+// parseQuality reads a JPEG quality from 1 to 100.
+func parseQuality(value string) (int, error) {
+	quality, err := strconv.Atoi(value)
+	if err != nil || quality < 1 || quality > 100 {
+		return 0, fmt.Errorf("%w: quality %q is not between 1 and 100", errBadRequest, value)
+	}
+	return quality, nil
+}
+
+// parseQualityClasses parses the size:quality pairs of -jpeg-quality-classes.
+func parseQualityClasses(spec string) ([]qualityClass, error) {
+	var classes []qualityClass
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		size, quality, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("quality class %q is not size:quality", pair)
+		}
+		maxSize, err := strconv.Atoi(size)
+		if err != nil || maxSize <= 0 {
+			return nil, fmt.Errorf("quality class %q has an invalid size", pair)
+		}
+		q, err := parseQuality(quality)
+		if err != nil {
+			return nil, fmt.Errorf("quality class %q has an invalid quality", pair)
+		}
+		classes = append(classes, qualityClass{maxSize, q})
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i].maxSize < classes[j].maxSize })
+	return classes, nil
+}
+
+// encodeOptions returns the encoding parameters of rendition img for t. A
+// quality requested with ?q= wins over the class of the rendition size,
+// which wins over -jpeg-quality. Large JPEG renditions are progressive.
+// The PNG compression defaults to -png-compression.
+func encodeOptions(t transformation, img image.Image) *codec.Options {
+	bounds := img.Bounds()
+	size := bounds.Dx()
+	if bounds.Dy() > size {
+		size = bounds.Dy()
+	}
+
+	options := &codec.Options{Quality: t.quality}
+	if options.Quality == 0 {
+		options.Quality = *jpegQuality
+		for _, class := range qualityClasses {
+			if size <= class.maxSize {
+				options.Quality = class.quality
+				break
+			}
+		}
+	}
+	options.Progressive = *progressiveMinSize > 0 && size >= *progressiveMinSize
+
+	compression := t.compression
+	if compression == "" {
+		compression = *pngCompression
+	}
+	options.Compression, _ = codec.CompressionLevel(compression)
+	options.Colors = t.colors
+	return options
+}
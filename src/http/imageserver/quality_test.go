@@ -0,0 +1,120 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"image/codec"
+)
+
+func TestParseQualityClasses(t *testing.T) {
+	classes, err := parseQualityClasses("480:70, 160:60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []qualityClass{{160, 60}, {480, 70}}
+	if !reflect.DeepEqual(classes, want) {
+		t.Errorf("parseQualityClasses = %v, want %v", classes, want)
+	}
+
+	for _, spec := range []string{"160", "0:60", "x:60", "160:0", "160:101", "160:high"} {
+		if _, err := parseQualityClasses(spec); err == nil {
+			t.Errorf("parseQualityClasses(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestEncodeOptions(t *testing.T) {
+	defer func(classes []qualityClass) { qualityClasses = classes }(qualityClasses)
+	qualityClasses = []qualityClass{{160, 60}, {480, 70}}
+
+	tests := []struct {
+		quality, width, height int
+		want                   codec.Options
+	}{
+		{0, 100, 160, codec.Options{Quality: 60}},
+		{0, 161, 100, codec.Options{Quality: 70}},
+		{0, 800, 600, codec.Options{Quality: *jpegQuality}},
+		{0, 1600, 1200, codec.Options{Quality: *jpegQuality, Progressive: true}},
+		{95, 100, 100, codec.Options{Quality: 95}},
+		{95, 600, 1200, codec.Options{Quality: 95, Progressive: true}},
+	}
+	for _, test := range tests {
+		tr := transformation{quality: test.quality}
+		img := image.NewRGBA(image.Rect(0, 0, test.width, test.height))
+		if got := encodeOptions(tr, img); *got != test.want {
+			t.Errorf("encodeOptions(q=%d, %dx%d) = %+v, want %+v", test.quality, test.width, test.height, *got, test.want)
+		}
+	}
+}
+
+func TestImageHandlerQuality(t *testing.T) {
+	serveFromMemory(map[string][]byte{"photo.jpg": encodeTestImage(t, codec.JPEG)})
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/photo.jpg?q=1", http.StatusOK},
+		{"/photo.jpg?q=100", http.StatusOK},
+		{"/photo.jpg?q=0", http.StatusBadRequest},
+		{"/photo.jpg?q=101", http.StatusBadRequest},
+		{"/photo.jpg?q=-5", http.StatusBadRequest},
+		{"/photo.jpg?q=best", http.StatusBadRequest},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+		}
+	}
+
+	a := transformation{filename: "photo.jpg", quality: 40}
+	b := transformation{filename: "photo.jpg", quality: 90}
+	if a.key() == b.key() {
+		t.Errorf("renditions of different quality share the key %q", a.key())
+	}
+}
+
+func TestImageHandlerPNGOptions(t *testing.T) {
+	serveFromMemory(map[string][]byte{"photo.png": encodeTestImage(t, codec.PNG)})
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/photo.png?compression=best", http.StatusOK},
+		{"/photo.png?compression=NONE", http.StatusOK},
+		{"/photo.png?colors=2", http.StatusOK},
+		{"/photo.png?colors=256&fmt=gif", http.StatusOK},
+		{"/photo.png?compression=max", http.StatusBadRequest},
+		{"/photo.png?colors=1", http.StatusBadRequest},
+		{"/photo.png?colors=257", http.StatusBadRequest},
+		{"/photo.png?colors=many", http.StatusBadRequest},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+		}
+	}
+
+	tr := transformation{compression: "best", colors: 64}
+	options := encodeOptions(tr, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	if options.Compression != png.BestCompression || options.Colors != 64 {
+		t.Errorf("encodeOptions = %+v, want best compression and 64 colors", *options)
+	}
+	if tr.key() == (transformation{}).key() {
+		t.Errorf("PNG options are missing from the key %q", tr.key())
+	}
+}
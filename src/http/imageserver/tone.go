@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"image/codec"
+	"image/resizer"
+)
+
+// Tones an image can be given with ?tone=.
+const (
+	toneNone  = ""
+	toneGray  = "grayscale"
+	toneSepia = "sepia"
+)
+
+// parseTone reads a tone: grayscale, also spelled gray, grey or
+// greyscale, or sepia.
+func parseTone(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "grayscale", "gray", "greyscale", "grey":
+		return toneGray, nil
+	case "sepia":
+		return toneSepia, nil
+	}
+	return toneNone, fmt.Errorf("%w: unknown tone %q", errBadRequest, name)
+}
+
+// parseDuotone reads the dark and light colors of a duotone, separated by
+// a comma.
+func parseDuotone(value string) (*[2]color.NRGBA, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: duotone %q is not two colors", errBadRequest, value)
+	}
+	var duotone [2]color.NRGBA
+	for i, part := range parts {
+		c, err := parseColor(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		duotone[i] = c
+	}
+	return &duotone, nil
+}
+
+// parseTint reads the color of a tint. Its alpha is the strength of the
+// tint; colors given without alpha tint by half.
+func parseTint(value string) (*color.NRGBA, error) {
+	tint, err := parseColor(value)
+	if err != nil {
+		return nil, err
+	}
+	if digits := len(strings.TrimPrefix(value, "#")); digits == 3 || digits == 6 {
+		tint.A = 0x80
+	}
+	return &tint, nil
+}
+
+// colorMatrix returns the sepia tone, duotone and tint of t as a single
+// matrix.
+func (t transformation) colorMatrix() resizer.ColorMatrix {
+	m := resizer.IdentityMatrix
+	if t.tone == toneSepia {
+		m = resizer.SepiaMatrix
+	}
+	if t.duotone != nil {
+		m = m.Then(resizer.DuotoneMatrix(t.duotone[0], t.duotone[1]))
+	}
+	if t.tint != nil {
+		m = m.Then(resizer.TintMatrix(*t.tint))
+	}
+	return m
+}
+
+// recolor gives img the tone, duotone and tint of t, in that order. Opaque
+// images turned gray and nothing else become single-channel images.
+// Animations have the palettes of their frames recolored instead of their
+// pixels.
+func recolor(t transformation, img *image.Image) *image.Image {
+	m := t.colorMatrix()
+	if a, ok := (*img).(*codec.Animation); ok {
+		if t.tone == toneGray {
+			m = resizer.GrayMatrix.Then(m)
+		}
+		if m == resizer.IdentityMatrix {
+			return img
+		}
+		return mapPalettes(a, m.Color)
+	}
+
+	if t.tone == toneGray {
+		img = resizer.Grayscale(img)
+	}
+	return resizer.Recolor(img, m)
+}
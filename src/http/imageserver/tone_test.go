@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"image/codec"
+)
+
+// encodeRedBluePNG returns a 16x16 PNG whose left half is red and right
+// half blue.
+func encodeRedBluePNG(t *testing.T) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.NRGBA{255, 0, 0, 255}
+			if x >= 8 {
+				c = color.NRGBA{0, 0, 255, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	buffer := new(bytes.Buffer)
+	if err := codec.Encode(buffer, img, codec.PNG, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// near reports whether every channel of a is within tol of b.
+func near(a, b color.NRGBA, tol int) bool {
+	for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A)} {
+		if d < -tol || d > tol {
+			return false
+		}
+	}
+	return true
+}
+
+func TestImageHandlerTone(t *testing.T) {
+	serveFromMemory(map[string][]byte{"flag.png": encodeRedBluePNG(t), "anim.gif": encodeTestAnimation(t)})
+
+	tests := []struct {
+		target string
+		status int
+		gray   bool
+		// left and right are the colors at the middle of each half.
+		left, right color.NRGBA
+	}{
+		{"/flag.png", http.StatusOK, false, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}},
+		{"/flag.png?tone=grayscale", http.StatusOK, true, color.NRGBA{76, 76, 76, 255}, color.NRGBA{29, 29, 29, 255}},
+		{"/flag.png?tone=GREY", http.StatusOK, true, color.NRGBA{76, 76, 76, 255}, color.NRGBA{29, 29, 29, 255}},
+		{"/flag.png?tone=sepia", http.StatusOK, false, color.NRGBA{100, 89, 69, 255}, color.NRGBA{48, 42, 33, 255}},
+		{"/flag.png?duotone=000040,ffff00", http.StatusOK, false, color.NRGBA{76, 76, 45, 255}, color.NRGBA{29, 29, 58, 255}},
+		{"/flag.png?tint=00ff00ff", http.StatusOK, false, color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 255, 0, 255}},
+		{"/flag.png?tint=0f0", http.StatusOK, false, color.NRGBA{127, 128, 0, 255}, color.NRGBA{0, 128, 127, 255}},
+		{"/flag.png?tone=gray&tint=00f", http.StatusOK, false, color.NRGBA{38, 38, 166, 255}, color.NRGBA{15, 15, 142, 255}},
+		{"/flag.png?tone=negative", http.StatusBadRequest, false, color.NRGBA{}, color.NRGBA{}},
+		{"/flag.png?duotone=000", http.StatusBadRequest, false, color.NRGBA{}, color.NRGBA{}},
+		{"/flag.png?duotone=000,fff,f00", http.StatusBadRequest, false, color.NRGBA{}, color.NRGBA{}},
+		{"/flag.png?tint=green", http.StatusBadRequest, false, color.NRGBA{}, color.NRGBA{}},
+	}
+
+	handler := makeHandler(imageHandler)
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status = %d, want %d", test.target, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, gray := img.(*image.Gray); gray != test.gray {
+			t.Errorf("GET %s: decoded a %T", test.target, img)
+		}
+		for _, p := range []struct {
+			at   image.Point
+			want color.NRGBA
+		}{{image.Pt(4, 8), test.left}, {image.Pt(12, 8), test.right}} {
+			got := color.NRGBAModel.Convert(img.At(p.at.X, p.at.Y)).(color.NRGBA)
+			if !near(got, p.want, 2) {
+				t.Errorf("GET %s: pixel %v = %v, want %v", test.target, p.at, got, p.want)
+			}
+		}
+	}
+
+	// Animations keep their pixels and change their palettes.
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/anim.gif?tone=gray&tint=0000ff80", nil))
+	g, err := gif.DecodeAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := color.NRGBAModel.Convert(g.Image[1].At(5, 5)).(color.NRGBA); !near(got, color.NRGBA{75, 75, 202, 255}, 2) {
+		t.Errorf("tinted green frame is %v, want {75 75 202}", got)
+	}
+
+	// Grayscale JPEGs are encoded with a single channel.
+	var sizes [2]int
+	for i, target := range []string{"/flag.png?fmt=jpeg", "/flag.png?fmt=jpeg&tone=gray"} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", target, nil))
+		sizes[i] = w.Body.Len()
+		img, err := jpeg.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, gray := img.(*image.Gray); gray != (i == 1) {
+			t.Errorf("GET %s: decoded a %T", target, img)
+		}
+	}
+	if sizes[1] >= sizes[0] {
+		t.Errorf("grayscale JPEG is %d bytes, color %d", sizes[1], sizes[0])
+	}
+
+	// Effects applied after the tone keep grayscale output single-channel.
+	for _, target := range []string{"/flag.png?tone=grayscale&blur=2", "/flag.png?tone=grayscale&sharpen=2", "/flag.png?tone=grayscale&fmt=jpeg&sharpen=2"} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", target, nil))
+		config, _, err := image.DecodeConfig(w.Body)
+		if err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		if config.ColorModel != color.GrayModel {
+			t.Errorf("GET %s: color model is not 8-bit gray", target)
+		}
+	}
+
+	keys := map[string]bool{}
+	for _, target := range []string{"/flag.png", "/flag.png?tone=gray", "/flag.png?tone=sepia", "/flag.png?duotone=000,fff", "/flag.png?duotone=000,ffe", "/flag.png?tint=f00", "/flag.png?tint=f008"} {
+		tr, err := parseTransformation(httptest.NewRequest("GET", target, nil), "flag.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys[tr.key()] {
+			t.Errorf("GET %s shares its key %s", target, tr.key())
+		}
+		keys[tr.key()] = true
+	}
+}
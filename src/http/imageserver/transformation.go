@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/color"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"image/codec"
+	"image/resizer"
+)
+
+// transformation describes how an original image is turned into the
+// rendition served to the client.
+type transformation struct {
+	filename string
+	width    int
+	height   int
+	format   string
+	quality  int
+
+	// ignoreOrientation keeps the original as stored rather than turned
+	// upright as its EXIF orientation tells. orientation then turns and
+	// mirrors it as requested.
+	ignoreOrientation bool
+	orientation       resizer.Orientation
+
+	// angle is a clockwise rotation in degrees, other than by a multiple
+	// of 90, applied after resizing. The canvas grows to hold the turned
+	// image unless cropRotation keeps its size.
+	angle        float64
+	cropRotation bool
+
+	// crop is the region of the original kept before scaling, or nil.
+	crop *cropRegion
+
+	// fit is how the image is scaled into a box of width×height, gravity
+	// where it is anchored in the box, and background the color Contain
+	// pads it with.
+	fit        resizer.Fit
+	gravity    resizer.Gravity
+	background color.NRGBA
+
+	// filter is the resampling filter, and linear whether it filters in
+	// linear light.
+	filter resizer.Filter
+	linear bool
+
+	// adjustments change the colors after resizing, then tone, duotone
+	// and tint recolor the image. Nil colors apply no duotone or tint.
+	adjustments resizer.Adjustments
+	tone        string
+	duotone     *[2]color.NRGBA
+	tint        *color.NRGBA
+
+	// blur is the standard deviation of the Gaussian blur and sharpen the
+	// unsharp mask applied after resizing. Zero values apply neither.
+	blur    float64
+	sharpen *sharpening
+
+	// compression names the PNG compression level, colors the size of the
+	// palette PNG and GIF output is reduced to. Zero values select the
+	// configured defaults.
+	compression string
+	colors      int
+
+	// frame is the frame extracted from an animation, counting from 1.
+	// Zero keeps all frames.
+	frame int
+
+	// accepted lists the formats negotiated from the Accept header when
+	// no format is requested explicitly.
+	accepted []string
+}
+
+// errBadRequest wraps errors caused by malformed request parameters.
+var errBadRequest = errors.New("bad request")
+
+// parseTransformation reads the transformation of filename requested by r.
+// Malformed or negative dimensions are treated as absent. Without an
+// explicit ?fmt= the format is negotiated from the Accept header, falling
+// back to the format of the original. A ?q= outside 1 to 100, an unknown
+// ?compression=, a ?colors= outside 2 to 256, a negative ?frame=, an
+// unknown ?fit=, ?gravity=, ?filter=, ?orient=, ?canvas= or ?tone=, a
+// malformed ?crop=, ?bg=, ?linear=, ?blur=, ?sharpen=, ?rotate=, ?flip=,
+// ?duotone= or ?tint= and color adjustments out of range are rejected.
+// The image is rotated before it is flipped. The background defaults to
+// white.
+func parseTransformation(r *http.Request, filename string) (transformation, error) {
+	query := r.URL.Query()
+	t := transformation{
+		filename:   filename,
+		width:      parseDimension(query.Get("w")),
+		height:     parseDimension(query.Get("h")),
+		background: white,
+	}
+
+	switch name := query.Get("orient"); strings.ToLower(name) {
+	case "", "auto":
+	case "none":
+		t.ignoreOrientation = true
+	default:
+		return t, fmt.Errorf("%w: unknown orient %q", errBadRequest, name)
+	}
+
+	if value := query.Get("rotate"); value != "" {
+		rotation, angle, err := parseRotate(value)
+		if err != nil {
+			return t, err
+		}
+		t.orientation, t.angle = rotation, angle
+	}
+
+	switch name := query.Get("canvas"); strings.ToLower(name) {
+	case "", "expand":
+	case "crop":
+		t.cropRotation = true
+	default:
+		return t, fmt.Errorf("%w: unknown canvas %q", errBadRequest, name)
+	}
+
+	if value := query.Get("flip"); value != "" {
+		flip, err := parseFlip(value)
+		if err != nil {
+			return t, err
+		}
+		t.orientation = t.orientation.Then(flip)
+	}
+
+	if name := query.Get("fit"); name != "" {
+		fit, ok := resizer.ParseFit(name)
+		if !ok {
+			return t, fmt.Errorf("%w: unknown fit %q", errBadRequest, name)
+		}
+		t.fit = fit
+	}
+
+	if name := query.Get("gravity"); name != "" {
+		gravity, ok := resizer.ParseGravity(name)
+		if !ok {
+			return t, fmt.Errorf("%w: unknown gravity %q", errBadRequest, name)
+		}
+		t.gravity = gravity
+	}
+
+	if name := query.Get("filter"); name != "" {
+		filter, ok := resizer.ParseFilter(name)
+		if !ok {
+			return t, fmt.Errorf("%w: unknown filter %q", errBadRequest, name)
+		}
+		t.filter = filter
+	}
+
+	if value := query.Get("linear"); value != "" {
+		linear, err := strconv.ParseBool(value)
+		if err != nil {
+			return t, fmt.Errorf("%w: invalid linear %q", errBadRequest, value)
+		}
+		t.linear = linear
+	}
+
+	if value := query.Get("crop"); value != "" {
+		crop, err := parseCrop(value)
+		if err != nil {
+			return t, err
+		}
+		t.crop = crop
+	}
+
+	if value := query.Get("bg"); value != "" {
+		background, err := parseColor(value)
+		if err != nil {
+			return t, err
+		}
+		t.background = background
+	}
+
+	adjustments, err := parseAdjustments(query)
+	if err != nil {
+		return t, err
+	}
+	t.adjustments = adjustments
+
+	if name := query.Get("tone"); name != "" {
+		tone, err := parseTone(name)
+		if err != nil {
+			return t, err
+		}
+		t.tone = tone
+	}
+
+	if value := query.Get("duotone"); value != "" {
+		duotone, err := parseDuotone(value)
+		if err != nil {
+			return t, err
+		}
+		t.duotone = duotone
+	}
+
+	if value := query.Get("tint"); value != "" {
+		tint, err := parseTint(value)
+		if err != nil {
+			return t, err
+		}
+		t.tint = tint
+	}
+
+	if value := query.Get("blur"); value != "" {
+		blur, err := parseBlur(value)
+		if err != nil {
+			return t, err
+		}
+		t.blur = blur
+	}
+
+	if value := query.Get("sharpen"); value != "" {
+		sharpen, err := parseSharpen(value)
+		if err != nil {
+			return t, err
+		}
+		t.sharpen = sharpen
+	}
+
+	if value := query.Get("q"); value != "" {
+		quality, err := parseQuality(value)
+		if err != nil {
+			return t, err
+		}
+		t.quality = quality
+	}
+
+	if name := query.Get("compression"); name != "" {
+		if _, ok := codec.CompressionLevel(name); !ok {
+			return t, fmt.Errorf("%w: unknown compression %q", errBadRequest, name)
+		}
+		t.compression = strings.ToLower(name)
+	}
+
+	if value := query.Get("colors"); value != "" {
+		colors, err := strconv.Atoi(value)
+		if err != nil || colors < 2 || colors > 256 {
+			return t, fmt.Errorf("%w: colors %q is not between 2 and 256", errBadRequest, value)
+		}
+		t.colors = colors
+	}
+
+	if value := query.Get("frame"); value != "" {
+		frame, err := strconv.Atoi(value)
+		if err != nil || frame < 0 {
+			return t, fmt.Errorf("%w: invalid frame %q", errBadRequest, value)
+		}
+		t.frame = frame + 1
+	}
+
+	if name := query.Get("fmt"); name != "" {
+		format, ok := codec.Format(name)
+		if !ok {
+			return t, fmt.Errorf("%w: %s", codec.ErrUnsupported, name)
+		}
+		t.format = format
+	} else {
+		t.accepted = negotiateFormats(r.Header.Get("Accept"))
+		// WebP depends on the original, see chooseFormat.
+		if len(t.accepted) == 1 && t.accepted[0] != codec.WEBP {
+			t.format = t.accepted[0]
+		}
+	}
+
+	return t, nil
+}
+
+var white = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+
+// parseColor reads a color given as RGB, RGBA, RRGGBB or RRGGBBAA in
+// hexadecimal, optionally preceded by '#', or the name transparent.
+func parseColor(value string) (color.NRGBA, error) {
+	if strings.EqualFold(value, "transparent") {
+		return color.NRGBA{}, nil
+	}
+	digits := strings.TrimPrefix(value, "#")
+	if len(digits) == 3 || len(digits) == 4 {
+		var long []byte
+		for i := 0; i < len(digits); i++ {
+			long = append(long, digits[i], digits[i])
+		}
+		digits = string(long)
+	}
+	c, err := hex.DecodeString(digits)
+	if err != nil || len(c) != 3 && len(c) != 4 {
+		return color.NRGBA{}, fmt.Errorf("%w: invalid color %q", errBadRequest, value)
+	}
+	if len(c) == 3 {
+		c = append(c, 0xff)
+	}
+	return color.NRGBA{R: c[0], G: c[1], B: c[2], A: c[3]}, nil
+}
+
+func parseDimension(value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// resizes reports whether the transformation changes the image dimensions.
+func (t transformation) resizes() bool {
+	return t.width != 0 || t.height != 0
+}
+
+// adjustmentsKey returns the canonical form of color adjustments.
+func adjustmentsKey(a resizer.Adjustments) string {
+	if a.Gamma == 1 {
+		a.Gamma = 0
+	}
+	return fmt.Sprintf("%g,%g,%g,%g,%g", a.Brightness, a.Contrast, a.Gamma, a.Saturation, a.Hue)
+}
+
+// colorKey returns the hexadecimal form of c.
+func colorKey(c color.NRGBA) string {
+	return fmt.Sprintf("%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// key returns the canonical rendition cache key of the transformation.
+// Requests that differ only in parameter order or spelling share a key.
+func (t transformation) key() string {
+	format := t.format
+	if format == "" {
+		format = "auto"
+		if len(t.accepted) > 0 {
+			format = "accept:" + strings.Join(t.accepted, "|")
+		}
+	}
+	crop := ""
+	if t.crop != nil {
+		crop = t.crop.String()
+	}
+	canvas := "expand"
+	if t.cropRotation {
+		canvas = "crop"
+	}
+	orient := "auto"
+	if t.ignoreOrientation {
+		orient = "none"
+	}
+	duotone := ""
+	if t.duotone != nil {
+		duotone = colorKey(t.duotone[0]) + "," + colorKey(t.duotone[1])
+	}
+	tint := ""
+	if t.tint != nil {
+		tint = colorKey(*t.tint)
+	}
+	return fmt.Sprintf("%s?orient=%s&reorient=%s&angle=%g&canvas=%s&crop=%s&w=%d&h=%d&fit=%s&gravity=%s&bg=%s&filter=%s&linear=%t&adjust=%s&tone=%s&duotone=%s&tint=%s&blur=%g&sharpen=%s&fmt=%s&q=%d&compression=%s&colors=%d&frame=%d",
+		t.filename, orient, orientationKey(t.orientation), t.angle, canvas, crop, t.width, t.height, t.fit, t.gravity, colorKey(t.background), t.filter, t.linear, adjustmentsKey(t.adjustments), t.tone, duotone, tint, t.blur, t.sharpen, format, t.quality, t.compression, t.colors, t.frame)
+}
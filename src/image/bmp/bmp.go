@@ -0,0 +1,377 @@
+// Package bmp decodes Windows BMP images: uncompressed images of 1, 4, 8,
+// 16, 24 and 32 bits per pixel, optionally with bit field masks, and RLE4
+// and RLE8 compressed images. Importing it registers the format with the
+// image package.
+package bmp
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math/bits"
+)
+
+// A FormatError reports that the input is not a valid BMP.
+type FormatError string
+
+func (e FormatError) Error() string { return "bmp: invalid format: " + string(e) }
+
+// An UnsupportedError reports that the input uses a valid but unimplemented
+// BMP feature.
+type UnsupportedError string
+
+func (e UnsupportedError) Error() string { return "bmp: unsupported feature: " + string(e) }
+
+// Compression methods.
+const (
+	compressionNone      = 0
+	compressionRLE8      = 1
+	compressionRLE4      = 2
+	compressionBitFields = 3
+	compressionAlpha     = 6
+)
+
+const fileHeaderLen = 14
+
+// header holds the fields of the file and DIB headers.
+type header struct {
+	offset      int // of the pixel data
+	width       int
+	height      int
+	topDown     bool
+	bpp         int
+	compression int
+	masks       [4]uint32 // red, green, blue and alpha
+	palette     color.Palette
+}
+
+func init() {
+	image.RegisterFormat("bmp", "BM????\x00\x00\x00\x00", Decode, DecodeConfig)
+}
+
+// DecodeConfig returns the color model and dimensions of a BMP image
+// without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	// The headers and the palette are at most a few KiB. The pixel data
+	// follows them, so reading up to the palette is enough.
+	data, err := ioutil.ReadAll(io.LimitReader(r, fileHeaderLen+124+16+256*4))
+	if err != nil {
+		return image.Config{}, err
+	}
+	h, err := readHeader(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	config := image.Config{Width: h.width, Height: h.height, ColorModel: color.RGBAModel}
+	switch {
+	case h.palette != nil:
+		config.ColorModel = h.palette
+	case h.masks[3] != 0:
+		config.ColorModel = color.NRGBAModel
+	}
+	return config, nil
+}
+
+// Decode reads a BMP image from r.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.offset > len(data) {
+		return nil, FormatError("pixel data offset beyond end of file")
+	}
+	pixels := data[h.offset:]
+
+	switch h.compression {
+	case compressionRLE8, compressionRLE4:
+		return decodeRLE(h, pixels)
+	}
+	if h.palette != nil {
+		return decodePaletted(h, pixels)
+	}
+	return decodeTrueColor(h, pixels)
+}
+
+func readHeader(data []byte) (*header, error) {
+	if len(data) < fileHeaderLen+4 || data[0] != 'B' || data[1] != 'M' {
+		return nil, FormatError("not a BMP file")
+	}
+	h := &header{offset: int(binary.LittleEndian.Uint32(data[10:]))}
+
+	dib := data[fileHeaderLen:]
+	size := int(binary.LittleEndian.Uint32(dib))
+	if size < 12 || len(dib) < size {
+		return nil, FormatError("truncated header")
+	}
+
+	var colors int
+	paletteEntry := 4
+	if size == 12 {
+		// BITMAPCOREHEADER from OS/2 and Windows 2.
+		h.width = int(int16(binary.LittleEndian.Uint16(dib[4:])))
+		h.height = int(int16(binary.LittleEndian.Uint16(dib[6:])))
+		h.bpp = int(binary.LittleEndian.Uint16(dib[10:]))
+		paletteEntry = 3
+	} else {
+		if size < 40 {
+			return nil, FormatError("truncated header")
+		}
+		h.width = int(int32(binary.LittleEndian.Uint32(dib[4:])))
+		h.height = int(int32(binary.LittleEndian.Uint32(dib[8:])))
+		h.bpp = int(binary.LittleEndian.Uint16(dib[14:]))
+		h.compression = int(binary.LittleEndian.Uint32(dib[16:]))
+		colors = int(binary.LittleEndian.Uint32(dib[32:]))
+	}
+	if h.height < 0 {
+		h.height, h.topDown = -h.height, true
+	}
+	if h.width <= 0 || h.height <= 0 {
+		return nil, FormatError("invalid dimensions")
+	}
+	if h.width*h.height > 1<<28 {
+		return nil, UnsupportedError("image too large")
+	}
+
+	end := fileHeaderLen + size
+	switch h.compression {
+	case compressionNone:
+		switch h.bpp {
+		case 16:
+			h.masks = [4]uint32{0x7c00, 0x03e0, 0x001f, 0}
+		case 24, 32:
+			h.masks = [4]uint32{0xff0000, 0x00ff00, 0x0000ff, 0}
+		}
+	case compressionBitFields, compressionAlpha:
+		if h.bpp != 16 && h.bpp != 32 {
+			return nil, FormatError("bit fields require 16 or 32 bits per pixel")
+		}
+		n := 3
+		if h.compression == compressionAlpha || size >= 56 {
+			n = 4
+		}
+		masks := end
+		if size >= 52 {
+			// The masks are part of BITMAPV2INFOHEADER and later headers.
+			masks = fileHeaderLen + 40
+		} else {
+			end += 4 * n
+		}
+		if len(data) < masks+4*n {
+			return nil, FormatError("truncated bit fields")
+		}
+		for i := 0; i < n; i++ {
+			h.masks[i] = binary.LittleEndian.Uint32(data[masks+4*i:])
+		}
+	case compressionRLE8:
+		if h.bpp != 8 {
+			return nil, FormatError("RLE8 requires 8 bits per pixel")
+		}
+	case compressionRLE4:
+		if h.bpp != 4 {
+			return nil, FormatError("RLE4 requires 4 bits per pixel")
+		}
+	default:
+		return nil, UnsupportedError("compression method")
+	}
+	if h.topDown && (h.compression == compressionRLE8 || h.compression == compressionRLE4) {
+		return nil, FormatError("top-down RLE image")
+	}
+
+	switch h.bpp {
+	case 1, 4, 8:
+		if colors == 0 || colors > 1<<uint(h.bpp) {
+			colors = 1 << uint(h.bpp)
+		}
+		if len(data) < end+colors*paletteEntry {
+			return nil, FormatError("truncated palette")
+		}
+		h.palette = make(color.Palette, colors)
+		for i := range h.palette {
+			p := data[end+i*paletteEntry:]
+			h.palette[i] = color.RGBA{p[2], p[1], p[0], 0xff}
+		}
+	case 16, 24, 32:
+	default:
+		return nil, UnsupportedError("bits per pixel")
+	}
+	return h, nil
+}
+
+// row returns the index of the image row stored at position i.
+func (h *header) row(i int) int {
+	if h.topDown {
+		return i
+	}
+	return h.height - 1 - i
+}
+
+func decodePaletted(h *header, pixels []byte) (image.Image, error) {
+	img := image.NewPaletted(image.Rect(0, 0, h.width, h.height), h.palette)
+	stride := (h.width*h.bpp + 31) / 32 * 4
+	if len(pixels) < stride*h.height {
+		return nil, FormatError("truncated pixel data")
+	}
+
+	perByte := 8 / h.bpp
+	mask := byte(1<<uint(h.bpp) - 1)
+	for i := 0; i < h.height; i++ {
+		src := pixels[i*stride:]
+		dst := img.Pix[h.row(i)*img.Stride:]
+		for x := 0; x < h.width; x++ {
+			shift := uint(8 - h.bpp*(x%perByte+1))
+			index := src[x/perByte] >> shift & mask
+			if int(index) >= len(h.palette) {
+				index = 0
+			}
+			dst[x] = index
+		}
+	}
+	return img, nil
+}
+
+func decodeTrueColor(h *header, pixels []byte) (image.Image, error) {
+	stride := (h.width*h.bpp + 31) / 32 * 4
+	if len(pixels) < stride*h.height {
+		return nil, FormatError("truncated pixel data")
+	}
+
+	var channels [4]struct {
+		shift uint
+		max   uint32
+	}
+	for i, mask := range h.masks {
+		if mask != 0 {
+			channels[i].shift = uint(bits.TrailingZeros32(mask))
+			channels[i].max = mask >> channels[i].shift
+		}
+	}
+	scale := func(v uint32, c int) uint8 {
+		if channels[c].max == 0 {
+			return 0xff
+		}
+		v = v & h.masks[c] >> channels[c].shift
+		return uint8((v*255 + channels[c].max/2) / channels[c].max)
+	}
+
+	// Images with an alpha mask are not premultiplied.
+	bounds := image.Rect(0, 0, h.width, h.height)
+	var rgba *image.RGBA
+	var nrgba *image.NRGBA
+	if h.masks[3] != 0 {
+		nrgba = image.NewNRGBA(bounds)
+	} else {
+		rgba = image.NewRGBA(bounds)
+	}
+
+	bytesPerPixel := h.bpp / 8
+	for i := 0; i < h.height; i++ {
+		src := pixels[i*stride:]
+		y := h.row(i)
+		for x := 0; x < h.width; x++ {
+			p := src[x*bytesPerPixel:]
+			var v uint32
+			switch bytesPerPixel {
+			case 2:
+				v = uint32(binary.LittleEndian.Uint16(p))
+			case 3:
+				v = uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16
+			case 4:
+				v = binary.LittleEndian.Uint32(p)
+			}
+			c := color.RGBA{scale(v, 0), scale(v, 1), scale(v, 2), scale(v, 3)}
+			if nrgba != nil {
+				nrgba.SetNRGBA(x, y, color.NRGBA(c))
+			} else {
+				rgba.SetRGBA(x, y, c)
+			}
+		}
+	}
+	if nrgba != nil {
+		return nrgba, nil
+	}
+	return rgba, nil
+}
+
+// decodeRLE decodes run-length encoded 4 and 8 bit images. Pixels skipped
+// by delta and end of line escapes keep palette index 0.
+func decodeRLE(h *header, data []byte) (image.Image, error) {
+	img := image.NewPaletted(image.Rect(0, 0, h.width, h.height), h.palette)
+	set := func(x, i int, index byte) {
+		if x < h.width && i < h.height && int(index) < len(h.palette) {
+			img.Pix[h.row(i)*img.Stride+x] = index
+		}
+	}
+
+	x, i := 0, 0
+	for p := 0; ; {
+		if p+2 > len(data) {
+			// Tolerate a missing end of bitmap marker.
+			return img, nil
+		}
+		count, value := int(data[p]), data[p+1]
+		p += 2
+
+		if count > 0 {
+			for j := 0; j < count; j++ {
+				index := value
+				if h.bpp == 4 {
+					index = value >> 4
+					if j%2 == 1 {
+						index = value & 0x0f
+					}
+				}
+				set(x, i, index)
+				x++
+			}
+			continue
+		}
+
+		switch value {
+		case 0: // end of line
+			x, i = 0, i+1
+		case 1: // end of bitmap
+			return img, nil
+		case 2: // delta
+			if p+2 > len(data) {
+				return nil, FormatError("truncated RLE delta")
+			}
+			x += int(data[p])
+			i += int(data[p+1])
+			p += 2
+		default: // absolute run of value pixels, padded to 16 bits
+			n := int(value)
+			length := n
+			if h.bpp == 4 {
+				length = (n + 1) / 2
+			}
+			if p+length > len(data) {
+				return nil, FormatError("truncated RLE run")
+			}
+			for j := 0; j < n; j++ {
+				var index byte
+				if h.bpp == 4 {
+					index = data[p+j/2] >> 4
+					if j%2 == 1 {
+						index = data[p+j/2] & 0x0f
+					}
+				} else {
+					index = data[p+j]
+				}
+				set(x, i, index)
+				x++
+			}
+			p += (length + 1) &^ 1
+		}
+		if i >= h.height {
+			return img, nil
+		}
+	}
+}
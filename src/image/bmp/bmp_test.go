@@ -0,0 +1,225 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bmpFile assembles a BMP with a BITMAPINFOHEADER. extra holds the bit
+// field masks or the palette following the header; palettes may be
+// shorter than the bit depth allows.
+func bmpFile(width, height, bpp, compression int, extra, pixels []byte) []byte {
+	buffer := new(bytes.Buffer)
+	offset := 14 + 40 + len(extra)
+	le := binary.LittleEndian
+
+	buffer.WriteString("BM")
+	binary.Write(buffer, le, uint32(offset+len(pixels)))
+	binary.Write(buffer, le, uint32(0))
+	binary.Write(buffer, le, uint32(offset))
+
+	binary.Write(buffer, le, uint32(40))
+	binary.Write(buffer, le, int32(width))
+	binary.Write(buffer, le, int32(height))
+	binary.Write(buffer, le, uint16(1))
+	binary.Write(buffer, le, uint16(bpp))
+	binary.Write(buffer, le, uint32(compression))
+	binary.Write(buffer, le, uint32(len(pixels)))
+	colors := 0
+	if bpp <= 8 {
+		colors = len(extra) / 4
+	}
+	binary.Write(buffer, le, [4]uint32{2835, 2835, uint32(colors), 0})
+
+	buffer.Write(extra)
+	buffer.Write(pixels)
+	return buffer.Bytes()
+}
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+	white = color.RGBA{255, 255, 255, 255}
+)
+
+// palette4 is a BMP palette of blue, green, red and white, in BGRX order.
+var palette4 = []byte{255, 0, 0, 0, 0, 255, 0, 0, 0, 0, 255, 0, 255, 255, 255, 0}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		// want lists the rows of the image from the top.
+		want [][]color.RGBA
+	}{
+		{
+			"24-bit bottom-up",
+			bmpFile(2, 2, 24, compressionNone, nil, []byte{
+				255, 0, 0, 0, 255, 0, 0, 0, // blue, green, padding
+				0, 0, 255, 255, 255, 255, 0, 0, // red, white, padding
+			}),
+			[][]color.RGBA{{red, white}, {blue, green}},
+		},
+		{
+			"24-bit top-down",
+			bmpFile(2, -2, 24, compressionNone, nil, []byte{
+				255, 0, 0, 0, 255, 0, 0, 0,
+				0, 0, 255, 255, 255, 255, 0, 0,
+			}),
+			[][]color.RGBA{{blue, green}, {red, white}},
+		},
+		{
+			"32-bit",
+			bmpFile(1, 2, 32, compressionNone, nil, []byte{0, 0, 255, 0, 255, 255, 255, 0}),
+			[][]color.RGBA{{white}, {red}},
+		},
+		{
+			"16-bit 5-5-5",
+			bmpFile(2, 1, 16, compressionNone, nil, []byte{0x00, 0x7c, 0x1f, 0x00}),
+			[][]color.RGBA{{red, blue}},
+		},
+		{
+			"16-bit 5-6-5 bit fields",
+			bmpFile(2, 1, 16, compressionBitFields, []byte{0, 0xf8, 0, 0, 0xe0, 0x07, 0, 0, 0x1f, 0, 0, 0}, []byte{0xe0, 0x07, 0xff, 0xff}),
+			[][]color.RGBA{{green, white}},
+		},
+		{
+			"1-bit",
+			bmpFile(3, 1, 1, compressionNone, []byte{0, 0, 0, 0, 255, 255, 255, 0}, []byte{0xa0, 0, 0, 0}),
+			[][]color.RGBA{{white, {0, 0, 0, 255}, white}},
+		},
+		{
+			"4-bit",
+			bmpFile(3, 1, 4, compressionNone, palette4, []byte{0x01, 0x20, 0, 0}),
+			[][]color.RGBA{{blue, green, red}},
+		},
+		{
+			"8-bit",
+			bmpFile(2, 2, 8, compressionNone, palette4, []byte{3, 2, 0, 0, 1, 0, 0, 0}),
+			[][]color.RGBA{{green, blue}, {white, red}},
+		},
+		{
+			"RLE8",
+			bmpFile(4, 2, 8, compressionRLE8, palette4, []byte{
+				4, 2, 0, 0, // four red, end of line
+				0, 3, 3, 1, 0, 0, 1, 1, 0, 1, // absolute white, green, blue, padding; one green; end of bitmap
+			}),
+			[][]color.RGBA{{white, green, blue, green}, {red, red, red, red}},
+		},
+		{
+			"RLE4",
+			bmpFile(4, 2, 4, compressionRLE4, palette4, []byte{
+				4, 0x12, 0, 0, // green, red, green, red; end of line
+				0, 2, 1, 0, 0, 3, 0x30, 0, // one to the right; absolute white, blue, blue
+			}),
+			[][]color.RGBA{{blue, white, blue, blue}, {green, red, green, red}},
+		},
+	}
+
+	for _, test := range tests {
+		img, format, err := image.Decode(bytes.NewReader(test.data))
+		if err != nil || format != "bmp" {
+			t.Errorf("%s: image.Decode = %q, %v", test.name, format, err)
+			continue
+		}
+		if got := img.Bounds(); got != image.Rect(0, 0, len(test.want[0]), len(test.want)) {
+			t.Errorf("%s: bounds = %v", test.name, got)
+			continue
+		}
+		for y, row := range test.want {
+			for x, want := range row {
+				if got := color.RGBAModel.Convert(img.At(x, y)); got != want {
+					t.Errorf("%s: pixel (%d, %d) = %v, want %v", test.name, x, y, got, want)
+				}
+			}
+		}
+
+		config, format, err := image.DecodeConfig(bytes.NewReader(test.data))
+		if err != nil || config.Width != len(test.want[0]) || config.Height != len(test.want) {
+			t.Errorf("%s: image.DecodeConfig = %+v, %q, %v", test.name, config, format, err)
+		}
+	}
+}
+
+func TestDecodeAlpha(t *testing.T) {
+	masks := []byte{0, 0, 0xff, 0, 0, 0xff, 0, 0, 0xff, 0, 0, 0, 0, 0, 0, 0xff}
+	data := bmpFile(1, 1, 32, compressionAlpha, masks, []byte{0, 0, 255, 128})
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.At(0, 0), (color.NRGBA{255, 0, 0, 128}); got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := bmpFile(2, 2, 24, compressionNone, nil, make([]byte, 16))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"signature", append([]byte("XX"), valid[2:]...)},
+		{"truncated header", valid[:20]},
+		{"truncated pixels", valid[:len(valid)-4]},
+		{"zero width", bmpFile(0, 2, 24, compressionNone, nil, nil)},
+		{"bits per pixel", bmpFile(2, 2, 7, compressionNone, nil, make([]byte, 16))},
+		{"compression", bmpFile(2, 2, 24, 4, nil, make([]byte, 16))},
+		{"truncated RLE", bmpFile(4, 2, 8, compressionRLE8, palette4, []byte{0, 5, 1})},
+	}
+	for _, test := range tests {
+		if _, err := Decode(bytes.NewReader(test.data)); err == nil {
+			t.Errorf("%s: Decode succeeded", test.name)
+		}
+	}
+}
+
+// load decodes a fixture from testdata.
+func load(t *testing.T, name string) image.Image {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return img
+}
+
+func TestDecodeFixtures(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"video-001.bmp", "video-001.png"},
+		{"colormap.bmp", "colormap.png"},
+		{"colormap-rle8.bmp", "colormap.png"},
+	}
+	for _, test := range tests {
+		got, want := load(t, test.name), load(t, test.want)
+		if got.Bounds() != want.Bounds() {
+			t.Errorf("%s: bounds = %v, want %v", test.name, got.Bounds(), want.Bounds())
+			continue
+		}
+		b := got.Bounds()
+	pixels:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r0, g0, b0, a0 := got.At(x, y).RGBA()
+				r1, g1, b1, a1 := want.At(x, y).RGBA()
+				if r0 != r1 || g0 != g1 || b0 != b1 || a0 != a1 {
+					t.Errorf("%s: pixel (%d, %d) = %v, want %v", test.name, x, y, got.At(x, y), want.At(x, y))
+					break pixels
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+#!/usr/bin/env python3
+"""Converts the uncompressed 8-bit colormap.bmp into colormap-rle8.bmp.
+
+Runs of two or more equal pixels become encoded runs, everything else
+absolute runs, and every row ends with an end of line escape.
+"""
+
+import struct
+
+
+def encode_row(row):
+    out = bytearray()
+    literal = bytearray()
+
+    def flush():
+        while literal:
+            chunk = literal[:255]
+            del literal[:255]
+            if len(chunk) < 3:
+                # Absolute runs are at least three pixels long.
+                for index in chunk:
+                    out.extend((1, index))
+                continue
+            out.extend((0, len(chunk)))
+            out.extend(chunk)
+            if len(chunk) % 2:
+                out.append(0)
+
+    i = 0
+    while i < len(row):
+        j = i
+        while j < len(row) and j - i < 255 and row[j] == row[i]:
+            j += 1
+        if j - i >= 2:
+            flush()
+            out.extend((j - i, row[i]))
+        else:
+            literal.append(row[i])
+        i = j
+    flush()
+    out.extend((0, 0))
+    return out
+
+
+def main():
+    data = open("colormap.bmp", "rb").read()
+    offset = struct.unpack_from("<I", data, 10)[0]
+    width, height, _, bpp, compression = struct.unpack_from("<iiHHI", data, 18)
+    assert bpp == 8 and compression == 0 and height > 0
+    stride = (width + 3) & ~3
+
+    pixels = bytearray()
+    for y in range(height):
+        start = offset + y * stride
+        pixels += encode_row(data[start:start + width])
+    pixels[-2:] = b"\x00\x01"  # the last end of line ends the bitmap
+
+    header = bytearray(data[:offset])
+    struct.pack_into("<I", header, 2, offset + len(pixels))
+    struct.pack_into("<I", header, 30, 1)  # BI_RLE8
+    struct.pack_into("<I", header, 34, len(pixels))
+    open("colormap-rle8.bmp", "wb").write(header + pixels)
+
+
+if __name__ == "__main__":
+    main()
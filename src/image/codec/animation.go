@@ -0,0 +1,65 @@
+package codec
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+)
+
+// Animation is an animated GIF. It implements image.Image by showing its
+// first frame, so it can be cached and passed around like a still image,
+// and is encoded with all its frames as GIF.
+type Animation struct {
+	GIF   *gif.GIF
+	still image.Image
+}
+
+// NewAnimation wraps the decoded frames of an animated GIF.
+func NewAnimation(g *gif.GIF) *Animation {
+	a := &Animation{GIF: g}
+	a.still = a.Frame(0)
+	return a
+}
+
+func (a *Animation) ColorModel() color.Model { return a.still.ColorModel() }
+
+func (a *Animation) Bounds() image.Rectangle { return a.still.Bounds() }
+
+func (a *Animation) At(x, y int) color.Color { return a.still.At(x, y) }
+
+// Len returns the number of frames.
+func (a *Animation) Len() int {
+	return len(a.GIF.Image)
+}
+
+// Frame returns frame n as it is displayed: drawn over what the earlier
+// frames left on the canvas after their disposal. The background of the
+// canvas is transparent, as in web browsers.
+func (a *Animation) Frame(n int) image.Image {
+	canvas := image.NewRGBA(image.Rect(0, 0, a.GIF.Config.Width, a.GIF.Config.Height))
+	var previous *image.RGBA
+	for i, frame := range a.GIF.Image[:n+1] {
+		disposal := byte(0)
+		if i < len(a.GIF.Disposal) {
+			disposal = a.GIF.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious && i < n {
+			previous = image.NewRGBA(canvas.Rect)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if i == n {
+			break
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return canvas
+}
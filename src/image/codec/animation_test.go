@@ -0,0 +1,83 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+var animationPalette = color.Palette{color.Transparent, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}}
+
+// newTestAnimation returns a 20x10 animation: a red background, then a
+// blue square which is cleared after display, then a blue square which
+// is restored to the previous canvas after display.
+func newTestAnimation() *gif.GIF {
+	background := image.NewPaletted(image.Rect(0, 0, 20, 10), animationPalette)
+	for i := range background.Pix {
+		background.Pix[i] = 1
+	}
+	square := func(r image.Rectangle) *image.Paletted {
+		frame := image.NewPaletted(r, animationPalette)
+		for i := range frame.Pix {
+			frame.Pix[i] = 2
+		}
+		return frame
+	}
+	return &gif.GIF{
+		Image:     []*image.Paletted{background, square(image.Rect(0, 0, 5, 5)), square(image.Rect(10, 5, 15, 10)), square(image.Rect(15, 0, 20, 5))},
+		Delay:     []int{10, 20, 30, 40},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		LoopCount: 3,
+		Config:    image.Config{ColorModel: animationPalette, Width: 20, Height: 10},
+	}
+}
+
+func TestAnimationFrame(t *testing.T) {
+	a := NewAnimation(newTestAnimation())
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+
+	tests := []struct {
+		frame int
+		x, y  int
+		want  color.RGBA
+	}{
+		{0, 2, 2, red},
+		{1, 2, 2, blue},
+		{2, 2, 2, color.RGBA{}}, // cleared to the background
+		{2, 12, 7, blue},
+		{3, 12, 7, red}, // restored to the previous canvas
+		{3, 17, 2, blue},
+		{3, 2, 2, color.RGBA{}},
+	}
+	for _, test := range tests {
+		if got := a.Frame(test.frame).At(test.x, test.y); got != test.want {
+			t.Errorf("frame %d at (%d, %d) = %v, want %v", test.frame, test.x, test.y, got, test.want)
+		}
+	}
+	if got := a.At(2, 2); got != red {
+		t.Errorf("animation shows %v, want the first frame", got)
+	}
+}
+
+func TestEncodeAnimation(t *testing.T) {
+	a := NewAnimation(newTestAnimation())
+
+	buffer := new(bytes.Buffer)
+	if err := Encode(buffer, a, GIF, nil); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 4 || g.LoopCount != 3 || g.Delay[3] != 40 || g.Disposal[2] != gif.DisposalPrevious {
+		t.Errorf("animation encoded as %d frames, loop count %d, delays %v, disposal %v", len(g.Image), g.LoopCount, g.Delay, g.Disposal)
+	}
+
+	buffer.Reset()
+	if err := Encode(buffer, a, PNG, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+// Package codec encodes the renditions served by the imageserver.
+package codec
+
+import (
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"image/webp"
+	"io"
+	"strings"
+)
+
+// Names of the supported output formats. They match the format names
+// reported by image.Decode.
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+	GIF  = "gif"
+	WEBP = "webp"
+)
+
+// DefaultQuality is the JPEG quality used when none is requested.
+const DefaultQuality = jpeg.DefaultQuality
+
+// ErrUnsupported is returned when no encoder is available for a format.
+var ErrUnsupported = errors.New("codec: no encoder for format")
+
+// formats lists the supported output formats in order of preference.
+var formats = []string{JPEG, PNG, GIF, WEBP}
+
+var contentTypes = map[string]string{
+	JPEG: "image/jpeg",
+	PNG:  "image/png",
+	GIF:  "image/gif",
+	WEBP: "image/webp",
+}
+
+// Options holds the encoding parameters of a rendition. The zero value
+// selects the defaults of every encoder.
+type Options struct {
+	// Quality of JPEG output, from 1 to 100.
+	Quality int
+	// Progressive selects progressive rather than baseline JPEG output.
+	Progressive bool
+	// Compression is the zlib effort of PNG output.
+	Compression png.CompressionLevel
+	// Colors limits PNG and GIF output to a median cut palette of at most
+	// that many colors, from 2 to 256. Zero keeps PNG output true color.
+	Colors int
+}
+
+var compressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// CompressionLevel returns the PNG compression level called name: one of
+// default, none, fast and best.
+func CompressionLevel(name string) (png.CompressionLevel, bool) {
+	level, ok := compressionLevels[strings.ToLower(name)]
+	return level, ok
+}
+
+// Format returns the canonical name of the format called name, accepting
+// common aliases such as "jpg" and any letter case.
+func Format(name string) (string, bool) {
+	name = strings.ToLower(name)
+	if name == "jpg" {
+		name = JPEG
+	}
+	_, ok := contentTypes[name]
+	return name, ok
+}
+
+// Formats returns the supported output formats in order of preference.
+func Formats() []string {
+	return append([]string(nil), formats...)
+}
+
+// ContentType returns the MIME type of format.
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Encode writes img to w in the given format. Animations keep all their
+// frames as GIF and are reduced to the first frame otherwise. No metadata
+// of the original is carried over: the output holds only what is needed
+// to display it.
+func Encode(w io.Writer, img image.Image, format string, options *Options) error {
+	if options == nil {
+		options = &Options{}
+	}
+	if a, ok := img.(*Animation); ok {
+		if format == GIF {
+			return gif.EncodeAll(w, a.GIF)
+		}
+		img = a.still
+	}
+
+	switch format {
+	case JPEG:
+		quality := options.Quality
+		if quality == 0 {
+			quality = DefaultQuality
+		}
+		if options.Progressive {
+			return encodeProgressive(w, img, quality)
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case PNG:
+		if options.Colors > 0 {
+			img = paletted(img, options.Colors)
+		} else {
+			img = opaque8(img)
+		}
+		encoder := png.Encoder{CompressionLevel: options.Compression}
+		return encoder.Encode(w, img)
+	case GIF:
+		if options.Colors > 0 {
+			return gif.Encode(w, img, &gif.Options{NumColors: options.Colors, Quantizer: MedianCut{}})
+		}
+		return gif.Encode(w, img, nil)
+	case WEBP:
+		return webp.Encode(w, img)
+	}
+	return ErrUnsupported
+}
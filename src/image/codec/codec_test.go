@@ -0,0 +1,50 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"net/http"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		ok     bool
+	}{
+		{"jpeg", JPEG, true},
+		{"JPG", JPEG, true},
+		{"png", PNG, true},
+		{"Gif", GIF, true},
+		{"bmp", "bmp", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		format, ok := Format(test.name)
+		if format != test.format || ok != test.ok {
+			t.Errorf("Format(%q) = %q, %v, want %q, %v", test.name, format, ok, test.format, test.ok)
+		}
+	}
+}
+
+func TestEncodeMatchesContentType(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	img.Set(3, 3, color.RGBA{255, 0, 0, 255})
+
+	for _, format := range []string{JPEG, PNG, GIF} {
+		buffer := new(bytes.Buffer)
+		if err := Encode(buffer, img, format, nil); err != nil {
+			t.Errorf("Encode(%s): %v", format, err)
+			continue
+		}
+		if sniffed := http.DetectContentType(buffer.Bytes()); sniffed != ContentType(format) {
+			t.Errorf("Encode(%s) produced %s, want %s", format, sniffed, ContentType(format))
+		}
+	}
+
+	if err := Encode(new(bytes.Buffer), img, "bmp", nil); err != ErrUnsupported {
+		t.Errorf("Encode(bmp) error = %v, want %v", err, ErrUnsupported)
+	}
+}
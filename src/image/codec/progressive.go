@@ -0,0 +1,530 @@
+package codec
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// The JPEG tables below are those of Annex K of the JPEG specification.
+
+// unscaledQuant holds the luminance and chrominance quantization tables in
+// natural order, for quality 50.
+var unscaledQuant = [2][64]int{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// zigzag maps the zig-zag position of a coefficient to its natural index.
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// huffmanSpec is a Huffman table as stored in a DHT segment: the number of
+// codes of each length from 1 to 16 bits, and the coded values.
+type huffmanSpec struct {
+	count [16]byte
+	value []byte
+}
+
+// huffmanSpecs holds the DC luminance, DC chrominance, AC luminance and AC
+// chrominance tables.
+var huffmanSpecs = [4]huffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 0x7d},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 0x77},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// huffmanCode maps a value to its code and code length.
+type huffmanCode struct {
+	code [256]uint32
+	size [256]uint
+}
+
+func newHuffmanCode(spec huffmanSpec) *huffmanCode {
+	h := new(huffmanCode)
+	code, k := uint32(0), 0
+	for length := uint(1); length <= 16; length++ {
+		for i := 0; i < int(spec.count[length-1]); i++ {
+			h.code[spec.value[k]] = code
+			h.size[spec.value[k]] = length
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return h
+}
+
+var huffmanCodes [4]*huffmanCode
+
+func init() {
+	for i, spec := range huffmanSpecs {
+		huffmanCodes[i] = newHuffmanCode(spec)
+	}
+}
+
+// dctCos[u][x] is C(u)/2 * cos((2x+1)uπ/16), so that applying it along
+// both axes yields the forward DCT of the JPEG specification.
+var dctCos [8][8]float64
+
+func init() {
+	for u := 0; u < 8; u++ {
+		c := 0.5
+		if u == 0 {
+			c = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			dctCos[u][x] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+}
+
+// jpegComponent is a color component of a progressive JPEG.
+type jpegComponent struct {
+	h, v int // sampling factors
+	tq   int // quantization table
+	th   int // Huffman tables, 0 for luminance and 1 for chrominance
+
+	// stride is the number of blocks per row of the MCU-padded grid,
+	// cols and rows the blocks covered by the component itself.
+	stride, cols, rows int
+	// blocks holds the quantized coefficients in zig-zag order.
+	blocks [][64]int32
+}
+
+// encodeProgressive writes img as a progressive JPEG. The coefficients are
+// sent in spectral selection scans: the DC coefficients of all components
+// first, then the low and the high frequencies, so clients can render a
+// coarse preview of a large image early.
+func encodeProgressive(w io.Writer, img image.Image, quality int) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= 0 || height <= 0 || width >= 1<<16 || height >= 1<<16 {
+		return errors.New("codec: invalid image size for JPEG")
+	}
+
+	var quant [2][64]int32
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for i := range quant {
+		for j := range quant[i] {
+			q := (unscaledQuant[i][j]*scale + 50) / 100
+			if q < 1 {
+				q = 1
+			} else if q > 255 {
+				q = 255
+			}
+			quant[i][j] = int32(q)
+		}
+	}
+
+	_, gray := img.(*image.Gray)
+	components := sampleComponents(gray)
+	mcuW, mcuH := 8*components[0].h, 8*components[0].v
+	mcusX, mcusY := (width+mcuW-1)/mcuW, (height+mcuH-1)/mcuH
+
+	for _, c := range components {
+		maxH, maxV := components[0].h, components[0].v
+		c.stride = mcusX * c.h
+		c.cols = ((width*c.h+maxH-1)/maxH + 7) / 8
+		c.rows = ((height*c.v+maxV-1)/maxV + 7) / 8
+	}
+	quantize(components, img, gray, mcusX, mcusY, &quant)
+
+	e := &bitWriter{w: bufio.NewWriter(w)}
+	e.write([]byte{0xff, 0xd8})
+	e.write([]byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0})
+
+	tables := 2
+	if gray {
+		tables = 1
+	}
+	e.write([]byte{0xff, 0xdb, 0, byte(2 + 65*tables)})
+	for i := 0; i < tables; i++ {
+		e.write([]byte{byte(i)})
+		for k := 0; k < 64; k++ {
+			e.write([]byte{byte(quant[i][zigzag[k]])})
+		}
+	}
+
+	sof := []byte{0xff, 0xc2, 0, byte(8 + 3*len(components)), 8,
+		byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(len(components))}
+	for i, c := range components {
+		sof = append(sof, byte(i+1), byte(c.h<<4|c.v), byte(c.tq))
+	}
+	e.write(sof)
+
+	dht := []byte{0xff, 0xc4, 0, 0}
+	for i, spec := range huffmanSpecs {
+		class, index := i/2, i%2
+		if gray && index == 1 {
+			continue
+		}
+		dht = append(dht, byte(class<<4|index))
+		dht = append(dht, spec.count[:]...)
+		dht = append(dht, spec.value...)
+	}
+	dht[2], dht[3] = byte((len(dht)-2)>>8), byte(len(dht)-2)
+	e.write(dht)
+
+	e.dcScan(components, mcusX, mcusY)
+	e.acScan(components, 0, 1, 5)
+	for i := 1; i < len(components); i++ {
+		e.acScan(components, i, 1, 63)
+	}
+	e.acScan(components, 0, 6, 63)
+
+	e.write([]byte{0xff, 0xd9})
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// sampleComponents returns the components of img, luminance only for
+// gray images and with 4:2:0 subsampled chrominance otherwise.
+func sampleComponents(gray bool) []*jpegComponent {
+	if gray {
+		return []*jpegComponent{{h: 1, v: 1}}
+	}
+	return []*jpegComponent{
+		{h: 2, v: 2},
+		{h: 1, v: 1, tq: 1, th: 1},
+		{h: 1, v: 1, tq: 1, th: 1},
+	}
+}
+
+// quantize computes the quantized DCT coefficients of every block of the
+// MCU-padded grid. Samples beyond the image edges repeat the edge pixels.
+func quantize(components []*jpegComponent, img image.Image, gray bool, mcusX, mcusY int, quant *[2][64]int32) {
+	bounds := img.Bounds()
+	maxH, maxV := components[0].h, components[0].v
+	width, height := mcusX*8*maxH, mcusY*8*maxV
+
+	// Full resolution planes of the level-shifted samples.
+	planes := make([][]float64, len(components))
+	for i := range planes {
+		planes[i] = make([]float64, width*height)
+	}
+	at := ycbcrAt(img)
+	for y := 0; y < height; y++ {
+		sy := bounds.Min.Y + minInt(y, bounds.Dy()-1)
+		for x := 0; x < width; x++ {
+			sx := bounds.Min.X + minInt(x, bounds.Dx()-1)
+			yy, cb, cr := at(sx, sy)
+			planes[0][y*width+x] = float64(yy) - 128
+			if !gray {
+				planes[1][y*width+x] = float64(cb) - 128
+				planes[2][y*width+x] = float64(cr) - 128
+			}
+		}
+	}
+
+	var samples, coeffs [64]float64
+	for i, c := range components {
+		fx, fy := maxH/c.h, maxV/c.v
+		rows := mcusY * c.v
+		c.blocks = make([][64]int32, c.stride*rows)
+		q := &quant[c.tq]
+		for by := 0; by < rows; by++ {
+			for bx := 0; bx < c.stride; bx++ {
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						// Average the full resolution samples covered by
+						// a subsampled one.
+						var sum float64
+						px, py := (bx*8+x)*fx, (by*8+y)*fy
+						for dy := 0; dy < fy; dy++ {
+							for dx := 0; dx < fx; dx++ {
+								sum += planes[i][(py+dy)*width+px+dx]
+							}
+						}
+						samples[y*8+x] = sum / float64(fx*fy)
+					}
+				}
+				fdct(&samples, &coeffs)
+				block := &c.blocks[by*c.stride+bx]
+				for k := 0; k < 64; k++ {
+					n := zigzag[k]
+					block[k] = int32(math.Floor(coeffs[n]/float64(q[n]) + 0.5))
+				}
+			}
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// fdct computes the two-dimensional forward DCT of an 8x8 block.
+func fdct(in, out *[64]float64) {
+	var tmp [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < 8; x++ {
+				sum += in[y*8+x] * dctCos[u][x]
+			}
+			tmp[y*8+u] = sum
+		}
+	}
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < 8; y++ {
+				sum += tmp[y*8+u] * dctCos[v][y]
+			}
+			out[v*8+u] = sum
+		}
+	}
+}
+
+// bitWriter writes the entropy-coded segments of a JPEG, stuffing a zero
+// byte after every 0xff.
+type bitWriter struct {
+	w    *bufio.Writer
+	bits uint32
+	n    uint
+	err  error
+}
+
+func (b *bitWriter) write(p []byte) {
+	if b.err == nil {
+		_, b.err = b.w.Write(p)
+	}
+}
+
+func (b *bitWriter) emit(bits uint32, n uint) {
+	b.bits = b.bits<<n | bits&(1<<n-1)
+	b.n += n
+	for b.n >= 8 {
+		c := byte(b.bits >> (b.n - 8))
+		b.write([]byte{c})
+		if c == 0xff {
+			b.write([]byte{0})
+		}
+		b.n -= 8
+	}
+	b.bits &= 1<<b.n - 1
+}
+
+// flush pads the last byte of a scan with one bits.
+func (b *bitWriter) flush() {
+	if b.n > 0 {
+		b.emit(1<<(8-b.n)-1, 8-b.n)
+	}
+}
+
+func (b *bitWriter) emitHuffman(h *huffmanCode, value byte) {
+	b.emit(h.code[value], h.size[value])
+}
+
+// emitValue writes v as a magnitude category coded with h followed by the
+// category's extra bits.
+func (b *bitWriter) emitValue(h *huffmanCode, run int, v int32) {
+	a := v
+	if a < 0 {
+		a = -a
+		v--
+	}
+	category := uint(0)
+	for a > 0 {
+		category++
+		a >>= 1
+	}
+	b.emitHuffman(h, byte(run<<4)|byte(category))
+	if category > 0 {
+		b.emit(uint32(v), category)
+	}
+}
+
+func (b *bitWriter) startScan(components []*jpegComponent, indexes []int, ss, se int) {
+	sos := []byte{0xff, 0xda, 0, byte(6 + 2*len(indexes)), byte(len(indexes))}
+	for _, i := range indexes {
+		c := components[i]
+		sos = append(sos, byte(i+1), byte(c.th<<4|c.th))
+	}
+	b.write(append(sos, byte(ss), byte(se), 0))
+}
+
+// dcScan writes the DC coefficients of all components, interleaved by MCU
+// unless the image has a single component.
+func (b *bitWriter) dcScan(components []*jpegComponent, mcusX, mcusY int) {
+	indexes := make([]int, len(components))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	b.startScan(components, indexes, 0, 0)
+
+	predictions := make([]int32, len(components))
+	code := func(i, bx, by int) {
+		c := components[i]
+		dc := c.blocks[by*c.stride+bx][0]
+		b.emitValue(huffmanCodes[c.th], 0, dc-predictions[i])
+		predictions[i] = dc
+	}
+
+	if len(components) == 1 {
+		c := components[0]
+		for by := 0; by < c.rows; by++ {
+			for bx := 0; bx < c.cols; bx++ {
+				code(0, bx, by)
+			}
+		}
+	} else {
+		for my := 0; my < mcusY; my++ {
+			for mx := 0; mx < mcusX; mx++ {
+				for i, c := range components {
+					for v := 0; v < c.v; v++ {
+						for h := 0; h < c.h; h++ {
+							code(i, mx*c.h+h, my*c.v+v)
+						}
+					}
+				}
+			}
+		}
+	}
+	b.flush()
+}
+
+// acScan writes the AC coefficients ss to se of a single component.
+func (b *bitWriter) acScan(components []*jpegComponent, i, ss, se int) {
+	b.startScan(components, []int{i}, ss, se)
+
+	c := components[i]
+	h := huffmanCodes[2+c.th]
+	for by := 0; by < c.rows; by++ {
+		for bx := 0; bx < c.cols; bx++ {
+			block := &c.blocks[by*c.stride+bx]
+			run := 0
+			for k := ss; k <= se; k++ {
+				if block[k] == 0 {
+					run++
+					continue
+				}
+				for run >= 16 {
+					b.emitHuffman(h, 0xf0)
+					run -= 16
+				}
+				b.emitValue(h, run, block[k])
+				run = 0
+			}
+			if run > 0 {
+				// End of band for this block.
+				b.emitHuffman(h, 0x00)
+			}
+		}
+	}
+	b.flush()
+}
+
+// ycbcrAt returns a function reading the Y'CbCr samples of img.
+func ycbcrAt(img image.Image) func(x, y int) (uint8, uint8, uint8) {
+	switch m := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) (uint8, uint8, uint8) {
+			yi, ci := m.YOffset(x, y), m.COffset(x, y)
+			return m.Y[yi], m.Cb[ci], m.Cr[ci]
+		}
+	case *image.Gray:
+		return func(x, y int) (uint8, uint8, uint8) {
+			return m.Pix[m.PixOffset(x, y)], 128, 128
+		}
+	}
+	return func(x, y int) (uint8, uint8, uint8) {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	}
+}
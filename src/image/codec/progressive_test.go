@@ -0,0 +1,107 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// gradient returns a smooth test image with odd dimensions, so the edge
+// blocks and the subsampled chroma are padded.
+func gradient(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(255 * x / width), uint8(255 * y / height), uint8(128 + 64*math.Sin(float64(x+y)/9)), 255})
+		}
+	}
+	return img
+}
+
+// psnr returns the peak signal-to-noise ratio between two images in dB.
+func psnr(a, b image.Image) float64 {
+	var sum float64
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			for _, d := range []float64{float64(r1>>8) - float64(r2>>8), float64(g1>>8) - float64(g2>>8), float64(b1>>8) - float64(b2>>8)} {
+				sum += d * d
+			}
+		}
+	}
+	mse := sum / float64(3*bounds.Dx()*bounds.Dy())
+	return 10 * math.Log10(255*255/mse)
+}
+
+func TestProgressiveJPEG(t *testing.T) {
+	sources := map[string]image.Image{
+		"rgba":  gradient(93, 61),
+		"ycbcr": nil,
+		"gray":  nil,
+	}
+	ycbcr := image.NewYCbCr(image.Rect(0, 0, 93, 61), image.YCbCrSubsampleRatio420)
+	gray := image.NewGray(image.Rect(0, 0, 93, 61))
+	for y := 0; y < 61; y++ {
+		for x := 0; x < 93; x++ {
+			c := color.YCbCrModel.Convert(sources["rgba"].At(x, y)).(color.YCbCr)
+			ycbcr.Y[ycbcr.YOffset(x, y)] = c.Y
+			ycbcr.Cb[ycbcr.COffset(x, y)] = c.Cb
+			ycbcr.Cr[ycbcr.COffset(x, y)] = c.Cr
+			gray.Pix[gray.PixOffset(x, y)] = c.Y
+		}
+	}
+	sources["ycbcr"], sources["gray"] = ycbcr, gray
+
+	for name, img := range sources {
+		for _, quality := range []int{1, 50, 90, 100} {
+			buffer := new(bytes.Buffer)
+			if err := Encode(buffer, img, JPEG, &Options{Quality: quality, Progressive: true}); err != nil {
+				t.Fatalf("%s q=%d: Encode: %v", name, quality, err)
+			}
+			if !bytes.Contains(buffer.Bytes(), []byte{0xff, 0xc2}) {
+				t.Errorf("%s q=%d: no progressive frame header", name, quality)
+			}
+
+			decoded, err := jpeg.Decode(buffer)
+			if err != nil {
+				t.Fatalf("%s q=%d: decode: %v", name, quality, err)
+			}
+			if decoded.Bounds() != img.Bounds() {
+				t.Fatalf("%s q=%d: bounds = %v, want %v", name, quality, decoded.Bounds(), img.Bounds())
+			}
+			if _, ok := decoded.(*image.Gray); ok != (name == "gray") {
+				t.Errorf("%s q=%d: decoded as %T", name, quality, decoded)
+			}
+			if quality >= 90 {
+				if p := psnr(img, decoded); p < 35 {
+					t.Errorf("%s q=%d: PSNR = %.1f dB, want at least 35", name, quality, p)
+				}
+			}
+		}
+	}
+}
+
+func TestProgressiveMatchesBaseline(t *testing.T) {
+	img := gradient(64, 48)
+
+	baseline, progressive := new(bytes.Buffer), new(bytes.Buffer)
+	Encode(baseline, img, JPEG, &Options{Quality: 80})
+	Encode(progressive, img, JPEG, &Options{Quality: 80, Progressive: true})
+
+	a, err := jpeg.Decode(baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := jpeg.Decode(progressive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := psnr(a, b); p < 35 {
+		t.Errorf("progressive and baseline output differ: PSNR = %.1f dB", p)
+	}
+}
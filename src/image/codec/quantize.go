@@ -0,0 +1,194 @@
+package codec
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// MedianCut is a draw.Quantizer building a palette by median cut: the box
+// holding all colors of the image is split at the median of its longest
+// side until there are as many boxes as requested colors, and each box
+// contributes the mean of its colors.
+type MedianCut struct{}
+
+// bucket accumulates the pixels of a 5 bits per channel RGBA cell.
+type bucket struct {
+	sum   [4]uint64
+	mean  [4]uint8
+	count uint64
+}
+
+// box is a set of buckets spanning a range of each channel.
+type box struct {
+	buckets []*bucket
+	min     [4]uint8
+	max     [4]uint8
+	count   uint64
+}
+
+func newBox(buckets []*bucket) *box {
+	b := &box{buckets: buckets}
+	b.min = [4]uint8{255, 255, 255, 255}
+	for _, k := range buckets {
+		for c := 0; c < 4; c++ {
+			if k.mean[c] < b.min[c] {
+				b.min[c] = k.mean[c]
+			}
+			if k.mean[c] > b.max[c] {
+				b.max[c] = k.mean[c]
+			}
+		}
+		b.count += k.count
+	}
+	return b
+}
+
+// longest returns the channel with the widest range and that range.
+func (b *box) longest() (channel int, width int) {
+	for c := 0; c < 4; c++ {
+		if w := int(b.max[c]) - int(b.min[c]); w > width {
+			channel, width = c, w
+		}
+	}
+	return channel, width
+}
+
+// split divides the box at the weighted median of its longest channel.
+func (b *box) split() (*box, *box) {
+	channel, _ := b.longest()
+	sort.Slice(b.buckets, func(i, j int) bool { return b.buckets[i].mean[channel] < b.buckets[j].mean[channel] })
+
+	var seen uint64
+	i := 0
+	for ; i < len(b.buckets)-2; i++ {
+		seen += b.buckets[i].count
+		if 2*seen >= b.count {
+			break
+		}
+	}
+	return newBox(b.buckets[:i+1]), newBox(b.buckets[i+1:])
+}
+
+func (b *box) color() color.RGBA {
+	var sum [4]uint64
+	for _, k := range b.buckets {
+		for c := range sum {
+			sum[c] += k.sum[c]
+		}
+	}
+	return color.RGBA{
+		uint8((sum[0] + b.count/2) / b.count),
+		uint8((sum[1] + b.count/2) / b.count),
+		uint8((sum[2] + b.count/2) / b.count),
+		uint8((sum[3] + b.count/2) / b.count),
+	}
+}
+
+// Quantize appends up to cap(p)-len(p) colors representing m to p.
+func (MedianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	n := cap(p) - len(p)
+	if n <= 0 {
+		return p
+	}
+
+	cells := make(map[uint32]*bucket)
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := m.At(x, y).RGBA()
+			rgba := [4]uint32{r >> 8, g >> 8, b >> 8, a >> 8}
+			cell := rgba[0]>>3<<15 | rgba[1]>>3<<10 | rgba[2]>>3<<5 | rgba[3]>>3
+			k := cells[cell]
+			if k == nil {
+				k = new(bucket)
+				cells[cell] = k
+			}
+			for c, v := range rgba {
+				k.sum[c] += uint64(v)
+			}
+			k.count++
+		}
+	}
+	if len(cells) == 0 {
+		return p
+	}
+
+	buckets := make([]*bucket, 0, len(cells))
+	for _, k := range cells {
+		for c := range k.mean {
+			k.mean[c] = uint8(k.sum[c] / k.count)
+		}
+		buckets = append(buckets, k)
+	}
+	// Map iteration order is random; sort so the palette is deterministic.
+	sort.Slice(buckets, func(i, j int) bool {
+		a, b := buckets[i].mean, buckets[j].mean
+		for c := range a {
+			if a[c] != b[c] {
+				return a[c] < b[c]
+			}
+		}
+		return false
+	})
+
+	boxes := []*box{newBox(buckets)}
+	for len(boxes) < n {
+		// Split the box whose widest channel spans the most pixels.
+		best, score := -1, 0
+		for i, b := range boxes {
+			if len(b.buckets) < 2 {
+				continue
+			}
+			if _, width := b.longest(); width > 0 && width*int(b.count) > score {
+				best, score = i, width*int(b.count)
+			}
+		}
+		if best < 0 {
+			break
+		}
+		a, b := boxes[best].split()
+		boxes[best] = a
+		boxes = append(boxes, b)
+	}
+
+	for _, b := range boxes {
+		p = append(p, b.color())
+	}
+	return p
+}
+
+// paletted reduces img to a palette of at most colors colors chosen by
+// median cut, diffusing the quantization error.
+func paletted(img image.Image, colors int) *image.Paletted {
+	bounds := img.Bounds()
+	palette := MedianCut{}.Quantize(make(color.Palette, 0, colors), img)
+	if len(palette) == 0 {
+		palette = color.Palette{color.Transparent}
+	}
+	pm := image.NewPaletted(bounds, palette)
+	draw.FloydSteinberg.Draw(pm, bounds, img, bounds.Min)
+	return pm
+}
+
+// opaque8 returns a fully opaque 16-bit image as an 8-bit one, which the
+// PNG encoder writes as 8-bit RGB instead of 16-bit RGB. Other images are
+// returned unchanged.
+func opaque8(img image.Image) image.Image {
+	switch m := img.(type) {
+	case *image.RGBA64:
+		if !m.Opaque() {
+			return img
+		}
+	case *image.NRGBA64:
+		if !m.Opaque() {
+			return img
+		}
+	default:
+		return img
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,104 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestMedianCutKeepsFewColors(t *testing.T) {
+	colors := []color.RGBA{
+		{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {0, 0, 0, 0},
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			img.SetRGBA(x, y, colors[(x/10+y/10)%len(colors)])
+		}
+	}
+
+	pm := paletted(img, 16)
+	if len(pm.Palette) != len(colors) {
+		t.Errorf("palette has %d colors, want %d", len(pm.Palette), len(colors))
+	}
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			if got, want := color.RGBAModel.Convert(pm.At(x, y)), img.At(x, y); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestMedianCutLimitsColors(t *testing.T) {
+	img := gradient(64, 64)
+	for _, n := range []int{2, 16, 256} {
+		palette := MedianCut{}.Quantize(make(color.Palette, 0, n), img)
+		if len(palette) == 0 || len(palette) > n {
+			t.Errorf("Quantize(%d) returned %d colors", n, len(palette))
+		}
+		again := MedianCut{}.Quantize(make(color.Palette, 0, n), img)
+		for i := range palette {
+			if palette[i] != again[i] {
+				t.Fatalf("Quantize(%d) is not deterministic", n)
+			}
+		}
+	}
+}
+
+func TestEncodePNG(t *testing.T) {
+	// An opaque 16-bit image, as produced by the resizer.
+	img := image.NewRGBA64(image.Rect(0, 0, 64, 64))
+	src := gradient(64, 64)
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, src.At(x, y))
+		}
+	}
+
+	decode := func(options *Options) (image.Image, int) {
+		buffer := new(bytes.Buffer)
+		if err := Encode(buffer, img, PNG, options); err != nil {
+			t.Fatal(err)
+		}
+		size := buffer.Len()
+		m, err := png.Decode(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m, size
+	}
+
+	m, defaultSize := decode(nil)
+	if _, ok := m.(*image.RGBA); !ok {
+		t.Errorf("opaque 16-bit image encoded as %T, want 8-bit RGB", m)
+	}
+	if _, size := decode(&Options{Compression: png.NoCompression}); size <= defaultSize {
+		t.Errorf("uncompressed PNG is %d bytes, default %d", size, defaultSize)
+	}
+
+	m, _ = decode(&Options{Colors: 32})
+	pm, ok := m.(*image.Paletted)
+	if !ok {
+		t.Fatalf("?colors output decoded as %T, want a paletted image", m)
+	}
+	if len(pm.Palette) > 32 {
+		t.Errorf("palette has %d colors, want at most 32", len(pm.Palette))
+	}
+
+	translucent := image.NewRGBA64(image.Rect(0, 0, 4, 4))
+	if opaque8(translucent) != image.Image(translucent) {
+		t.Error("a translucent 16-bit image lost its precision")
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	if level, ok := CompressionLevel("Best"); !ok || level != png.BestCompression {
+		t.Errorf("CompressionLevel(Best) = %v, %v", level, ok)
+	}
+	if _, ok := CompressionLevel("max"); ok {
+		t.Error("CompressionLevel accepted an unknown level")
+	}
+}
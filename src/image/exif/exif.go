@@ -0,0 +1,247 @@
+// Package exif reads the EXIF metadata embedded in JPEG images: the
+// fields of the primary image file directory and of the EXIF directory it
+// points to.
+package exif
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// A FormatError reports that the EXIF metadata is not valid.
+type FormatError string
+
+func (e FormatError) Error() string { return "exif: invalid format: " + string(e) }
+
+// ErrNotFound reports a JPEG without EXIF metadata.
+var ErrNotFound = errors.New("exif: no EXIF metadata")
+
+// Tags read by this package.
+const (
+	TagOrientation = 0x0112
+	tagExifIFD     = 0x8769
+)
+
+// Types of field values.
+const (
+	typeByte     = 1
+	typeASCII    = 2
+	typeShort    = 3
+	typeLong     = 4
+	typeRational = 5
+)
+
+var typeSizes = map[uint16]int{typeByte: 1, typeASCII: 1, typeShort: 2, typeLong: 4, typeRational: 8}
+
+// Orientation tells how the stored pixels are turned relative to the
+// intended view, named by where the first row and column of the pixels
+// belong.
+type Orientation int
+
+const (
+	TopLeft     Orientation = 1 + iota // as stored
+	TopRight                           // mirrored horizontally
+	BottomRight                        // turned by 180 degrees
+	BottomLeft                         // mirrored vertically
+	LeftTop                            // mirrored along the main diagonal
+	RightTop                           // turned 90 degrees counterclockwise
+	RightBottom                        // mirrored along the other diagonal
+	LeftBottom                         // turned 90 degrees clockwise
+)
+
+// Correction returns how to bring stored pixels in orientation o into
+// view: transposing them along their main diagonal first, then mirroring
+// them horizontally and vertically.
+func (o Orientation) Correction() (transpose, flipX, flipY bool) {
+	switch o {
+	case TopRight:
+		return false, true, false
+	case BottomRight:
+		return false, true, true
+	case BottomLeft:
+		return false, false, true
+	case LeftTop:
+		return true, false, false
+	case RightTop:
+		return true, true, false
+	case RightBottom:
+		return true, true, true
+	case LeftBottom:
+		return true, false, true
+	}
+	return false, false, false
+}
+
+type field struct {
+	typ   uint16
+	count int
+	value []byte
+}
+
+// Exif holds the fields of EXIF metadata by tag.
+type Exif struct {
+	order  binary.ByteOrder
+	fields map[uint16]field
+}
+
+// Decode reads the EXIF metadata of the JPEG image read from r. It stops
+// reading at the image data and returns ErrNotFound when there is no
+// metadata before it.
+func Decode(r io.Reader) (*Exif, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+		return nil, FormatError("not a JPEG")
+	}
+
+	for {
+		marker, err := readMarker(br)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case marker == 0xda || marker == 0xd9: // start of scan, end of image
+			return nil, ErrNotFound
+		case marker >= 0xd0 && marker <= 0xd7 || marker == 0x01: // no payload
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return nil, FormatError("truncated segment")
+		}
+		n := int(binary.BigEndian.Uint16(length[:])) - 2
+		if n < 0 {
+			return nil, FormatError("invalid segment length")
+		}
+		if marker != 0xe1 {
+			if _, err := br.Discard(n); err != nil {
+				return nil, FormatError("truncated segment")
+			}
+			continue
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return nil, FormatError("truncated segment")
+		}
+		if len(payload) >= 6 && string(payload[:6]) == "Exif\x00\x00" {
+			return Parse(payload[6:])
+		}
+	}
+}
+
+// readMarker reads the next marker, skipping fill bytes.
+func readMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil || b != 0xff {
+		return 0, FormatError("missing marker")
+	}
+	for b == 0xff {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, FormatError("missing marker")
+		}
+	}
+	return b, nil
+}
+
+// Parse reads EXIF metadata from its TIFF structure, the payload of a
+// JPEG APP1 segment after its "Exif\x00\x00" header.
+func Parse(data []byte) (*Exif, error) {
+	if len(data) < 8 {
+		return nil, FormatError("truncated header")
+	}
+	x := &Exif{fields: make(map[uint16]field)}
+	switch string(data[:4]) {
+	case "II*\x00":
+		x.order = binary.LittleEndian
+	case "MM\x00*":
+		x.order = binary.BigEndian
+	default:
+		return nil, FormatError("invalid byte order")
+	}
+
+	if err := x.readIFD(data, x.order.Uint32(data[4:])); err != nil {
+		return nil, err
+	}
+	if offset, ok := x.Uint(tagExifIFD); ok {
+		if err := x.readIFD(data, offset); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+// readIFD adds the fields of the image file directory at offset. Fields
+// of unknown types are skipped.
+func (x *Exif) readIFD(data []byte, offset uint32) error {
+	ifd := int64(offset)
+	if ifd+2 > int64(len(data)) {
+		return FormatError("directory offset beyond end of data")
+	}
+	entries := int64(x.order.Uint16(data[ifd:]))
+	if ifd+2+12*entries > int64(len(data)) {
+		return FormatError("truncated directory")
+	}
+	for i := int64(0); i < entries; i++ {
+		entry := data[ifd+2+12*i:]
+		tag, typ := x.order.Uint16(entry), x.order.Uint16(entry[2:])
+		count := int64(x.order.Uint32(entry[4:]))
+		size, ok := typeSizes[typ]
+		if !ok {
+			continue
+		}
+		length := int64(size) * count
+		value := entry[8:12]
+		if length > 4 {
+			start := int64(x.order.Uint32(entry[8:]))
+			if start+length > int64(len(data)) {
+				return FormatError("field value beyond end of data")
+			}
+			value = data[start : start+length]
+		}
+		x.fields[tag] = field{typ: typ, count: int(count), value: value[:length]}
+	}
+	return nil
+}
+
+// Uint returns the first value of an integer field.
+func (x *Exif) Uint(tag uint16) (uint32, bool) {
+	f, ok := x.fields[tag]
+	if !ok || f.count == 0 {
+		return 0, false
+	}
+	switch f.typ {
+	case typeByte:
+		return uint32(f.value[0]), true
+	case typeShort:
+		return uint32(x.order.Uint16(f.value)), true
+	case typeLong:
+		return x.order.Uint32(f.value), true
+	}
+	return 0, false
+}
+
+// String returns the value of a text field.
+func (x *Exif) String(tag uint16) (string, bool) {
+	f, ok := x.fields[tag]
+	if !ok || f.typ != typeASCII {
+		return "", false
+	}
+	value := f.value
+	for len(value) > 0 && value[len(value)-1] == 0 {
+		value = value[:len(value)-1]
+	}
+	return string(value), true
+}
+
+// Orientation returns the orientation of the image, TopLeft when it is
+// missing or invalid.
+func (x *Exif) Orientation() Orientation {
+	o, ok := x.Uint(TagOrientation)
+	if !ok || o < uint32(TopLeft) || o > uint32(LeftBottom) {
+		return TopLeft
+	}
+	return Orientation(o)
+}
@@ -0,0 +1,128 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// app1 returns an EXIF APP1 segment with an orientation and an EXIF
+// directory holding a date, in the given byte order.
+func app1(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := new(bytes.Buffer)
+	if order == binary.LittleEndian {
+		tiff.WriteString("II*\x00")
+	} else {
+		tiff.WriteString("MM\x00*")
+	}
+	write := func(v interface{}) { binary.Write(tiff, order, v) }
+	write(uint32(8))
+
+	// IFD0 at 8: orientation and the EXIF directory pointer.
+	write(uint16(2))
+	write([]uint16{TagOrientation, typeShort})
+	write(uint32(1))
+	write([]uint16{orientation, 0})
+	write([]uint16{tagExifIFD, typeLong})
+	write(uint32(1))
+	write(uint32(38))
+	write(uint32(0))
+
+	// EXIF IFD at 38: DateTimeOriginal, stored at 56.
+	write(uint16(1))
+	write([]uint16{0x9003, typeASCII})
+	write(uint32(20))
+	write(uint32(56))
+	write(uint32(0))
+	tiff.WriteString("2026:10:17 12:00:00\x00")
+
+	segment := []byte{0xff, 0xe1, 0, 0}
+	segment = append(segment, "Exif\x00\x00"...)
+	segment = append(segment, tiff.Bytes()...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	return segment
+}
+
+func encodeJPEG(t *testing.T, segments ...[]byte) []byte {
+	buffer := new(bytes.Buffer)
+	if err := jpeg.Encode(buffer, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	out := append([]byte{}, data[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, data[2:]...)
+}
+
+func TestDecode(t *testing.T) {
+	comment := []byte{0xff, 0xfe, 0, 7, 'h', 'e', 'l', 'l', 'o'}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		x, err := Decode(bytes.NewReader(encodeJPEG(t, comment, app1(order, uint16(RightTop)))))
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+		if o := x.Orientation(); o != RightTop {
+			t.Errorf("%v: orientation = %d, want %d", order, o, RightTop)
+		}
+		if date, ok := x.String(0x9003); date != "2026:10:17 12:00:00" || !ok {
+			t.Errorf("%v: date = %q, %v", order, date, ok)
+		}
+	}
+
+	if _, err := Decode(bytes.NewReader(encodeJPEG(t, comment))); err != ErrNotFound {
+		t.Errorf("JPEG without EXIF: error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := Decode(bytes.NewReader([]byte("GIF89a"))); err == nil {
+		t.Error("a GIF was read as a JPEG")
+	}
+
+	segment := app1(binary.BigEndian, uint16(LeftBottom))
+	truncated := append([]byte{}, segment[:len(segment)-24]...)
+	binary.BigEndian.PutUint16(truncated[2:], uint16(len(truncated)-2))
+	if _, err := Decode(bytes.NewReader(encodeJPEG(t, truncated))); err == nil {
+		t.Error("truncated EXIF was read without error")
+	}
+
+	x, err := Decode(bytes.NewReader(encodeJPEG(t, app1(binary.LittleEndian, 9))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := x.Orientation(); o != TopLeft {
+		t.Errorf("invalid orientation read as %d, want %d", o, TopLeft)
+	}
+}
+
+func TestCorrection(t *testing.T) {
+	// Where the pixel stored at the top right of a 2×1 image ends up when
+	// the correction is applied, as (x, y) of the corrected image.
+	want := map[Orientation]image.Point{
+		TopLeft:     {1, 0},
+		TopRight:    {0, 0},
+		BottomRight: {0, 0},
+		BottomLeft:  {1, 0},
+		LeftTop:     {0, 1},
+		RightTop:    {0, 1},
+		RightBottom: {0, 0},
+		LeftBottom:  {0, 0},
+	}
+	for o, p := range want {
+		transpose, flipX, flipY := o.Correction()
+		x, y, size := 1, 0, image.Pt(2, 1)
+		if transpose {
+			x, y, size = y, x, image.Pt(size.Y, size.X)
+		}
+		if flipX {
+			x = size.X - 1 - x
+		}
+		if flipY {
+			y = size.Y - 1 - y
+		}
+		if (image.Point{x, y}) != p {
+			t.Errorf("orientation %d moves the top right pixel to (%d, %d), want %v", o, x, y, p)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Adjustments are per-pixel color changes, applied in the order of the
+// fields. The zero value changes nothing.
+type Adjustments struct {
+	// Brightness is added to the luma, as a fraction of its range from
+	// -1 to 1.
+	Brightness float64
+	// Contrast stretches the luma about middle gray by 1+Contrast. -1
+	// leaves a flat gray.
+	Contrast float64
+	// Gamma raises the red, green and blue levels to the power 1/Gamma,
+	// brightening the image for values above 1. Zero stands for 1.
+	Gamma float64
+	// Saturation scales the chroma by 1+Saturation. -1 leaves a gray
+	// image.
+	Saturation float64
+	// Hue turns the chroma by degrees, from red towards yellow and green.
+	Hue float64
+}
+
+func (a Adjustments) gamma() float64 {
+	if a.Gamma == 0 {
+		return 1
+	}
+	return a.Gamma
+}
+
+// luma changes a luma level between 0 and 1 by the brightness and
+// contrast.
+func (a Adjustments) luma(y float64) float64 {
+	return (y+a.Brightness-0.5)*(1+a.Contrast) + 0.5
+}
+
+// chroma returns the matrix scaling and turning the chroma.
+func (a Adjustments) chroma() [4]float64 {
+	sin, cos := math.Sincos(a.Hue * math.Pi / 180)
+	s := 1 + a.Saturation
+	return [4]float64{s * cos, -s * sin, s * sin, s * cos}
+}
+
+// Color applies the adjustments to a single color, like Adjust does to
+// images other than YCbCr ones.
+func (a Adjustments) Color(c color.Color) color.Color {
+	r, g, b, alpha := c.RGBA()
+	if alpha == 0 {
+		return color.NRGBA64{}
+	}
+	rgb := [3]float64{float64(r) / float64(alpha), float64(g) / float64(alpha), float64(b) / float64(alpha)}
+	rgb = a.adjustRGB(rgb, a.chroma())
+	return color.NRGBA64{
+		R: uint16(math.Round(rgb[0] * 0xffff)),
+		G: uint16(math.Round(rgb[1] * 0xffff)),
+		B: uint16(math.Round(rgb[2] * 0xffff)),
+		A: uint16(alpha),
+	}
+}
+
+// adjustRGB applies the adjustments to levels between 0 and 1, moving the
+// luma by the brightness and contrast and the chroma by the saturation and
+// hue as the YCbCr path does. The result is clamped to 0 to 1.
+func (a Adjustments) adjustRGB(rgb [3]float64, chroma [4]float64) [3]float64 {
+	y := 0.299*rgb[0] + 0.587*rgb[1] + 0.114*rgb[2]
+	if a.Brightness != 0 || a.Contrast != 0 {
+		delta := a.luma(y) - y
+		for c := range rgb {
+			rgb[c] = clamp01(rgb[c] + delta)
+		}
+	}
+	if gamma := a.gamma(); gamma != 1 {
+		for c := range rgb {
+			rgb[c] = math.Pow(clamp01(rgb[c]), 1/gamma)
+		}
+	}
+	if a.Saturation != 0 || a.Hue != 0 {
+		y = 0.299*rgb[0] + 0.587*rgb[1] + 0.114*rgb[2]
+		cb := -0.168736*rgb[0] - 0.331264*rgb[1] + 0.5*rgb[2]
+		cr := 0.5*rgb[0] - 0.418688*rgb[1] - 0.081312*rgb[2]
+		cb, cr = chroma[0]*cb+chroma[1]*cr, chroma[2]*cb+chroma[3]*cr
+		rgb = [3]float64{y + 1.402*cr, y - 0.344136*cb - 0.714136*cr, y + 1.772*cb}
+	}
+	for c := range rgb {
+		rgb[c] = clamp01(rgb[c])
+	}
+	return rgb
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// Adjust applies the adjustments to an image. YCbCr images are adjusted
+// in 8 bits and stay YCbCr: the brightness and contrast change only their
+// luma and the saturation and hue only their chroma. Other images are
+// adjusted in 16 bits and become RGBA64 images, keeping their alpha.
+func Adjust(img *image.Image, a Adjustments) *image.Image {
+	if a == (Adjustments{}) || a == (Adjustments{Gamma: 1}) {
+		return img
+	}
+
+	var result image.Image
+	chroma := a.chroma()
+	if input, ok := (*img).(*image.YCbCr); ok {
+		out := imageYCbCrToYCC(input)
+		adjustYCC(out, a, chroma)
+		result = out.YCbCr()
+	} else {
+		out := image.NewRGBA64((*img).Bounds())
+		draw.Draw(out, out.Rect, *img, out.Rect.Min, draw.Src)
+		parallel(out, func(slice image.Image) {
+			adjustRGBA64(slice.(*image.RGBA64), a, chroma)
+		})
+		result = out
+	}
+	return &result
+}
+
+// adjustYCC adjusts p in place, through lookup tables for the luma and,
+// with a gamma, the red, green and blue levels.
+func adjustYCC(p *ycc, a Adjustments, chroma [4]float64) {
+	var luma, gamma [256]uint8
+	for i := range luma {
+		luma[i] = uint8(math.Round(clamp01(a.luma(float64(i)/255)) * 255))
+		gamma[i] = uint8(math.Round(math.Pow(float64(i)/255, 1/a.gamma()) * 255))
+	}
+	var m [4]int32
+	for i, v := range chroma {
+		m[i] = int32(math.Round(v * (1 << 16)))
+	}
+	adjustLuma := a.Brightness != 0 || a.Contrast != 0
+	adjustGamma := a.gamma() != 1
+	adjustChroma := a.Saturation != 0 || a.Hue != 0
+
+	parallel(p, func(slice image.Image) {
+		r := slice.Bounds()
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i := p.PixOffset(r.Min.X, y)
+			for x := r.Min.X; x < r.Max.X; x, i = x+1, i+3 {
+				yy, cb, cr := p.Pix[i], p.Pix[i+1], p.Pix[i+2]
+				if adjustLuma {
+					yy = luma[yy]
+				}
+				if adjustGamma {
+					red, green, blue := color.YCbCrToRGB(yy, cb, cr)
+					yy, cb, cr = color.RGBToYCbCr(gamma[red], gamma[green], gamma[blue])
+				}
+				if adjustChroma {
+					u, v := int32(cb)-128, int32(cr)-128
+					cb = clampUint8((m[0]*u+m[1]*v+1<<15)>>16 + 128)
+					cr = clampUint8((m[2]*u+m[3]*v+1<<15)>>16 + 128)
+				}
+				p.Pix[i], p.Pix[i+1], p.Pix[i+2] = yy, cb, cr
+			}
+		}
+	})
+}
+
+// adjustRGBA64 adjusts p in place, on the colors divided by their alpha.
+func adjustRGBA64(p *image.RGBA64, a Adjustments, chroma [4]float64) {
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		i := p.PixOffset(p.Rect.Min.X, y)
+		for x := p.Rect.Min.X; x < p.Rect.Max.X; x, i = x+1, i+8 {
+			alpha := float64(uint16(p.Pix[i+6])<<8 | uint16(p.Pix[i+7]))
+			if alpha == 0 {
+				continue
+			}
+			var rgb [3]float64
+			for c := range rgb {
+				rgb[c] = float64(uint16(p.Pix[i+2*c])<<8|uint16(p.Pix[i+2*c+1])) / alpha
+			}
+			rgb = a.adjustRGB(rgb, chroma)
+			for c, v := range rgb {
+				level := uint16(math.Round(v * alpha))
+				p.Pix[i+2*c], p.Pix[i+2*c+1] = uint8(level>>8), uint8(level)
+			}
+		}
+	}
+}
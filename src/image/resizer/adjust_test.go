@@ -0,0 +1,92 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestAdjustYCbCr(t *testing.T) {
+	tests := []struct {
+		adjustments Adjustments
+		in, want    color.YCbCr
+	}{
+		{Adjustments{}, color.YCbCr{100, 90, 160}, color.YCbCr{100, 90, 160}},
+		// 100 + 0.1 × 255
+		{Adjustments{Brightness: 0.1}, color.YCbCr{100, 90, 160}, color.YCbCr{126, 90, 160}},
+		{Adjustments{Brightness: -0.5}, color.YCbCr{100, 90, 160}, color.YCbCr{0, 90, 160}},
+		// (200 - 127.5) × 1.5 + 127.5
+		{Adjustments{Contrast: 0.5}, color.YCbCr{200, 90, 160}, color.YCbCr{236, 90, 160}},
+		{Adjustments{Contrast: -1}, color.YCbCr{200, 90, 160}, color.YCbCr{128, 90, 160}},
+		// 255 × (64 / 255)^(1/2)
+		{Adjustments{Gamma: 2}, color.YCbCr{64, 128, 128}, color.YCbCr{128, 128, 128}},
+		{Adjustments{Saturation: -1}, color.YCbCr{100, 90, 160}, color.YCbCr{100, 128, 128}},
+		// 128 + 1.5 × (90 - 128), 128 + 1.5 × (160 - 128)
+		{Adjustments{Saturation: 0.5}, color.YCbCr{100, 90, 160}, color.YCbCr{100, 71, 176}},
+		{Adjustments{Hue: 180}, color.YCbCr{100, 90, 160}, color.YCbCr{100, 166, 96}},
+		{Adjustments{Hue: 90}, color.YCbCr{100, 90, 160}, color.YCbCr{100, 96, 90}},
+	}
+	for _, test := range tests {
+		src := image.NewYCbCr(image.Rect(0, 0, 4, 4), image.YCbCrSubsampleRatio420)
+		for i := range src.Y {
+			src.Y[i] = test.in.Y
+		}
+		for i := range src.Cb {
+			src.Cb[i], src.Cr[i] = test.in.Cb, test.in.Cr
+		}
+		var img image.Image = src
+		adjusted, ok := (*Adjust(&img, test.adjustments)).(*image.YCbCr)
+		if !ok || adjusted.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+			t.Fatalf("%+v: result is not a 4:2:0 YCbCr image", test.adjustments)
+		}
+		if got := adjusted.YCbCrAt(3, 3); got != test.want {
+			t.Errorf("%+v: %v adjusted to %v, want %v", test.adjustments, test.in, got, test.want)
+		}
+	}
+}
+
+func TestAdjustRGBA64(t *testing.T) {
+	tests := []struct {
+		adjustments Adjustments
+		in, want    color.NRGBA
+	}{
+		// The luma of red is 0.299 × 255.
+		{Adjustments{Saturation: -1}, color.NRGBA{255, 0, 0, 255}, color.NRGBA{76, 76, 76, 255}},
+		// Brightness moves every channel by the change of the luma, of
+		// the color divided by its alpha.
+		{Adjustments{Brightness: 0.1}, color.NRGBA{200, 100, 50, 128}, color.NRGBA{226, 126, 76, 128}},
+		{Adjustments{Contrast: 1}, color.NRGBA{64, 64, 64, 255}, color.NRGBA{0, 0, 0, 255}},
+		{Adjustments{Gamma: 0.5}, color.NRGBA{128, 255, 0, 255}, color.NRGBA{64, 255, 0, 255}},
+		// Cb -0.106 and Cr 0.314 turn to -0.219 and -0.248 about a luma of
+		// 0.345, out of the gamut for red and blue.
+		{Adjustments{Hue: 120}, color.NRGBA{200, 40, 40, 255}, color.NRGBA{0, 152, 0, 255}},
+		{Adjustments{Brightness: 1}, color.NRGBA{10, 20, 30, 0}, color.NRGBA{0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		src := image.NewNRGBA(image.Rect(0, 0, 3, 3))
+		for i := 0; i < len(src.Pix); i += 4 {
+			src.Pix[i], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3] = test.in.R, test.in.G, test.in.B, test.in.A
+		}
+		var img image.Image = src
+		adjusted, ok := (*Adjust(&img, test.adjustments)).(*image.RGBA64)
+		if !ok {
+			t.Fatalf("%+v: result is not RGBA64", test.adjustments)
+		}
+		got := color.NRGBAModel.Convert(adjusted.At(1, 1)).(color.NRGBA)
+		if !near(got, test.want, 1) {
+			t.Errorf("%+v: %v adjusted to %v, want %v", test.adjustments, test.in, got, test.want)
+		}
+		if single := color.NRGBAModel.Convert(test.adjustments.Color(test.in)).(color.NRGBA); !near(single, got, 1) {
+			t.Errorf("%+v: Color gives %v, Adjust %v", test.adjustments, single, got)
+		}
+	}
+}
+
+func near(a, b color.NRGBA, tolerance int) bool {
+	for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A)} {
+		if d < -tolerance || d > tolerance {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Affine is a two-dimensional affine transform. It maps the point (x, y)
+// to (a[0]*x + a[1]*y + a[2], a[3]*x + a[4]*y + a[5]).
+type Affine [6]float64
+
+// Identity is the transform that leaves every point where it is.
+var Identity = Affine{1, 0, 0, 0, 1, 0}
+
+// Then returns the transform that applies a and then b.
+func (a Affine) Then(b Affine) Affine {
+	return Affine{
+		b[0]*a[0] + b[1]*a[3], b[0]*a[1] + b[1]*a[4], b[0]*a[2] + b[1]*a[5] + b[2],
+		b[3]*a[0] + b[4]*a[3], b[3]*a[1] + b[4]*a[4], b[3]*a[2] + b[4]*a[5] + b[5],
+	}
+}
+
+// Translate returns a followed by a move by (dx, dy).
+func (a Affine) Translate(dx, dy float64) Affine {
+	return a.Then(Affine{1, 0, dx, 0, 1, dy})
+}
+
+// Scale returns a followed by a scaling by sx and sy about the origin.
+func (a Affine) Scale(sx, sy float64) Affine {
+	return a.Then(Affine{sx, 0, 0, 0, sy, 0})
+}
+
+// Rotate returns a followed by a rotation by degrees about the origin,
+// clockwise on an image whose y axis points down.
+func (a Affine) Rotate(degrees float64) Affine {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	return a.Then(Affine{cos, -sin, 0, sin, cos, 0})
+}
+
+// Apply returns where a maps the point (x, y).
+func (a Affine) Apply(x, y float64) (float64, float64) {
+	return a[0]*x + a[1]*y + a[2], a[3]*x + a[4]*y + a[5]
+}
+
+// Invert returns the transform undoing a. It fails when a collapses the
+// plane onto a line or a point.
+func (a Affine) Invert() (Affine, bool) {
+	det := a[0]*a[4] - a[1]*a[3]
+	if det == 0 || math.IsNaN(det) || math.IsInf(det, 0) {
+		return Affine{}, false
+	}
+	return Affine{
+		a[4] / det, -a[1] / det, (a[1]*a[5] - a[4]*a[2]) / det,
+		-a[3] / det, a[0] / det, (a[3]*a[2] - a[0]*a[5]) / det,
+	}, true
+}
+
+// Rotate turns an image clockwise by degrees about its center. With
+// expand the canvas grows to hold all of the turned image, otherwise it
+// keeps the size of the original and the corners are cut off. Turns by
+// multiples of 90 degrees that fit the canvas are exact, as Reorient
+// does them; others are sampled with options.Filter and the uncovered
+// corners are filled with options.Background.
+func Rotate(img *image.Image, degrees float64, expand bool, options Options) *image.Image {
+	degrees = math.Mod(degrees, 360)
+	if quarters := degrees / 90; quarters == math.Trunc(quarters) && (expand || int(quarters)%2 == 0) {
+		o, _ := Rotation(int(quarters) * 90)
+		return Reorient(img, o)
+	}
+
+	bounds := (*img).Bounds()
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	size := bounds.Size()
+	if expand {
+		sin, cos := math.Sincos(degrees * math.Pi / 180)
+		sin, cos = math.Abs(sin), math.Abs(cos)
+		// Round away the error of sin and cos before growing to whole
+		// pixels.
+		size = image.Pt(int(math.Ceil(w*cos+h*sin-1e-9)), int(math.Ceil(w*sin+h*cos-1e-9)))
+	}
+	m := Identity.Translate(-w/2, -h/2).Rotate(degrees).Translate(float64(size.X)/2, float64(size.Y)/2)
+	return Transform(img, m, size, options)
+}
+
+// Transform maps an image through m onto a canvas of the given size. The
+// coordinates m works on start at the top left corner of the image and of
+// the canvas, with pixel centers at half-integers. Every pixel of the
+// canvas is sampled from the image with options.Filter at its scale, and
+// the parts of the canvas the image does not cover are filled with
+// options.Background, nil being transparent. YCbCr images on an opaque
+// background become 4:4:4 YCbCr images, others RGBA64 images.
+func Transform(img *image.Image, m Affine, size image.Point, options Options) *image.Image {
+	background := options.Background
+	if background == nil {
+		background = color.Transparent
+	}
+	r := image.Rectangle{Max: size}
+	inverse, ok := m.Invert()
+	if !ok {
+		var result image.Image = image.NewRGBA64(r)
+		draw.Draw(result.(draw.Image), r, image.NewUniform(background), image.Point{}, draw.Src)
+		return &result
+	}
+	taps, kernel := options.Filter.kernel()
+
+	var result image.Image
+	if input, ok := (*img).(*image.YCbCr); ok && opaque(background) {
+		in := imageYCbCrToYCC(input)
+		out := newYCC(r, image.YCbCrSubsampleRatio444)
+		fill := color.YCbCrModel.Convert(background).(color.YCbCr)
+		parallel(out, func(slice image.Image) {
+			transformYCC(in, slice.(*ycc), inverse, taps, kernel, fill)
+		})
+		result = out.YCbCr()
+	} else {
+		in, ok := (*img).(*image.RGBA64)
+		if !ok {
+			in = image.NewRGBA64((*img).Bounds())
+			draw.Draw(in, in.Rect, *img, in.Rect.Min, draw.Src)
+		}
+		out := image.NewRGBA64(r)
+		fill := color.RGBA64Model.Convert(background).(color.RGBA64)
+		parallel(out, func(slice image.Image) {
+			transformRGBA64(in, slice.(*image.RGBA64), inverse, taps, kernel, fill)
+		})
+		result = out
+	}
+	return &result
+}
+
+func opaque(c color.Color) bool {
+	_, _, _, a := c.RGBA()
+	return a == 0xffff
+}
+
+// tapWeights sets the weights of the taps of kernel around the source
+// coordinate u and returns the index of the first tap.
+func tapWeights(u float64, weights []float64, kernel func(float64) float64) int {
+	c := u - 0.5
+	first := int(math.Floor(c)) - len(weights)/2 + 1
+	for i := range weights {
+		weights[i] = kernel(c - float64(first+i))
+	}
+	return first
+}
+
+// coverage returns the share of the weights that fell on the image, which
+// the background fills the rest of.
+func coverage(inside, total float64) float64 {
+	return math.Max(0, math.Min(1, inside/total))
+}
+
+// transformYCC samples every pixel of out from in through inverse, which
+// maps out to in.
+func transformYCC(in, out *ycc, inverse Affine, taps int, kernel func(float64) float64, fill color.YCbCr) {
+	size := in.Rect.Size()
+	background := [3]float64{float64(fill.Y), float64(fill.Cb), float64(fill.Cr)}
+	wx, wy := make([]float64, taps), make([]float64, taps)
+	for y := out.Rect.Min.Y; y < out.Rect.Max.Y; y++ {
+		for x := out.Rect.Min.X; x < out.Rect.Max.X; x++ {
+			u, v := inverse.Apply(float64(x)+0.5, float64(y)+0.5)
+			x0, y0 := tapWeights(u, wx, kernel), tapWeights(v, wy, kernel)
+			var sum [3]float64
+			var inside, total float64
+			for j, wj := range wy {
+				for i, wi := range wx {
+					w := wi * wj
+					total += w
+					sx, sy := x0+i, y0+j
+					if w == 0 || sx < 0 || sy < 0 || sx >= size.X || sy >= size.Y {
+						continue
+					}
+					inside += w
+					k := sy*in.Stride + sx*3
+					sum[0] += w * float64(in.Pix[k])
+					sum[1] += w * float64(in.Pix[k+1])
+					sum[2] += w * float64(in.Pix[k+2])
+				}
+			}
+			rest := 1 - coverage(inside, total)
+			k := out.PixOffset(x, y)
+			for c := range sum {
+				out.Pix[k+c] = clampUint8(int32(math.Round(sum[c]/total + rest*background[c])))
+			}
+		}
+	}
+}
+
+// transformRGBA64 is transformYCC for RGBA64 images, in premultiplied
+// 16-bit color.
+func transformRGBA64(in, out *image.RGBA64, inverse Affine, taps int, kernel func(float64) float64, fill color.RGBA64) {
+	size := in.Rect.Size()
+	background := [4]float64{float64(fill.R), float64(fill.G), float64(fill.B), float64(fill.A)}
+	wx, wy := make([]float64, taps), make([]float64, taps)
+	for y := out.Rect.Min.Y; y < out.Rect.Max.Y; y++ {
+		for x := out.Rect.Min.X; x < out.Rect.Max.X; x++ {
+			u, v := inverse.Apply(float64(x)+0.5, float64(y)+0.5)
+			x0, y0 := tapWeights(u, wx, kernel), tapWeights(v, wy, kernel)
+			var sum [4]float64
+			var inside, total float64
+			for j, wj := range wy {
+				for i, wi := range wx {
+					w := wi * wj
+					total += w
+					sx, sy := x0+i, y0+j
+					if w == 0 || sx < 0 || sy < 0 || sx >= size.X || sy >= size.Y {
+						continue
+					}
+					inside += w
+					k := sy*in.Stride + sx*8
+					for c := range sum {
+						sum[c] += w * float64(uint16(in.Pix[k+2*c])<<8|uint16(in.Pix[k+2*c+1]))
+					}
+				}
+			}
+			rest := 1 - coverage(inside, total)
+			var value [4]uint16
+			for c := range sum {
+				value[c] = clampUint16(int64(math.Round(sum[c]/total + rest*background[c])))
+			}
+			for c := 0; c < 3; c++ {
+				if value[c] > value[3] {
+					value[c] = value[3]
+				}
+			}
+			k := out.PixOffset(x, y)
+			for c, v := range value {
+				out.Pix[k+2*c] = uint8(v >> 8)
+				out.Pix[k+2*c+1] = uint8(v)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestAffine(t *testing.T) {
+	m := Identity.Scale(2, 3).Rotate(30).Translate(5, -7)
+	inverse, ok := m.Invert()
+	if !ok {
+		t.Fatal("Invert failed")
+	}
+	for _, p := range [][2]float64{{0, 0}, {1, 0}, {-4, 9.5}} {
+		x, y := m.Then(inverse).Apply(p[0], p[1])
+		if math.Abs(x-p[0]) > 1e-9 || math.Abs(y-p[1]) > 1e-9 {
+			t.Errorf("m then its inverse moves %v to (%g, %g)", p, x, y)
+		}
+	}
+
+	// Clockwise with the y axis pointing down turns the x axis into the
+	// y axis.
+	if x, y := Identity.Rotate(90).Apply(1, 0); math.Abs(x) > 1e-9 || math.Abs(y-1) > 1e-9 {
+		t.Errorf("Rotate(90) moves (1, 0) to (%g, %g), want (0, 1)", x, y)
+	}
+	if x, y := Identity.Translate(1, 0).Scale(2, 2).Apply(0, 0); x != 2 || y != 0 {
+		t.Errorf("translating then scaling moves the origin to (%g, %g), want (2, 0)", x, y)
+	}
+	if _, ok := Identity.Scale(0, 1).Invert(); ok {
+		t.Error("a transform onto a line was inverted")
+	}
+}
+
+func TestTransform(t *testing.T) {
+	src := grayYCbCr(6, 4, func(x, y int) uint8 { return uint8(40*x + 10*y) })
+	var img image.Image = src
+
+	// Half turns about the center and shifts by whole pixels land on
+	// pixel centers and so reproduce the pixels exactly.
+	for _, filter := range []Filter{Nearest, Bilinear, CatmullRom, Lanczos3} {
+		m := Identity.Translate(-3, -2).Rotate(180).Translate(3, 2)
+		turned := (*Transform(&img, m, image.Pt(6, 4), Options{Filter: filter, Background: color.Black})).(*image.YCbCr)
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 6; x++ {
+				if got, want := turned.YCbCrAt(x, y).Y, src.YCbCrAt(5-x, 3-y).Y; got != want {
+					t.Fatalf("%v: turned pixel (%d, %d) = %d, want %d", filter, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	// Bilinear sampling halfway between two pixels averages them, and
+	// the uncovered column is half background.
+	shifted := (*Transform(&img, Identity.Translate(0.5, 0), image.Pt(6, 4), Options{Filter: Bilinear, Background: color.White})).(*image.YCbCr)
+	if got := shifted.YCbCrAt(2, 0).Y; got != 60 {
+		t.Errorf("pixel between 40 and 80 is %d, want 60", got)
+	}
+	if got := shifted.YCbCrAt(0, 0).Y; got != 128 {
+		t.Errorf("pixel half over the edge is %d, want 128", got)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	var img image.Image = grayYCbCr(20, 10, func(x, y int) uint8 { return 200 })
+	red := color.RGBA{R: 0xff, A: 0xff}
+
+	if got := Rotate(&img, 360, true, Options{}); *got != img {
+		t.Error("a full turn changed the image")
+	}
+	if got := (*Rotate(&img, -90, true, Options{})).Bounds(); got != image.Rect(0, 0, 10, 20) {
+		t.Errorf("quarter turn is %v, want 10x20", got)
+	}
+
+	tests := []struct {
+		degrees float64
+		expand  bool
+		size    image.Point
+	}{
+		{90, false, image.Pt(20, 10)},
+		{30, true, image.Pt(23, 19)},
+		{30, false, image.Pt(20, 10)},
+		{-3.5, true, image.Pt(21, 12)},
+	}
+	for _, test := range tests {
+		rotated := *Rotate(&img, test.degrees, test.expand, Options{Filter: Bilinear, Background: red})
+		if _, ok := rotated.(*image.YCbCr); !ok {
+			t.Errorf("%g degrees: result is %T, want YCbCr on an opaque background", test.degrees, rotated)
+		}
+		if size := rotated.Bounds().Size(); size != test.size {
+			t.Errorf("%g degrees: size = %v, want %v", test.degrees, size, test.size)
+		}
+		center := color.GrayModel.Convert(rotated.At(test.size.X/2, test.size.Y/2)).(color.Gray).Y
+		corner := color.RGBAModel.Convert(rotated.At(0, 0)).(color.RGBA)
+		if center < 199 || center > 201 || corner.R < 0xf0 || corner.G > 0x10 {
+			t.Errorf("%g degrees: center is %d and corner %v, want the image in the middle of red", test.degrees, center, corner)
+		}
+	}
+
+	// Transparent corners need alpha.
+	rotated := *Rotate(&img, 45, true, Options{Filter: CatmullRom})
+	if c := rotated.(*image.RGBA64).RGBA64At(0, 0); c.A != 0 {
+		t.Errorf("corner is %v, want transparent", c)
+	}
+	if c := rotated.(*image.RGBA64).RGBA64At(rotated.Bounds().Dx()/2, rotated.Bounds().Dy()/2); c.A != 0xffff {
+		t.Errorf("center is %v, want opaque", c)
+	}
+}
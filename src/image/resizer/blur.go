@@ -0,0 +1,132 @@
+package resizer
+
+import (
+	"image"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// gaussian returns the taps and the kernel of a Gaussian blur of standard
+// deviation sigma, cut off at three times sigma.
+func gaussian(sigma float64) (int, func(float64) float64) {
+	radius := math.Ceil(3 * sigma)
+	return 2*int(radius) + 2, func(in float64) float64 {
+		if in < -radius || in > radius {
+			return 0
+		}
+		return math.Exp(-in * in / (2 * sigma * sigma))
+	}
+}
+
+// Blur applies a Gaussian blur of standard deviation sigma to an image, in
+// a horizontal and a vertical pass. YCbCr and gray images are blurred in 8
+// bits and keep their type, other images are blurred in 16 bits. A sigma of
+// zero or less returns the image unchanged.
+func Blur(img *image.Image, sigma float64) *image.Image {
+	if sigma <= 0 {
+		return img
+	}
+	bounds := (*img).Bounds()
+	taps, kernel := gaussian(sigma)
+
+	var result image.Image
+	switch input := (*img).(type) {
+	case *image.YCbCr:
+		result = filter8(imageYCbCrToYCC(input), bounds.Dx(), bounds.Dy(), 1, 1, taps, kernel, 1).YCbCr()
+	case *image.Gray:
+		result = filter8(imageGrayToYCC(input), bounds.Dx(), bounds.Dy(), 1, 1, taps, kernel, 1).Gray()
+	default:
+		result = filter16(img, bounds.Dx(), bounds.Dy(), 1, 1, taps, kernel, 1)
+	}
+	return &result
+}
+
+// Sharpen applies an unsharp mask to an image: it adds amount times the
+// difference between the image and its Gaussian blur of standard deviation
+// radius, where that difference exceeds threshold levels out of 255. YCbCr
+// images are sharpened in their luma only, which avoids color fringes, and
+// gray images in 8 bits too. Both keep their type.
+func Sharpen(img *image.Image, amount, radius, threshold float64) *image.Image {
+	if amount <= 0 || radius <= 0 {
+		return img
+	}
+	bounds := (*img).Bounds()
+	taps, kernel := gaussian(radius)
+
+	var result image.Image
+	switch input := (*img).(type) {
+	case *image.YCbCr:
+		result = sharpenYCC(imageYCbCrToYCC(input), amount, taps, kernel, threshold).YCbCr()
+	case *image.Gray:
+		result = sharpenYCC(imageGrayToYCC(input), amount, taps, kernel, threshold).Gray()
+	default:
+		out := filter16(img, bounds.Dx(), bounds.Dy(), 1, 1, taps, kernel, 1)
+		limit := int64(threshold * 0x101)
+		parallel(out, func(slice image.Image) {
+			r := slice.Bounds()
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					i := out.PixOffset(x, y)
+					sr, sg, sb, sa := (*img).At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+					var values [3]uint16
+					for c, original := range [3]int64{int64(sr), int64(sg), int64(sb)} {
+						diff := original - int64(out.Pix[i+2*c])<<8 - int64(out.Pix[i+2*c+1])
+						if diff > limit || -diff > limit {
+							original += int64(math.Round(amount * float64(diff)))
+						}
+						// Premultiplied colors cannot exceed the alpha.
+						if original > int64(sa) {
+							original = int64(sa)
+						}
+						values[c] = clampUint16(original)
+					}
+					for c, v := range values {
+						out.Pix[i+2*c], out.Pix[i+2*c+1] = uint8(v>>8), uint8(v)
+					}
+					out.Pix[i+6], out.Pix[i+7] = uint8(sa>>8), uint8(sa)
+				}
+			}
+		})
+		result = out
+	}
+	return &result
+}
+
+// sharpenYCC is Sharpen for the luma of a ycc image.
+func sharpenYCC(in *ycc, amount float64, taps int, kernel func(float64) float64, threshold float64) *ycc {
+	bounds := in.Bounds()
+	out := filter8(in, bounds.Dx(), bounds.Dy(), 1, 1, taps, kernel, 1)
+	limit := int32(threshold)
+	parallel(out, func(slice image.Image) {
+		r := slice.Bounds()
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for i := y * out.Stride; i < y*out.Stride+3*r.Dx(); i += 3 {
+				original := int32(in.Pix[i])
+				diff := original - int32(out.Pix[i])
+				if diff > limit || -diff > limit {
+					original += int32(math.Round(amount * float64(diff)))
+				}
+				out.Pix[i] = clampUint8(original)
+				out.Pix[i+1], out.Pix[i+2] = in.Pix[i+1], in.Pix[i+2]
+			}
+		}
+	})
+	return out
+}
+
+// parallel runs f on horizontal slices of img at once, one per CPU, as
+// Resize does.
+func parallel(img imageWithSubImage, f func(slice image.Image)) {
+	cpus := runtime.GOMAXPROCS(runtime.NumCPU())
+	wg := sync.WaitGroup{}
+	wg.Add(cpus)
+	for i := 0; i < cpus; i++ {
+		slice := makeSlice(img, i, cpus)
+		go func() {
+			defer wg.Done()
+			f(slice)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,156 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// grayYCbCr returns a 4:4:4 YCbCr image of the given lumas without color.
+func grayYCbCr(width, height int, luma func(x, y int) uint8) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio444)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := img.YOffset(x, y)
+			img.Y[i], img.Cb[i], img.Cr[i] = luma(x, y), 128, 128
+		}
+	}
+	return img
+}
+
+func TestBlur(t *testing.T) {
+	dot := func(x, y int) uint8 {
+		if x == 10 && y == 10 {
+			return 255
+		}
+		return 0
+	}
+	gray := image.NewGray(image.Rect(0, 0, 21, 21))
+	gray.SetGray(10, 10, color.Gray{Y: 255})
+
+	// The blurred dot follows the normalized Gaussian of sigma 1, which
+	// keeps 0.159 of the dot in the center and 0.097 next to it.
+	weight := func(k int) float64 { return math.Exp(-float64(k*k) / 2) }
+	var sum float64
+	for k := -3; k <= 3; k++ {
+		sum += weight(k)
+	}
+	for _, img := range []image.Image{grayYCbCr(21, 21, dot), gray} {
+		blurred := *Blur(&img, 1)
+		if blurred.Bounds().Size() != image.Pt(21, 21) {
+			t.Fatalf("%T blurred to %v", img, blurred.Bounds())
+		}
+		for _, p := range []image.Point{{10, 10}, {11, 10}, {10, 12}, {9, 11}} {
+			d := p.Sub(image.Pt(10, 10))
+			want := 255 * weight(d.X) * weight(d.Y) / (sum * sum)
+			got := float64(color.GrayModel.Convert(blurred.At(p.X, p.Y)).(color.Gray).Y)
+			if math.Abs(got-want) > 1.5 {
+				t.Errorf("%T: pixel %v = %.0f, want %.1f", img, p, got, want)
+			}
+		}
+	}
+
+	var flat image.Image = grayYCbCr(30, 20, func(x, y int) uint8 { return 77 })
+	blurred := *Blur(&flat, 4.5)
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 30; x++ {
+			if got := blurred.(*image.YCbCr).YCbCrAt(x, y); got != (color.YCbCr{Y: 77, Cb: 128, Cr: 128}) {
+				t.Fatalf("flat image blurred to %v at (%d, %d)", got, x, y)
+			}
+		}
+	}
+}
+
+func TestSharpen(t *testing.T) {
+	// A step from 50 to 200, with noise of one level on the left.
+	step := func(x, y int) uint8 {
+		if x < 10 {
+			return uint8(50 + (x+y)%2)
+		}
+		return 200
+	}
+	var img image.Image = grayYCbCr(20, 5, step)
+
+	sharpened := (*Sharpen(&img, 1, 1, 4)).(*image.YCbCr)
+	at := func(x int) uint8 { return sharpened.YCbCrAt(x, 2).Y }
+	if at(9) >= 50 || at(10) <= 200 {
+		t.Errorf("edge sharpened to %d and %d, want overshoot below 50 and above 200", at(9), at(10))
+	}
+	if at(2) != step(2, 2) || at(3) != step(3, 2) {
+		t.Errorf("noise below the threshold changed to %d and %d", at(2), at(3))
+	}
+	for i := range sharpened.Cb {
+		if sharpened.Cb[i] != 128 || sharpened.Cr[i] != 128 {
+			t.Fatal("sharpening changed the chroma")
+		}
+	}
+
+	// Premultiplied colors stay within the alpha.
+	translucent := image.NewNRGBA(image.Rect(0, 0, 20, 5))
+	for i := 0; i < len(translucent.Pix); i += 4 {
+		v := uint8(40)
+		if i/4%20 >= 10 {
+			v = 255
+		}
+		translucent.Pix[i], translucent.Pix[i+1], translucent.Pix[i+2], translucent.Pix[i+3] = v, v, v, 128
+	}
+	img = translucent
+	rgba := (*Sharpen(&img, 3, 1, 0)).(*image.RGBA64)
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 20; x++ {
+			c := rgba.RGBA64At(x, y)
+			if c.A != 128*0x101 || c.R > c.A {
+				t.Fatalf("pixel (%d, %d) = %v is not premultiplied by the original alpha", x, y, c)
+			}
+		}
+	}
+}
+
+func TestBlurOption(t *testing.T) {
+	stripes := func(x, y int) uint8 { return uint8(x / 4 % 2 * 255) }
+	var img image.Image = grayYCbCr(40, 40, stripes)
+	sharp := *ResizeWith(20, 20, &img, Options{Blur: 0.5})
+	soft := *ResizeWith(20, 20, &img, Options{Blur: 2})
+	deviation := func(img image.Image) float64 {
+		var sum float64
+		for _, v := range img.(*image.YCbCr).Y {
+			sum += math.Abs(float64(v) - 127.5)
+		}
+		return sum
+	}
+	if deviation(sharp) <= deviation(soft) {
+		t.Errorf("a blur of 0.5 keeps less contrast than a blur of 2")
+	}
+}
+
+func TestBlurGray(t *testing.T) {
+	step := func(x, y int) uint8 { return uint8(x / 10 * 200) }
+	ycbcr := grayYCbCr(20, 20, step)
+	gray := image.NewGray(image.Rect(5, 5, 25, 25))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			gray.SetGray(x+5, y+5, color.Gray{step(x, y)})
+		}
+	}
+
+	for name, f := range map[string]func(*image.Image) *image.Image{
+		"blur":    func(img *image.Image) *image.Image { return Blur(img, 2) },
+		"sharpen": func(img *image.Image) *image.Image { return Sharpen(img, 2, 1, 0) },
+	} {
+		var a, b image.Image = ycbcr, gray
+		want := (*f(&a)).(*image.YCbCr)
+		got, ok := (*f(&b)).(*image.Gray)
+		if !ok {
+			t.Errorf("%s of a gray image is a %T", name, *f(&b))
+			continue
+		}
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 20; x++ {
+				if g, w := got.GrayAt(got.Rect.Min.X+x, got.Rect.Min.Y+y).Y, want.YCbCrAt(x, y).Y; g != w {
+					t.Fatalf("%s of a gray image at (%d, %d) = %d, want %d", name, x, y, g, w)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package resizer
+
+import (
+	"image"
+	"image/draw"
+	"strings"
+)
+
+// Gravity anchors a crop window or a padded image to a side or corner of
+// its box.
+type Gravity int
+
+const (
+	Center Gravity = iota
+	North
+	NorthEast
+	East
+	SouthEast
+	South
+	SouthWest
+	West
+	NorthWest
+	// Smart picks the most interesting part of an image to crop, see
+	// SmartWindow. It centers the image where it is padded.
+	Smart
+)
+
+var gravityNames = []string{"center", "north", "northeast", "east", "southeast", "south", "southwest", "west", "northwest", "smart"}
+
+// ParseGravity returns the gravity called name.
+func ParseGravity(name string) (Gravity, bool) {
+	for i, n := range gravityNames {
+		if strings.EqualFold(n, name) {
+			return Gravity(i), true
+		}
+	}
+	return Center, false
+}
+
+func (g Gravity) String() string {
+	if g >= 0 && int(g) < len(gravityNames) {
+		return gravityNames[g]
+	}
+	return "unknown"
+}
+
+// Position returns the offset of a rectangle of size inner placed in a box
+// of size outer. The offset is negative where inner is larger than outer.
+func (g Gravity) Position(outer, inner image.Point) image.Point {
+	free := outer.Sub(inner)
+	p := free.Div(2)
+	switch g {
+	case North, NorthEast, NorthWest:
+		p.Y = 0
+	case South, SouthEast, SouthWest:
+		p.Y = free.Y
+	}
+	switch g {
+	case West, NorthWest, SouthWest:
+		p.X = 0
+	case East, NorthEast, SouthEast:
+		p.X = free.X
+	}
+	return p
+}
+
+// Window returns the rectangle of the given size that gravity anchors in
+// bounds, as used to crop an image.
+func (g Gravity) Window(bounds image.Rectangle, size image.Point) image.Rectangle {
+	min := bounds.Min.Add(g.Position(bounds.Size(), size))
+	return image.Rectangle{Min: min, Max: min.Add(size)}
+}
+
+// Crop returns the part r of an image, clipped to its bounds. The result
+// keeps the coordinates of the original. YCbCr images and the other
+// standard types share the pixels of the original rather than being
+// converted.
+func Crop(img *image.Image, r image.Rectangle) *image.Image {
+	result := crop(*img, r.Intersect((*img).Bounds()))
+	return &result
+}
+
+// crop returns the part r of img, sharing its pixels.
+func crop(img image.Image, r image.Rectangle) image.Image {
+	if img, ok := img.(imageWithSubImage); ok {
+		return img.SubImage(r)
+	}
+	result := image.NewRGBA64(r)
+	draw.Draw(result, r, img, r.Min, draw.Src)
+	return result
+}
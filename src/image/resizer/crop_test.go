@@ -0,0 +1,98 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestGravityWindow(t *testing.T) {
+	bounds := image.Rect(10, 20, 110, 70)
+	size := image.Pt(40, 20)
+	tests := []struct {
+		gravity Gravity
+		min     image.Point
+	}{
+		{Center, image.Pt(40, 35)},
+		{North, image.Pt(40, 20)},
+		{NorthEast, image.Pt(70, 20)},
+		{East, image.Pt(70, 35)},
+		{SouthEast, image.Pt(70, 50)},
+		{South, image.Pt(40, 50)},
+		{SouthWest, image.Pt(10, 50)},
+		{West, image.Pt(10, 35)},
+		{NorthWest, image.Pt(10, 20)},
+	}
+	for _, test := range tests {
+		want := image.Rectangle{Min: test.min, Max: test.min.Add(size)}
+		if got := test.gravity.Window(bounds, size); got != want {
+			t.Errorf("%v window = %v, want %v", test.gravity, got, want)
+		}
+		if g, ok := ParseGravity(test.gravity.String()); !ok || g != test.gravity {
+			t.Errorf("ParseGravity(%q) = %v, %v", test.gravity, g, ok)
+		}
+	}
+}
+
+// TestCropYCbCr crops a 4:2:0 image at odd coordinates, where a chroma
+// sample is shared with pixels outside the crop, and checks that resizing
+// keeps the chroma of every pixel.
+func TestCropYCbCr(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 40, 30), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = 128
+	}
+	for y := 0; y < 15; y++ {
+		for x := 0; x < 20; x++ {
+			src.Cb[y*src.CStride+x] = uint8(10 * x)
+			src.Cr[y*src.CStride+x] = uint8(200 - 5*x)
+		}
+	}
+	var img image.Image = src
+
+	cropped := *Crop(&img, image.Rect(3, 5, 31, 50))
+	ycbcr, ok := cropped.(*image.YCbCr)
+	if !ok {
+		t.Fatalf("cropped image is %T", cropped)
+	}
+	if ycbcr.Rect != image.Rect(3, 5, 31, 30) {
+		t.Errorf("crop covers %v, want it clipped to the image", ycbcr.Rect)
+	}
+
+	resized := *Resize(uint(ycbcr.Rect.Dx()), uint(ycbcr.Rect.Dy()+7), &cropped)
+	for x := 0; x < ycbcr.Rect.Dx(); x++ {
+		want := ycbcr.YCbCrAt(ycbcr.Rect.Min.X+x, ycbcr.Rect.Min.Y)
+		for y := 0; y < resized.Bounds().Dy(); y++ {
+			got := color.YCbCrModel.Convert(resized.At(x, y)).(color.YCbCr)
+			if got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestResizeWithGravity(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			src.Pix[y*src.Stride+x] = uint8(x * 6)
+		}
+	}
+	var img image.Image = src
+
+	west := *ResizeWith(20, 20, &img, Options{Fit: Cover, Gravity: West, Background: color.White})
+	east := *ResizeWith(20, 20, &img, Options{Fit: Cover, Gravity: East, Background: color.White})
+	if west.Bounds().Size() != image.Pt(20, 20) || east.Bounds().Size() != image.Pt(20, 20) {
+		t.Fatalf("covered images are %v and %v", west.Bounds().Size(), east.Bounds().Size())
+	}
+	left := color.GrayModel.Convert(west.At(west.Bounds().Min.X+1, west.Bounds().Min.Y)).(color.Gray)
+	right := color.GrayModel.Convert(east.At(east.Bounds().Max.X-2, east.Bounds().Min.Y)).(color.Gray)
+	if left.Y > 20 || right.Y < 200 {
+		t.Errorf("west crop starts at %d, east crop ends at %d", left.Y, right.Y)
+	}
+
+	padded := *ResizeWith(20, 20, &img, Options{Fit: Contain, Gravity: South, Background: color.White})
+	if got := color.GrayModel.Convert(padded.At(10, 2)); got != (color.Gray{Y: 0xff}) {
+		t.Errorf("top of the south anchored image is %v, want white padding", got)
+	}
+}
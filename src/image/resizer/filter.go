@@ -0,0 +1,112 @@
+package resizer
+
+import (
+	"math"
+	"strings"
+)
+
+// Filter is the resampling filter used to scale an image.
+type Filter int
+
+const (
+	// Lanczos3 is a windowed sinc of three lobes, sharp with slight
+	// ringing. It is the default.
+	Lanczos3 Filter = iota
+	// Lanczos2 is a windowed sinc of two lobes, softer than Lanczos3.
+	Lanczos2
+	// Nearest picks the closest source pixel, keeping hard edges.
+	Nearest
+	// Bilinear interpolates linearly between neighboring pixels.
+	Bilinear
+	// CatmullRom is the bicubic spline through the source pixels.
+	CatmullRom
+	// Mitchell is the Mitchell-Netravali bicubic filter, trading a little
+	// sharpness for less ringing than CatmullRom.
+	Mitchell
+	// Box averages the source pixels each output pixel covers.
+	Box
+)
+
+var filterNames = []string{"lanczos3", "lanczos2", "nearest", "bilinear", "catmullrom", "mitchell", "box"}
+
+// ParseFilter returns the filter called name. Bicubic is an alias of
+// CatmullRom.
+func ParseFilter(name string) (Filter, bool) {
+	if strings.EqualFold(name, "bicubic") {
+		return CatmullRom, true
+	}
+	for i, n := range filterNames {
+		if strings.EqualFold(n, name) {
+			return Filter(i), true
+		}
+	}
+	return Lanczos3, false
+}
+
+func (f Filter) String() string {
+	if f >= 0 && int(f) < len(filterNames) {
+		return filterNames[f]
+	}
+	return "unknown"
+}
+
+// kernel returns the number of taps and the kernel of the filter. The
+// taps cover the support of the kernel when it is not stretched.
+func (f Filter) kernel() (int, func(float64) float64) {
+	switch f {
+	case Lanczos2:
+		return 4, lanczos2
+	case Nearest, Box:
+		return 2, box
+	case Bilinear:
+		return 2, linear
+	case CatmullRom:
+		return 4, catmullRom
+	case Mitchell:
+		return 4, mitchell
+	}
+	return 6, lanczos3
+}
+
+func lanczos2(in float64) float64 {
+	if in > -2 && in < 2 {
+		return sinc(in) * sinc(in*0.5)
+	}
+	return 0
+}
+
+func box(in float64) float64 {
+	if in >= -0.5 && in < 0.5 {
+		return 1
+	}
+	return 0
+}
+
+func linear(in float64) float64 {
+	in = math.Abs(in)
+	if in < 1 {
+		return 1 - in
+	}
+	return 0
+}
+
+func catmullRom(in float64) float64 {
+	return cubic(in, 0, 0.5)
+}
+
+func mitchell(in float64) float64 {
+	return cubic(in, 1.0/3, 1.0/3)
+}
+
+// cubic is the family of bicubic filters of Mitchell and Netravali with
+// parameters b and c.
+func cubic(in, b, c float64) float64 {
+	in = math.Abs(in)
+	switch {
+	case in < 1:
+		return ((12-9*b-6*c)*in*in*in + (-18+12*b+6*c)*in*in + (6 - 2*b)) / 6
+	case in < 2:
+		return ((-b-6*c)*in*in*in + (6*b+30*c)*in*in + (-12*b-48*c)*in + (8*b + 24*c)) / 6
+	}
+	return 0
+}
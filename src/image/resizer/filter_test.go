@@ -0,0 +1,94 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// Reference rows scaled with a floating point convolution that clamps at
+// the edges, before rounding to 8 bits.
+var (
+	upRow   = []uint8{0, 255, 64, 192}
+	downRow = []uint8{10, 200, 30, 250, 0, 120, 90, 60, 255, 5, 180, 40}
+
+	filterReferences = []struct {
+		filter   Filter
+		up, down []float64
+	}{
+		{Lanczos3,
+			[]float64{-34.44, 22.64, 151.91, 249.30, 223.69, 118.78, 58.46, 100.48, 178.26, 212.26},
+			[]float64{94.76, 126.57, 79.02, 136.32, 90.34}},
+		{Lanczos2,
+			[]float64{-21.76, 19.26, 139.44, 246.00, 222.15, 110.92, 63.66, 116.06, 182.08, 202.92},
+			[]float64{92.76, 123.68, 82.21, 133.04, 90.10}},
+		{Nearest,
+			[]float64{0, 0, 255, 255, 255, 64, 64, 192, 192, 192},
+			[]float64{200, 250, 90, 255, 180}},
+		{Bilinear,
+			[]float64{0, 25.50, 127.50, 229.50, 197.70, 121.30, 76.80, 128.00, 179.20, 192.00},
+			[]float64{88.07, 124.41, 80.89, 132.54, 88.60}},
+		{CatmullRom,
+			[]float64{-18.74, 17.18, 139.44, 246.42, 220.43, 111.90, 64.46, 116.06, 182.95, 201.41},
+			[]float64{92.44, 124.02, 82.74, 133.85, 90.06}},
+		{Mitchell,
+			[]float64{-7.64, 30.26, 134.13, 222.73, 204.83, 120.44, 81.48, 121.37, 176.53, 195.83},
+			[]float64{89.67, 120.40, 87.23, 128.95, 89.60}},
+		{Box,
+			[]float64{0, 0, 255, 255, 255, 64, 64, 192, 192, 192},
+			[]float64{105.00, 93.33, 105.00, 106.67, 110.00}},
+	}
+)
+
+// rowImages returns row as a single row YCbCr image, which is scaled in 8
+// bits, and as a Gray16 image, which is scaled in 16 bits.
+func rowImages(row []uint8) (image.Image, image.Image) {
+	ycbcr := image.NewYCbCr(image.Rect(0, 0, len(row), 1), image.YCbCrSubsampleRatio444)
+	gray := image.NewGray16(image.Rect(0, 0, len(row), 1))
+	for x, v := range row {
+		ycbcr.Y[x], ycbcr.Cb[x], ycbcr.Cr[x] = v, 128, 128
+		gray.SetGray16(x, 0, color.Gray16{Y: uint16(v) * 0x101})
+	}
+	return ycbcr, gray
+}
+
+func TestFilterReferences(t *testing.T) {
+	for _, ref := range filterReferences {
+		for _, test := range []struct {
+			row  []uint8
+			want []float64
+		}{{upRow, ref.up}, {downRow, ref.down}} {
+			ycbcr, gray := rowImages(test.row)
+			fast := *ResizeWith(uint(len(test.want)), 1, &ycbcr, Options{Filter: ref.filter})
+			generic := *ResizeWith(uint(len(test.want)), 1, &gray, Options{Filter: ref.filter})
+			if _, ok := fast.(*image.YCbCr); !ok {
+				t.Fatalf("%v: 8-bit result is %T", ref.filter, fast)
+			}
+
+			for x, want := range test.want {
+				want = math.Max(0, math.Min(255, want))
+				y8 := float64(fast.(*image.YCbCr).Y[x])
+				y16 := float64(generic.(*image.RGBA64).RGBA64At(x, 0).R) / 0x101
+				if math.Abs(y8-want) > 1.5 || math.Abs(y16-want) > 1 {
+					t.Errorf("%v, %d to %d pixels: pixel %d = %.2f in 8 bits and %.2f in 16 bits, want %.2f",
+						ref.filter, len(test.row), len(test.want), x, y8, y16, want)
+				}
+			}
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	for _, ref := range filterReferences {
+		if f, ok := ParseFilter(ref.filter.String()); !ok || f != ref.filter {
+			t.Errorf("ParseFilter(%q) = %v, %v", ref.filter, f, ok)
+		}
+	}
+	if f, ok := ParseFilter("Bicubic"); !ok || f != CatmullRom {
+		t.Errorf("ParseFilter(bicubic) = %v, %v, want catmullrom", f, ok)
+	}
+	if _, ok := ParseFilter("hermite"); ok {
+		t.Error("ParseFilter accepted an unknown filter")
+	}
+}
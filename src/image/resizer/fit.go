@@ -0,0 +1,186 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+// Fit selects how an image is scaled into a box of a given width and
+// height.
+type Fit int
+
+const (
+	// Fill stretches the image to the box, ignoring its aspect ratio.
+	Fill Fit = iota
+	// Contain scales the image to fit the box and pads it to the size of
+	// the box with a background color.
+	Contain
+	// Cover scales the image to cover the box and crops what overflows.
+	Cover
+	// Inside scales the image down to fit the box. Smaller images are
+	// kept as they are.
+	Inside
+	// Outside scales the image to cover the box without cropping it.
+	Outside
+)
+
+var fitNames = []string{"fill", "contain", "cover", "inside", "outside"}
+
+// ParseFit returns the fit mode called name.
+func ParseFit(name string) (Fit, bool) {
+	for i, n := range fitNames {
+		if strings.EqualFold(n, name) {
+			return Fit(i), true
+		}
+	}
+	return Fill, false
+}
+
+func (f Fit) String() string {
+	if f >= 0 && int(f) < len(fitNames) {
+		return fitNames[f]
+	}
+	return "unknown"
+}
+
+// Geometry describes how an image is laid out in its box.
+type Geometry struct {
+	// Width and Height are the size the image is scaled to.
+	Width, Height uint
+	// Canvas is the bounds of the output image.
+	Canvas image.Rectangle
+	// Offset is the position of the scaled image on the canvas. It is
+	// negative where the image is cropped and positive where it is padded.
+	Offset image.Point
+}
+
+// Geometry returns the layout of an image with the given bounds scaled
+// into a box of width×height, centered on the canvas. A zero width or
+// height leaves that side free, and the image keeps its aspect ratio in
+// every mode but Fill. Without a box the image keeps its size.
+func (f Fit) Geometry(width, height uint, bounds image.Rectangle) Geometry {
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	same := Geometry{Width: uint(bounds.Dx()), Height: uint(bounds.Dy()), Canvas: image.Rect(0, 0, bounds.Dx(), bounds.Dy())}
+	if width == 0 && height == 0 {
+		return same
+	}
+
+	var scaledWidth, scaledHeight uint
+	if f == Fill || width == 0 || height == 0 {
+		scaledWidth, scaledHeight = Dimensions(width, height, bounds)
+	} else {
+		scaleX, scaleY := float64(width)/w, float64(height)/h
+		// Contain and Inside scale by the smaller factor, Cover and
+		// Outside by the larger one.
+		byWidth := scaleX <= scaleY
+		if f == Cover || f == Outside {
+			byWidth = !byWidth
+		}
+		if byWidth {
+			scaledWidth, scaledHeight = width, uint(math.Max(math.Round(h*scaleX), 1))
+		} else {
+			scaledWidth, scaledHeight = uint(math.Max(math.Round(w*scaleY), 1)), height
+		}
+	}
+	if f == Inside && (scaledWidth > same.Width || scaledHeight > same.Height) {
+		return same
+	}
+
+	g := Geometry{Width: scaledWidth, Height: scaledHeight, Canvas: image.Rect(0, 0, int(scaledWidth), int(scaledHeight))}
+	if (f == Contain || f == Cover) && width != 0 && height != 0 {
+		g.Canvas = image.Rect(0, 0, int(width), int(height))
+		g.Offset = image.Pt((int(width)-int(scaledWidth))/2, (int(height)-int(scaledHeight))/2)
+	}
+	return g
+}
+
+// Anchor moves the image to the side or corner of the canvas named by
+// gravity. It picks the part Cover keeps and where Contain puts the image
+// in its padding.
+func (g Geometry) Anchor(gravity Gravity) Geometry {
+	g.Offset = gravity.Position(g.Canvas.Size(), image.Pt(int(g.Width), int(g.Height)))
+	return g
+}
+
+// Options control how ResizeWith scales an image. The zero value
+// stretches the image with Lanczos3 like Resize.
+type Options struct {
+	// Fit is how the image is scaled into its box, and Gravity where it
+	// is anchored in the box.
+	Fit     Fit
+	Gravity Gravity
+	// Background fills the padding Contain adds. Nil is transparent.
+	Background color.Color
+	// Filter is the resampling filter, and Blur stretches its kernel
+	// when scaling down. Blur values above 1 soften the image, values
+	// below 1 sharpen it. Zero stands for 1.
+	Filter Filter
+	Blur   float64
+	// Linear resamples in linear light, which keeps fine high-contrast
+	// detail from darkening when scaling down.
+	Linear bool
+}
+
+// ResizeWith scales an image into a box of width×height as options
+// describe. A zero width or height leaves that side free.
+func ResizeWith(width, height uint, img *image.Image, options Options) *image.Image {
+	if options.Fit == Cover && options.Gravity == Smart {
+		img, _ = SmartCover(width, height, img, options)
+		return img
+	}
+	g := options.Fit.Geometry(width, height, (*img).Bounds()).Anchor(options.Gravity)
+	img = resample(g.Width, g.Height, img, options.Filter, options.blur(), options.Linear)
+
+	background := options.Background
+	if background == nil {
+		background = color.Transparent
+	}
+	var result image.Image
+	switch {
+	case g.Canvas.Dx() < int(g.Width) || g.Canvas.Dy() < int(g.Height):
+		result = crop(*img, g.Canvas.Sub(g.Offset).Add((*img).Bounds().Min))
+	case g.Canvas.Dx() > int(g.Width) || g.Canvas.Dy() > int(g.Height):
+		result = pad(*img, g.Canvas, g.Offset, background)
+	default:
+		return img
+	}
+	return &result
+}
+
+func (options Options) blur() float64 {
+	if options.Blur == 0 {
+		return 1
+	}
+	return options.Blur
+}
+
+// pad draws img at offset on a canvas filled with background. Full
+// resolution YCbCr images padded with an opaque color stay YCbCr.
+func pad(img image.Image, canvas image.Rectangle, offset image.Point, background color.Color) image.Image {
+	r := img.Bounds()
+	if src, ok := img.(*image.YCbCr); ok && src.SubsampleRatio == image.YCbCrSubsampleRatio444 {
+		if _, _, _, a := background.RGBA(); a == 0xffff {
+			fill := color.YCbCrModel.Convert(background).(color.YCbCr)
+			dst := image.NewYCbCr(canvas, image.YCbCrSubsampleRatio444)
+			for i := range dst.Y {
+				dst.Y[i], dst.Cb[i], dst.Cr[i] = fill.Y, fill.Cb, fill.Cr
+			}
+			for y := 0; y < r.Dy(); y++ {
+				d := dst.YOffset(canvas.Min.X+offset.X, canvas.Min.Y+offset.Y+y)
+				s := src.YOffset(r.Min.X, r.Min.Y+y)
+				copy(dst.Y[d:d+r.Dx()], src.Y[s:s+r.Dx()])
+				copy(dst.Cb[d:d+r.Dx()], src.Cb[s:s+r.Dx()])
+				copy(dst.Cr[d:d+r.Dx()], src.Cr[s:s+r.Dx()])
+			}
+			return dst
+		}
+	}
+
+	dst := image.NewRGBA64(canvas)
+	draw.Draw(dst, canvas, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, r.Sub(r.Min).Add(canvas.Min.Add(offset)), img, r.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,115 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestGeometry(t *testing.T) {
+	portrait := image.Rect(0, 0, 300, 600)
+	landscape := image.Rect(0, 0, 600, 300)
+	square := image.Rect(0, 0, 400, 400)
+
+	tests := []struct {
+		fit           Fit
+		bounds        image.Rectangle
+		width, height uint
+		// scaled is the size the image is scaled to, canvas the size of
+		// the output and offset the position of the image on it.
+		scaled, canvas, offset image.Point
+	}{
+		{Fill, portrait, 200, 200, image.Pt(200, 200), image.Pt(200, 200), image.Pt(0, 0)},
+		{Fill, landscape, 200, 200, image.Pt(200, 200), image.Pt(200, 200), image.Pt(0, 0)},
+		{Fill, square, 200, 100, image.Pt(200, 100), image.Pt(200, 100), image.Pt(0, 0)},
+		{Fill, portrait, 150, 0, image.Pt(150, 300), image.Pt(150, 300), image.Pt(0, 0)},
+
+		{Contain, portrait, 200, 200, image.Pt(100, 200), image.Pt(200, 200), image.Pt(50, 0)},
+		{Contain, landscape, 200, 200, image.Pt(200, 100), image.Pt(200, 200), image.Pt(0, 50)},
+		{Contain, square, 200, 100, image.Pt(100, 100), image.Pt(200, 100), image.Pt(50, 0)},
+		{Contain, landscape, 0, 100, image.Pt(200, 100), image.Pt(200, 100), image.Pt(0, 0)},
+		{Contain, square, 800, 600, image.Pt(600, 600), image.Pt(800, 600), image.Pt(100, 0)},
+
+		{Cover, portrait, 200, 200, image.Pt(200, 400), image.Pt(200, 200), image.Pt(0, -100)},
+		{Cover, landscape, 200, 200, image.Pt(400, 200), image.Pt(200, 200), image.Pt(-100, 0)},
+		{Cover, square, 200, 100, image.Pt(200, 200), image.Pt(200, 100), image.Pt(0, -50)},
+		{Cover, portrait, 100, 0, image.Pt(100, 200), image.Pt(100, 200), image.Pt(0, 0)},
+		{Cover, landscape, 1200, 900, image.Pt(1800, 900), image.Pt(1200, 900), image.Pt(-300, 0)},
+
+		{Inside, portrait, 200, 200, image.Pt(100, 200), image.Pt(100, 200), image.Pt(0, 0)},
+		{Inside, landscape, 200, 200, image.Pt(200, 100), image.Pt(200, 100), image.Pt(0, 0)},
+		{Inside, square, 200, 100, image.Pt(100, 100), image.Pt(100, 100), image.Pt(0, 0)},
+		{Inside, portrait, 1000, 1000, image.Pt(300, 600), image.Pt(300, 600), image.Pt(0, 0)},
+		{Inside, landscape, 900, 0, image.Pt(600, 300), image.Pt(600, 300), image.Pt(0, 0)},
+
+		{Outside, portrait, 200, 200, image.Pt(200, 400), image.Pt(200, 400), image.Pt(0, 0)},
+		{Outside, landscape, 200, 200, image.Pt(400, 200), image.Pt(400, 200), image.Pt(0, 0)},
+		{Outside, square, 200, 100, image.Pt(200, 200), image.Pt(200, 200), image.Pt(0, 0)},
+		{Outside, square, 800, 600, image.Pt(800, 800), image.Pt(800, 800), image.Pt(0, 0)},
+
+		{Cover, square, 0, 0, image.Pt(400, 400), image.Pt(400, 400), image.Pt(0, 0)},
+	}
+
+	for _, test := range tests {
+		g := test.fit.Geometry(test.width, test.height, test.bounds)
+		scaled := image.Pt(int(g.Width), int(g.Height))
+		if scaled != test.scaled || g.Canvas.Size() != test.canvas || g.Offset != test.offset {
+			t.Errorf("%v of %v into %dx%d: scaled to %v on %v at %v, want %v on %v at %v",
+				test.fit, test.bounds.Size(), test.width, test.height,
+				scaled, g.Canvas.Size(), g.Offset, test.scaled, test.canvas, test.offset)
+		}
+	}
+}
+
+func TestParseFit(t *testing.T) {
+	for _, name := range []string{"fill", "contain", "cover", "inside", "outside"} {
+		fit, ok := ParseFit(name)
+		if !ok || fit.String() != name {
+			t.Errorf("ParseFit(%q) = %v, %v", name, fit, ok)
+		}
+	}
+	if _, ok := ParseFit("stretch"); ok {
+		t.Error("ParseFit accepted an unknown mode")
+	}
+}
+
+func TestResizeWithFit(t *testing.T) {
+	black := image.NewYCbCr(image.Rect(0, 0, 60, 30), image.YCbCrSubsampleRatio420)
+	for i := range black.Cb {
+		black.Cb[i], black.Cr[i] = 128, 128
+	}
+	var ycbcr image.Image = black
+	var rgba image.Image = image.NewRGBA(image.Rect(0, 0, 60, 30))
+	red := color.RGBA{255, 0, 0, 255}
+
+	contained := *ResizeWith(40, 40, &ycbcr, Options{Fit: Contain, Gravity: Center, Background: red})
+	if _, ok := contained.(*image.YCbCr); !ok {
+		t.Errorf("contained YCbCr image is %T", contained)
+	}
+	if contained.Bounds() != image.Rect(0, 0, 40, 40) {
+		t.Errorf("contained image covers %v", contained.Bounds())
+	}
+	r, g, b, _ := contained.At(20, 2).RGBA()
+	if r>>8 < 250 || g>>8 > 5 || b>>8 > 5 {
+		t.Errorf("padding is %v, want red", contained.At(20, 2))
+	}
+	if got := color.GrayModel.Convert(contained.At(20, 20)).(color.Gray); got.Y > 5 {
+		t.Errorf("image is %v, want black", got)
+	}
+
+	padded := *ResizeWith(40, 40, &rgba, Options{Fit: Contain, Gravity: Center, Background: color.Transparent})
+	if _, _, _, a := padded.At(20, 2).RGBA(); a != 0 {
+		t.Errorf("padding alpha = %d, want transparent", a)
+	}
+	if _, _, _, a := padded.At(20, 20).RGBA(); a != 0 {
+		t.Errorf("image alpha = %d, want the transparent source", a)
+	}
+
+	covered := *ResizeWith(20, 20, &ycbcr, Options{Fit: Cover, Gravity: Center, Background: red})
+	if _, ok := covered.(*image.YCbCr); !ok {
+		t.Errorf("covered YCbCr image is %T", covered)
+	}
+	if covered.Bounds().Size() != image.Pt(20, 20) {
+		t.Errorf("covered image is %v", covered.Bounds().Size())
+	}
+}
@@ -0,0 +1,159 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// Levels are converted between sRGB and linear light through tables of
+// all 16-bit levels, built on first use.
+var (
+	linearOnce           sync.Once
+	toLinear, fromLinear []uint16
+)
+
+// linearTables returns the tables converting sRGB levels to linear light
+// and back.
+func linearTables() (to, from []uint16) {
+	linearOnce.Do(func() {
+		toLinear, fromLinear = make([]uint16, 1<<16), make([]uint16, 1<<16)
+		for i := range toLinear {
+			v := float64(i) / 0xffff
+			toLinear[i] = uint16(math.Round(srgbToLinear(v) * 0xffff))
+			fromLinear[i] = uint16(math.Round(linearToSRGB(v) * 0xffff))
+		}
+	})
+	return toLinear, fromLinear
+}
+
+// srgbToLinear decodes an sRGB level in [0, 1] to linear light.
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB encodes linear light in [0, 1] as an sRGB level.
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// convertLevels maps the un-premultiplied color levels of p through table
+// in place, leaving alpha alone.
+func convertLevels(p *image.RGBA64, table []uint16) {
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		i := p.PixOffset(p.Rect.Min.X, y)
+		for x := p.Rect.Min.X; x < p.Rect.Max.X; x, i = x+1, i+8 {
+			alpha := uint32(p.Pix[i+6])<<8 | uint32(p.Pix[i+7])
+			for c := 0; c < 6; c += 2 {
+				level := uint32(p.Pix[i+c])<<8 | uint32(p.Pix[i+c+1])
+				// Premultiplied colors cannot exceed the alpha, though
+				// filters that ring may leave them above it.
+				if level > alpha {
+					level = alpha
+				}
+				if alpha != 0 {
+					level = uint32(table[level*0xffff/alpha]) * alpha / 0xffff
+				}
+				p.Pix[i+c], p.Pix[i+c+1] = uint8(level>>8), uint8(level)
+			}
+		}
+	}
+}
+
+// linearRGBA64 copies img into an RGBA64 image at the origin holding
+// premultiplied linear light.
+func linearRGBA64(img image.Image) *image.RGBA64 {
+	to, _ := linearTables()
+	bounds := img.Bounds()
+	result := image.NewRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	// imageYCbCrToYCC reads 4:1:1 and 4:1:0 chroma as 4:4:4, so those
+	// rare ratios take the generic path.
+	var yc *ycc
+	if src, ok := img.(*image.YCbCr); ok && src.SubsampleRatio != image.YCbCrSubsampleRatio411 && src.SubsampleRatio != image.YCbCrSubsampleRatio410 {
+		yc = imageYCbCrToYCC(src)
+	}
+
+	parallel(result, func(slice image.Image) {
+		p := slice.(*image.RGBA64)
+		for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+			i := p.PixOffset(p.Rect.Min.X, y)
+			if yc != nil {
+				k := yc.PixOffset(p.Rect.Min.X, y)
+				for x := p.Rect.Min.X; x < p.Rect.Max.X; x, i, k = x+1, i+8, k+3 {
+					r, g, b, _ := color.YCbCr{Y: yc.Pix[k], Cb: yc.Pix[k+1], Cr: yc.Pix[k+2]}.RGBA()
+					setRGBA64(p.Pix[i:i+8], r, g, b, 0xffff)
+				}
+				continue
+			}
+			switch src := img.(type) {
+			case *image.RGBA:
+				k := src.PixOffset(p.Rect.Min.X+bounds.Min.X, y+bounds.Min.Y)
+				for x := p.Rect.Min.X; x < p.Rect.Max.X; x, i, k = x+1, i+8, k+4 {
+					s := src.Pix[k : k+4]
+					setRGBA64(p.Pix[i:i+8], uint32(s[0])*0x101, uint32(s[1])*0x101, uint32(s[2])*0x101, uint32(s[3])*0x101)
+				}
+			case *image.NRGBA:
+				k := src.PixOffset(p.Rect.Min.X+bounds.Min.X, y+bounds.Min.Y)
+				for x := p.Rect.Min.X; x < p.Rect.Max.X; x, i, k = x+1, i+8, k+4 {
+					s := src.Pix[k : k+4]
+					// Premultiply the way color.NRGBA does.
+					a := uint32(s[3])
+					setRGBA64(p.Pix[i:i+8], uint32(s[0])*0x101*a/0xff, uint32(s[1])*0x101*a/0xff, uint32(s[2])*0x101*a/0xff, a*0x101)
+				}
+			default:
+				for x := p.Rect.Min.X; x < p.Rect.Max.X; x++ {
+					p.Set(x, y, img.At(x+bounds.Min.X, y+bounds.Min.Y))
+				}
+			}
+		}
+		convertLevels(p, to)
+	})
+	return result
+}
+
+// setRGBA64 stores 16-bit premultiplied levels in the 8 bytes of pix.
+func setRGBA64(pix []uint8, r, g, b, a uint32) {
+	pix[0], pix[1] = uint8(r>>8), uint8(r)
+	pix[2], pix[3] = uint8(g>>8), uint8(g)
+	pix[4], pix[5] = uint8(b>>8), uint8(b)
+	pix[6], pix[7] = uint8(a>>8), uint8(a)
+}
+
+// resampleLinear is resample in linear light. The image is converted to
+// 16-bit linear light, filtered and converted back to sRGB. YCbCr images
+// come back as 4:4:4 YCbCr, all others as RGBA64.
+func resampleLinear(width, height int, scaleX, scaleY float64, img *image.Image, taps int, kernel func(float64) float64, blur float64) image.Image {
+	var linear image.Image = linearRGBA64(*img)
+	result := filter16(&linear, width, height, scaleX, scaleY, taps, kernel, blur)
+	_, from := linearTables()
+	if _, ok := (*img).(*image.YCbCr); !ok {
+		parallel(result, func(slice image.Image) {
+			convertLevels(slice.(*image.RGBA64), from)
+		})
+		return result
+	}
+
+	out := newYCC(result.Rect, image.YCbCrSubsampleRatio444)
+	parallel(out, func(slice image.Image) {
+		p := slice.(*ycc)
+		for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+			i, k := result.PixOffset(p.Rect.Min.X, y), p.PixOffset(p.Rect.Min.X, y)
+			for x := p.Rect.Min.X; x < p.Rect.Max.X; x, i, k = x+1, i+8, k+3 {
+				var rgb [3]uint8
+				for c := range rgb {
+					rgb[c] = uint8((uint32(from[uint16(result.Pix[i+2*c])<<8|uint16(result.Pix[i+2*c+1])])*0xff + 0x7fff) / 0xffff)
+				}
+				p.Pix[k], p.Pix[k+1], p.Pix[k+2] = color.RGBToYCbCr(rgb[0], rgb[1], rgb[2])
+			}
+		}
+	})
+	return out.YCbCr()
+}
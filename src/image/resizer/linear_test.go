@@ -0,0 +1,122 @@
+package resizer
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestLinear(t *testing.T) {
+	checker := func(x, y int) uint8 {
+		return uint8((x + y) % 2 * 255)
+	}
+	// The nrgba checkerboard has transparent black squares instead.
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if checker(x, y) != 0 {
+				nrgba.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			}
+		}
+	}
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		linear bool
+		// want is the color in the middle of the scaled image.
+		want color.NRGBA
+	}{
+		// Averaging the levels of black and white gives a mid gray far
+		// darker than the checkerboard looks, averaging the light gives
+		// the gray it looks like.
+		{"ycbcr", grayYCbCr(64, 64, checker), false, color.NRGBA{128, 128, 128, 255}},
+		{"ycbcr", grayYCbCr(64, 64, checker), true, color.NRGBA{188, 188, 188, 255}},
+		{"flat", grayYCbCr(64, 64, func(x, y int) uint8 { return 100 }), true, color.NRGBA{100, 100, 100, 255}},
+		// Transparent pixels carry no light.
+		{"nrgba", nrgba, false, color.NRGBA{255, 255, 255, 128}},
+		{"nrgba", nrgba, true, color.NRGBA{255, 255, 255, 128}},
+	}
+
+	for _, test := range tests {
+		img := test.img
+		result := ResizeWith(16, 16, &img, Options{Filter: Lanczos3, Linear: test.linear})
+		if _, ycbcr := test.img.(*image.YCbCr); ycbcr {
+			if _, ok := (*result).(*image.YCbCr); !ok {
+				t.Errorf("%s, linear %v: got a %T", test.name, test.linear, *result)
+			}
+		}
+		got := color.NRGBAModel.Convert((*result).At(8, 8)).(color.NRGBA)
+		if !near(got, test.want, 2) {
+			t.Errorf("%s, linear %v: got %v, want %v", test.name, test.linear, got, test.want)
+		}
+	}
+}
+
+func TestLinearUpscale(t *testing.T) {
+	// Ringing around a sharp edge between translucent white and opaque
+	// black pushes the color of the white side above its alpha.
+	var img image.Image = image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			c := color.NRGBA{0, 0, 0, 255}
+			if (x/2+y/2)%2 == 0 {
+				c = color.NRGBA{255, 255, 255, 64}
+			}
+			img.(*image.NRGBA).SetNRGBA(x, y, c)
+		}
+	}
+
+	result := ResizeWith(100, 100, &img, Options{Filter: Lanczos3, Linear: true})
+	p := (*result).(*image.RGBA64)
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			c := p.RGBA64At(x, y)
+			if c.R > c.A || c.G > c.A || c.B > c.A {
+				t.Fatalf("pixel (%d, %d) = %v is not premultiplied", x, y, c)
+			}
+		}
+	}
+}
+
+func TestLinearRGBA64FastPaths(t *testing.T) {
+	rect := image.Rect(0, 0, 21, 13)
+	rgba, nrgba := image.NewRGBA(rect), image.NewNRGBA(rect)
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			c := color.NRGBA{uint8(x * 12), uint8(y * 19), uint8(x * y), uint8(255 - x*7)}
+			nrgba.SetNRGBA(x, y, c)
+			rgba.Set(x, y, c)
+			ycbcr.Y[ycbcr.YOffset(x, y)] = uint8(x*y + 16)
+			ycbcr.Cb[ycbcr.COffset(x, y)] = uint8(x * 11)
+			ycbcr.Cr[ycbcr.COffset(x, y)] = uint8(255 - y*9)
+		}
+	}
+
+	sub := image.Rect(3, 1, 20, 12)
+	for _, img := range []image.Image{
+		rgba, nrgba, ycbcr,
+		rgba.SubImage(sub), nrgba.SubImage(sub), ycbcr.SubImage(sub),
+	} {
+		// Hiding the type forces the generic path through At.
+		got, want := linearRGBA64(img), linearRGBA64(struct{ image.Image }{img})
+		if got.Rect != want.Rect || !bytes.Equal(got.Pix, want.Pix) {
+			t.Errorf("%T %v: fast path differs from At", img, img.Bounds())
+		}
+	}
+}
+
+func TestLinearTables(t *testing.T) {
+	to, from := linearTables()
+	for i := 0; i < 256; i++ {
+		level := uint16(i) * 0x101
+		if got := (int(from[to[level]]) + 0x80) / 0x101; got != i {
+			t.Errorf("level %d comes back as %d", i, got)
+		}
+	}
+	if v := to[0x8080]; v < 0x3700 || v > 0x3800 {
+		t.Errorf("sRGB 0.502 is linear %#x, want about 0.216", v)
+	}
+}
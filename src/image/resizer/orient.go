@@ -0,0 +1,227 @@
+package resizer
+
+import (
+	"image"
+)
+
+// Orientation describes one of the eight ways to turn and mirror an image:
+// transposing it along its main diagonal, then mirroring it horizontally
+// and vertically. The zero value leaves the image as it is.
+type Orientation struct {
+	Transpose, FlipX, FlipY bool
+}
+
+// Rotation returns the orientation that turns an image clockwise by
+// degrees, which must be a multiple of 90.
+func Rotation(degrees int) (Orientation, bool) {
+	switch (degrees%360 + 360) % 360 {
+	case 0:
+		return Orientation{}, true
+	case 90:
+		return Orientation{Transpose: true, FlipX: true}, true
+	case 180:
+		return Orientation{FlipX: true, FlipY: true}, true
+	case 270:
+		return Orientation{Transpose: true, FlipY: true}, true
+	}
+	return Orientation{}, false
+}
+
+// Then returns the orientation that applies o and then next.
+func (o Orientation) Then(next Orientation) Orientation {
+	if next.Transpose {
+		o.FlipX, o.FlipY = o.FlipY, o.FlipX
+	}
+	return Orientation{o.Transpose != next.Transpose, o.FlipX != next.FlipX, o.FlipY != next.FlipY}
+}
+
+// Mirrors reports whether o mirrors the image rather than only turning
+// it.
+func (o Orientation) Mirrors() bool {
+	return o.Transpose != (o.FlipX != o.FlipY)
+}
+
+// Size returns the size of an image of the given size once reoriented.
+func (o Orientation) Size(size image.Point) image.Point {
+	if o.Transpose {
+		return image.Pt(size.Y, size.X)
+	}
+	return size
+}
+
+// Rect returns where the part r of an image of the given size ends up
+// once the image is reoriented, in an image whose bounds start at the
+// origin.
+func (o Orientation) Rect(r image.Rectangle, size image.Point) image.Rectangle {
+	if o.Transpose {
+		r = image.Rect(r.Min.Y, r.Min.X, r.Max.Y, r.Max.X)
+	}
+	size = o.Size(size)
+	if o.FlipX {
+		r.Min.X, r.Max.X = size.X-r.Max.X, size.X-r.Min.X
+	}
+	if o.FlipY {
+		r.Min.Y, r.Max.Y = size.Y-r.Max.Y, size.Y-r.Min.Y
+	}
+	return r
+}
+
+// source returns the point of the original image, relative to its
+// bounds, that ends up at p of a reoriented image of the given size.
+func (o Orientation) source(p, size image.Point) image.Point {
+	if o.FlipX {
+		p.X = size.X - 1 - p.X
+	}
+	if o.FlipY {
+		p.Y = size.Y - 1 - p.Y
+	}
+	if o.Transpose {
+		p.X, p.Y = p.Y, p.X
+	}
+	return p
+}
+
+// Reorient turns and mirrors an image as o describes. Unless o is the zero
+// value, which returns the image itself, the result starts at the origin
+// and has the type of the input for the standard image types. YCbCr
+// images keep their chroma subsampling, swapped between 4:2:2 and 4:4:0
+// when transposed; transposed 4:1:1 and 4:1:0 images become 4:4:4.
+func Reorient(img *image.Image, o Orientation) *image.Image {
+	if o == (Orientation{}) {
+		return img
+	}
+	result := reorient(*img, o)
+	return &result
+}
+
+func reorient(img image.Image, o Orientation) image.Image {
+	r := image.Rectangle{Max: o.Size(img.Bounds().Size())}
+	switch src := img.(type) {
+	case *image.YCbCr:
+		return reorientYCbCr(src, o)
+	case *image.Gray:
+		dst := image.NewGray(r)
+		reorientPix(dst.Pix, dst.Stride, src.Pix, src.Stride, 1, r.Max, o)
+		return dst
+	case *image.Gray16:
+		dst := image.NewGray16(r)
+		reorientPix(dst.Pix, dst.Stride, src.Pix, src.Stride, 2, r.Max, o)
+		return dst
+	case *image.Alpha:
+		dst := image.NewAlpha(r)
+		reorientPix(dst.Pix, dst.Stride, src.Pix, src.Stride, 1, r.Max, o)
+		return dst
+	case *image.Alpha16:
+		dst := image.NewAlpha16(r)
+		reorientPix(dst.Pix, dst.Stride, src.Pix, src.Stride, 2, r.Max, o)
+		return dst
+	case *image.Paletted:
+		dst := image.NewPaletted(r, src.Palette)
+		reorientPix(dst.Pix, dst.Stride, src.Pix, src.Stride, 1, r.Max, o)
+		return dst
+	case *image.RGBA:
+		dst := image.NewRGBA(r)
+		reorientPix(dst.Pix, dst.Stride, src.Pix, src.Stride, 4, r.Max, o)
+		return dst
+	case *image.NRGBA:
+		dst := image.NewNRGBA(r)
+		reorientPix(dst.Pix, dst.Stride, src.Pix, src.Stride, 4, r.Max, o)
+		return dst
+	case *image.CMYK:
+		dst := image.NewCMYK(r)
+		reorientPix(dst.Pix, dst.Stride, src.Pix, src.Stride, 4, r.Max, o)
+		return dst
+	case *image.RGBA64:
+		dst := image.NewRGBA64(r)
+		reorientPix(dst.Pix, dst.Stride, src.Pix, src.Stride, 8, r.Max, o)
+		return dst
+	case *image.NRGBA64:
+		dst := image.NewNRGBA64(r)
+		reorientPix(dst.Pix, dst.Stride, src.Pix, src.Stride, 8, r.Max, o)
+		return dst
+	}
+
+	min := img.Bounds().Min
+	dst := image.NewRGBA64(r)
+	for y := 0; y < r.Max.Y; y++ {
+		for x := 0; x < r.Max.X; x++ {
+			p := o.source(image.Pt(x, y), r.Max).Add(min)
+			dst.Set(x, y, img.At(p.X, p.Y))
+		}
+	}
+	return dst
+}
+
+// reorientPix copies pixels of bpp bytes into a reoriented image of the
+// given size.
+func reorientPix(dst []uint8, dstStride int, src []uint8, srcStride, bpp int, size image.Point, o Orientation) {
+	for y := 0; y < size.Y; y++ {
+		i := y * dstStride
+		for x := 0; x < size.X; x++ {
+			p := o.source(image.Pt(x, y), size)
+			j := p.Y*srcStride + p.X*bpp
+			copy(dst[i:i+bpp], src[j:j+bpp])
+			i += bpp
+		}
+	}
+}
+
+// reorientYCbCr reorients the luma and chroma planes of a YCbCr image
+// separately. Every chroma sample is taken from the sample of the original
+// covering the first pixel it covers, which is exact unless the
+// subsampling does not evenly divide the image.
+func reorientYCbCr(src *image.YCbCr, o Orientation) *image.YCbCr {
+	bounds := src.Bounds()
+	ratio := src.SubsampleRatio
+	if o.Transpose {
+		switch ratio {
+		case image.YCbCrSubsampleRatio422:
+			ratio = image.YCbCrSubsampleRatio440
+		case image.YCbCrSubsampleRatio440:
+			ratio = image.YCbCrSubsampleRatio422
+		case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+			ratio = image.YCbCrSubsampleRatio444
+		}
+	}
+	size := o.Size(bounds.Size())
+	dst := image.NewYCbCr(image.Rectangle{Max: size}, ratio)
+
+	for y := 0; y < size.Y; y++ {
+		i := y * dst.YStride
+		for x := 0; x < size.X; x++ {
+			p := o.source(image.Pt(x, y), size).Add(bounds.Min)
+			dst.Y[i] = src.Y[src.YOffset(p.X, p.Y)]
+			i++
+		}
+	}
+
+	sx, sy := subsampleFactors(ratio)
+	for cy := 0; cy*sy < size.Y; cy++ {
+		i := cy * dst.CStride
+		for cx := 0; cx*sx < size.X; cx++ {
+			p := o.source(image.Pt(cx*sx, cy*sy), size).Add(bounds.Min)
+			j := src.COffset(p.X, p.Y)
+			dst.Cb[i], dst.Cr[i] = src.Cb[j], src.Cr[j]
+			i++
+		}
+	}
+	return dst
+}
+
+// subsampleFactors returns how many pixels across and down share a chroma
+// sample.
+func subsampleFactors(ratio image.YCbCrSubsampleRatio) (int, int) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2
+	case image.YCbCrSubsampleRatio440:
+		return 1, 2
+	case image.YCbCrSubsampleRatio411:
+		return 4, 1
+	case image.YCbCrSubsampleRatio410:
+		return 4, 2
+	}
+	return 1, 1
+}
@@ -0,0 +1,154 @@
+package resizer
+
+import (
+	"image"
+	"testing"
+)
+
+// orientations lists all eight orientations, the zero value first.
+func orientations() []Orientation {
+	var all []Orientation
+	for i := 0; i < 8; i++ {
+		all = append(all, Orientation{Transpose: i&4 != 0, FlipX: i&2 != 0, FlipY: i&1 != 0})
+	}
+	return all
+}
+
+// forward returns where the pixel at p of an image of the given size ends
+// up once it is reoriented.
+func forward(o Orientation, p, size image.Point) image.Point {
+	if o.Transpose {
+		p.X, p.Y = p.Y, p.X
+		size.X, size.Y = size.Y, size.X
+	}
+	if o.FlipX {
+		p.X = size.X - 1 - p.X
+	}
+	if o.FlipY {
+		p.Y = size.Y - 1 - p.Y
+	}
+	return p
+}
+
+var transposedRatios = map[image.YCbCrSubsampleRatio]image.YCbCrSubsampleRatio{
+	image.YCbCrSubsampleRatio444: image.YCbCrSubsampleRatio444,
+	image.YCbCrSubsampleRatio422: image.YCbCrSubsampleRatio440,
+	image.YCbCrSubsampleRatio420: image.YCbCrSubsampleRatio420,
+	image.YCbCrSubsampleRatio440: image.YCbCrSubsampleRatio422,
+	image.YCbCrSubsampleRatio411: image.YCbCrSubsampleRatio444,
+	image.YCbCrSubsampleRatio410: image.YCbCrSubsampleRatio444,
+}
+
+func TestReorientYCbCr(t *testing.T) {
+	for ratio := range transposedRatios {
+		full := image.NewYCbCr(image.Rect(0, 0, 20, 12), ratio)
+		for i := range full.Y {
+			full.Y[i] = uint8(i)
+		}
+		for i := range full.Cb {
+			full.Cb[i], full.Cr[i] = uint8(i), uint8(255-i)
+		}
+		// A part starting on a chroma sample, whose size the subsampling
+		// divides.
+		src := full.SubImage(image.Rect(4, 2, 20, 10)).(*image.YCbCr)
+		size := src.Bounds().Size()
+
+		for _, o := range orientations()[1:] {
+			var img image.Image = src
+			dst, ok := (*Reorient(&img, o)).(*image.YCbCr)
+			if !ok {
+				t.Fatalf("%v %+v: result is not YCbCr", ratio, o)
+			}
+			if dst.Bounds() != (image.Rectangle{Max: o.Size(size)}) {
+				t.Fatalf("%v %+v: bounds = %v", ratio, o, dst.Bounds())
+			}
+			want := ratio
+			if o.Transpose {
+				want = transposedRatios[ratio]
+			}
+			if dst.SubsampleRatio != want {
+				t.Errorf("%v %+v: subsampling = %v, want %v", ratio, o, dst.SubsampleRatio, want)
+			}
+			for y := 0; y < size.Y; y++ {
+				for x := 0; x < size.X; x++ {
+					p := forward(o, image.Pt(x, y), size)
+					want := src.YCbCrAt(x+4, y+2)
+					if got := dst.YCbCrAt(p.X, p.Y); got != want {
+						t.Fatalf("%v %+v: pixel (%d, %d) moved to %v as %v, want %v", ratio, o, x, y, p, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReorient(t *testing.T) {
+	gray := image.NewGray(image.Rect(1, 1, 4, 3))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 10)
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := range nrgba.Pix {
+		nrgba.Pix[i] = uint8(i)
+	}
+
+	for _, src := range []image.Image{gray, nrgba} {
+		bounds := src.Bounds()
+		for _, o := range orientations()[1:] {
+			img := src
+			dst := *Reorient(&img, o)
+			if dst.ColorModel() != src.ColorModel() {
+				t.Errorf("%T %+v: color model changed", src, o)
+			}
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					p := forward(o, image.Pt(x, y).Sub(bounds.Min), bounds.Size())
+					if got, want := dst.At(p.X, p.Y), src.At(x, y); got != want {
+						t.Fatalf("%T %+v: pixel (%d, %d) moved to %v as %v, want %v", src, o, x, y, p, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestOrientation(t *testing.T) {
+	rotate := func(degrees int) Orientation {
+		o, ok := Rotation(degrees)
+		if !ok {
+			t.Fatalf("Rotation(%d) failed", degrees)
+		}
+		return o
+	}
+	if _, ok := Rotation(45); ok {
+		t.Error("Rotation(45) succeeded")
+	}
+	if rotate(90).Then(rotate(90)) != rotate(180) || rotate(-90) != rotate(270) || rotate(450) != rotate(90) {
+		t.Error("rotations do not add up")
+	}
+	for _, degrees := range []int{0, 90, 180, 270} {
+		if rotate(degrees).Mirrors() || !rotate(degrees).Then(Orientation{FlipX: true}).Mirrors() {
+			t.Errorf("Mirrors is wrong about turning by %d degrees", degrees)
+		}
+	}
+
+	size := image.Pt(5, 3)
+	frame := image.Rect(1, 0, 3, 2)
+	for _, a := range orientations() {
+		for _, b := range orientations() {
+			both := a.Then(b)
+			p := image.Pt(1, 2)
+			want := forward(b, forward(a, p, size), a.Size(size))
+			if got := forward(both, p, size); got != want {
+				t.Errorf("%+v then %+v moves %v to %v, want %v", a, b, p, got, want)
+			}
+		}
+
+		r := a.Rect(frame, size)
+		corner := forward(a, frame.Min, size)
+		opposite := forward(a, frame.Max.Sub(image.Pt(1, 1)), size)
+		if !corner.In(r) || !opposite.In(r) || r.Dx()*r.Dy() != frame.Dx()*frame.Dy() {
+			t.Errorf("%+v moves %v to %v", a, frame, r)
+		}
+	}
+}
@@ -2,109 +2,143 @@ package resizer
 
 import (
 	"image"
-	"log"
 	"math"
 	"runtime"
 	"sync"
 )
 
-// values <1 will sharpen the image
-var blur = 1.0
-
-// Resize scales an image to new width and height using the interpolation function interp.
+// Resize scales an image to new width and height using the Lanczos3 filter.
 // A new image with the given dimensions will be returned.
 // If one of the parameters width or height is set to 0, its size will be calculated so that
 // the aspect ratio is that of the originating image.
 // The resizing algorithm uses channels for parallel computation.
 func Resize(width, height uint, img *image.Image) *image.Image {
+	return resample(width, height, img, Lanczos3, 1, false)
+}
+
+// resample is Resize with the given filter. Nearest samples a single
+// pixel, the other filters are stretched by blur to cover all source
+// pixels when scaling down. Values of blur below 1 sharpen the image.
+// Linear filters in linear light rather than in the encoded levels.
+func resample(width, height uint, img *image.Image, filter Filter, blur float64, linear bool) *image.Image {
 	bounds := (*img).Bounds()
 	scaleX, scaleY := calcFactors(width, height, float64(bounds.Dx()), float64(bounds.Dy()))
-	if width == 0 {
-		width = uint(0.7 + float64(bounds.Dx())/scaleX)
-	}
-	if height == 0 {
-		height = uint(0.7 + float64(bounds.Dy())/scaleY)
-	}
+	width, height = Dimensions(width, height, bounds)
 
 	// Trivial case: return input image
 	if int(width) == bounds.Dx() && int(height) == bounds.Dy() {
 		return img
 	}
 
-	taps, kernel := 6, lanczos3
-	cpus := runtime.GOMAXPROCS(runtime.NumCPU())
-	wg := sync.WaitGroup{}
+	taps, kernel := filter.kernel()
+	if filter == Nearest {
+		blur = 0
+	}
 
 	// Generic access to image.Image is slow in tight loops.
 	// The optimal access has to be determined from the concrete image type.
+	var result image.Image
+	if linear {
+		result = resampleLinear(int(width), int(height), scaleX, scaleY, img, taps, kernel, blur)
+		return &result
+	}
 	switch input := (*img).(type) {
 	case *image.YCbCr:
 		// 8-bit precision
 		// accessing the YCbCr arrays in a tight loop is slow.
 		// converting the image to ycc increases performance by 2x.
-		temp := newYCC(image.Rect(0, 0, input.Bounds().Dy(), int(width)), input.SubsampleRatio)
-		result := newYCC(image.Rect(0, 0, int(width), int(height)), image.YCbCrSubsampleRatio444)
-
-		coeffs, offset, filterLength := createWeights8(temp.Bounds().Dy(), taps, blur, scaleX, kernel)
-		in := imageYCbCrToYCC(input)
-		wg.Add(cpus)
-		for i := 0; i < cpus; i++ {
-			slice := makeSlice(temp, i, cpus).(*ycc)
-			go func() {
-				defer wg.Done()
-				resizeYCbCr(in, slice, scaleX, coeffs, offset, filterLength)
-			}()
-		}
-		wg.Wait()
-
-		coeffs, offset, filterLength = createWeights8(result.Bounds().Dy(), taps, blur, scaleY, kernel)
-		wg.Add(cpus)
-		for i := 0; i < cpus; i++ {
-			slice := makeSlice(result, i, cpus).(*ycc)
-			go func() {
-				defer wg.Done()
-				resizeYCbCr(temp, slice, scaleY, coeffs, offset, filterLength)
-			}()
-		}
-		wg.Wait()
-		ycbcr := image.Image(result.YCbCr())
-		img = &ycbcr
+		result = filter8(imageYCbCrToYCC(input), int(width), int(height), scaleX, scaleY, taps, kernel, blur).YCbCr()
 	default:
-		log.Printf("Unknown image type %T", input)
-
 		// 16-bit precision
-		temp := image.NewRGBA64(image.Rect(0, 0, bounds.Dy(), int(width)))
-		result := image.NewRGBA64(image.Rect(0, 0, int(width), int(height)))
-
-		// horizontal filter, results in transposed temporary image
-		coeffs, offset, filterLength := createWeights16(temp.Bounds().Dy(), taps, blur, scaleX, kernel)
-		wg.Add(cpus)
-		for i := 0; i < cpus; i++ {
-			slice := makeSlice(temp, i, cpus).(*image.RGBA64)
-			go func() {
-				defer wg.Done()
-				resizeGeneric(img, slice, scaleX, coeffs, offset, filterLength)
-			}()
-		}
-		wg.Wait()
-
-		// horizontal filter on transposed image, result is not transposed
-		coeffs, offset, filterLength = createWeights16(result.Bounds().Dy(), taps, blur, scaleY, kernel)
-		wg.Add(cpus)
-		for i := 0; i < cpus; i++ {
-			slice := makeSlice(result, i, cpus).(*image.RGBA64)
-			go func() {
-				defer wg.Done()
-				resizeRGBA64(temp, slice, scaleY, coeffs, offset, filterLength)
-			}()
-		}
-		wg.Wait()
+		result = filter16(img, int(width), int(height), scaleX, scaleY, taps, kernel, blur)
+	}
+	return &result
+}
+
+// filter8 runs the two passes of the kernel over a ycc image, scaling it
+// to width×height. Each pass filters the rows of its input and writes them
+// as the columns of its output, so the second pass restores the
+// orientation.
+func filter8(in *ycc, width, height int, scaleX, scaleY float64, taps int, kernel func(float64) float64, blur float64) *ycc {
+	cpus := runtime.GOMAXPROCS(runtime.NumCPU())
+	wg := sync.WaitGroup{}
 
-		rgba64 := image.Image(result)
-		img = &rgba64
+	temp := newYCC(image.Rect(0, 0, in.Bounds().Dy(), width), in.SubsampleRatio)
+	result := newYCC(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio444)
+
+	coeffs, offset, filterLength := createWeights8(temp.Bounds().Dy(), taps, blur, scaleX, kernel)
+	wg.Add(cpus)
+	for i := 0; i < cpus; i++ {
+		slice := makeSlice(temp, i, cpus).(*ycc)
+		go func() {
+			defer wg.Done()
+			resizeYCbCr(in, slice, scaleX, coeffs, offset, filterLength)
+		}()
 	}
+	wg.Wait()
+
+	coeffs, offset, filterLength = createWeights8(result.Bounds().Dy(), taps, blur, scaleY, kernel)
+	wg.Add(cpus)
+	for i := 0; i < cpus; i++ {
+		slice := makeSlice(result, i, cpus).(*ycc)
+		go func() {
+			defer wg.Done()
+			resizeYCbCr(temp, slice, scaleY, coeffs, offset, filterLength)
+		}()
+	}
+	wg.Wait()
+	return result
+}
 
-	return img
+// filter16 is filter8 for any image, in 16-bit precision.
+func filter16(img *image.Image, width, height int, scaleX, scaleY float64, taps int, kernel func(float64) float64, blur float64) *image.RGBA64 {
+	cpus := runtime.GOMAXPROCS(runtime.NumCPU())
+	wg := sync.WaitGroup{}
+
+	temp := image.NewRGBA64(image.Rect(0, 0, (*img).Bounds().Dy(), width))
+	result := image.NewRGBA64(image.Rect(0, 0, width, height))
+
+	// horizontal filter, results in transposed temporary image
+	coeffs, offset, filterLength := createWeights16(temp.Bounds().Dy(), taps, blur, scaleX, kernel)
+	wg.Add(cpus)
+	for i := 0; i < cpus; i++ {
+		slice := makeSlice(temp, i, cpus).(*image.RGBA64)
+		go func() {
+			defer wg.Done()
+			if in, ok := (*img).(*image.RGBA64); ok && in.Rect.Min == (image.Point{}) {
+				resizeRGBA64(in, slice, scaleX, coeffs, offset, filterLength)
+			} else {
+				resizeGeneric(img, slice, scaleX, coeffs, offset, filterLength)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// horizontal filter on transposed image, result is not transposed
+	coeffs, offset, filterLength = createWeights16(result.Bounds().Dy(), taps, blur, scaleY, kernel)
+	wg.Add(cpus)
+	for i := 0; i < cpus; i++ {
+		slice := makeSlice(result, i, cpus).(*image.RGBA64)
+		go func() {
+			defer wg.Done()
+			resizeRGBA64(temp, slice, scaleY, coeffs, offset, filterLength)
+		}()
+	}
+	wg.Wait()
+	return result
+}
+
+// Dimensions returns the size of the image Resize makes of an image with
+// the given bounds, filling in a zero width or height from the aspect ratio.
+func Dimensions(width, height uint, bounds image.Rectangle) (uint, uint) {
+	scaleX, scaleY := calcFactors(width, height, float64(bounds.Dx()), float64(bounds.Dy()))
+	if width == 0 {
+		width = uint(0.7 + float64(bounds.Dx())/scaleX)
+	}
+	if height == 0 {
+		height = uint(0.7 + float64(bounds.Dy())/scaleY)
+	}
+	return width, height
 }
 
 // Calculates scaling factors using old and new image dimensions.
@@ -192,29 +226,6 @@ func createWeights16(dy, filterLength int, blur, scale float64, kernel func(floa
 	return coeffs, start, filterLength
 }
 
-func createWeightsNearest(dy, filterLength int, blur, scale float64) ([]bool, []int, int) {
-	filterLength = filterLength * int(math.Max(math.Ceil(blur*scale), 1))
-	filterFactor := math.Min(1./(blur*scale), 1)
-
-	coeffs := make([]bool, dy*filterLength)
-	start := make([]int, dy)
-	for y := 0; y < dy; y++ {
-		interpX := scale*(float64(y)+0.5) - 0.5
-		start[y] = int(interpX) - filterLength/2 + 1
-		interpX -= float64(start[y])
-		for i := 0; i < filterLength; i++ {
-			in := (interpX - float64(i)) * filterFactor
-			if in >= -0.5 && in < 0.5 {
-				coeffs[y*filterLength+i] = true
-			} else {
-				coeffs[y*filterLength+i] = false
-			}
-		}
-	}
-
-	return coeffs, start, filterLength
-}
-
 // Keep value in [0,255] range.
 func clampUint8(in int32) uint8 {
 	// casting a negative int to an uint will result in an overflown
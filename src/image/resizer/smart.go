@@ -0,0 +1,156 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// analysisSize is the longest side of the copy smart crops are scored on.
+const analysisSize = 256
+
+// Weights of the features that make a region interesting.
+const (
+	edgeWeight       = 1.0
+	saturationWeight = 0.3
+	skinWeight       = 1.8
+)
+
+// skinTone is the direction of typical skin colors in RGB space.
+var skinTone = [3]float64{0.78, 0.57, 0.44}
+
+// SmartCover scales an image to cover a box of width×height like
+// ResizeWith with Cover, keeping the part SmartWindow picks, and resamples
+// it with the filter and blur of options. It also returns the region of the
+// original that was kept.
+func SmartCover(width, height uint, img *image.Image, options Options) (*image.Image, image.Rectangle) {
+	bounds := (*img).Bounds()
+	g := Cover.Geometry(width, height, bounds)
+	if g.Canvas.Dx() >= int(g.Width) && g.Canvas.Dy() >= int(g.Height) {
+		return resample(g.Width, g.Height, img, options.Filter, options.blur(), options.Linear), bounds
+	}
+
+	size := image.Pt(
+		int(math.Round(float64(g.Canvas.Dx())*float64(bounds.Dx())/float64(g.Width))),
+		int(math.Round(float64(g.Canvas.Dy())*float64(bounds.Dy())/float64(g.Height))),
+	)
+	window := SmartWindow(img, size)
+	return resample(uint(g.Canvas.Dx()), uint(g.Canvas.Dy()), Crop(img, window), options.Filter, options.blur(), options.Linear), window
+}
+
+// SmartWindow returns the region of the given size of an image that holds
+// the most detail, saturated color and skin tones. The image is scored on
+// a copy scaled down to at most analysisSize pixels a side. Of equally
+// interesting regions the one closest to the center wins, so the result
+// depends on nothing but the image.
+func SmartWindow(img *image.Image, size image.Point) image.Rectangle {
+	bounds := (*img).Bounds()
+	if size.X > bounds.Dx() {
+		size.X = bounds.Dx()
+	}
+	if size.Y > bounds.Dy() {
+		size.Y = bounds.Dy()
+	}
+	if size == bounds.Size() {
+		return bounds
+	}
+
+	small := img
+	if long := math.Max(float64(bounds.Dx()), float64(bounds.Dy())); long > analysisSize {
+		scale := analysisSize / long
+		small = Resize(uint(math.Max(math.Round(float64(bounds.Dx())*scale), 1)), uint(math.Max(math.Round(float64(bounds.Dy())*scale), 1)), img)
+	}
+	w, h := (*small).Bounds().Dx(), (*small).Bounds().Dy()
+	fx, fy := float64(w)/float64(bounds.Dx()), float64(h)/float64(bounds.Dy())
+	window := image.Pt(clampInt(int(math.Round(float64(size.X)*fx)), 1, w), clampInt(int(math.Round(float64(size.Y)*fy)), 1, h))
+
+	// sums is the summed-area table of the scores.
+	scores := scoreMap(*small)
+	sums := make([]float64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		row := 0.0
+		for x := 0; x < w; x++ {
+			row += scores[y*w+x]
+			sums[(y+1)*(w+1)+x+1] = sums[y*(w+1)+x+1] + row
+		}
+	}
+
+	center := image.Pt((w-window.X)/2, (h-window.Y)/2)
+	best, bestScore, bestDistance := image.Point{}, -1.0, 0
+	for y := 0; y+window.Y <= h; y++ {
+		for x := 0; x+window.X <= w; x++ {
+			score := sums[(y+window.Y)*(w+1)+x+window.X] - sums[y*(w+1)+x+window.X] -
+				sums[(y+window.Y)*(w+1)+x] + sums[y*(w+1)+x]
+			d := image.Pt(x, y).Sub(center)
+			distance := d.X*d.X + d.Y*d.Y
+			if score > bestScore || score == bestScore && distance < bestDistance {
+				best, bestScore, bestDistance = image.Pt(x, y), score, distance
+			}
+		}
+	}
+
+	min := image.Pt(
+		clampInt(int(math.Round(float64(best.X)/fx)), 0, bounds.Dx()-size.X),
+		clampInt(int(math.Round(float64(best.Y)/fy)), 0, bounds.Dy()-size.Y),
+	).Add(bounds.Min)
+	return image.Rectangle{Min: min, Max: min.Add(size)}
+}
+
+// scoreMap rates every pixel of img by how interesting it is: edges in
+// the luminance, saturated colors and skin tones.
+func scoreMap(img image.Image) []float64 {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	rgb := make([][3]float64, w*h)
+	lum := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			alpha := float64(c.A) / 255
+			p := [3]float64{float64(c.R) / 255 * alpha, float64(c.G) / 255 * alpha, float64(c.B) / 255 * alpha}
+			rgb[y*w+x] = p
+			lum[y*w+x] = 0.2126*p[0] + 0.7152*p[1] + 0.0722*p[2]
+		}
+	}
+
+	scores := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			l := lum[i]
+			edge := 4*l - lum[y*w+clampInt(x-1, 0, w-1)] - lum[y*w+clampInt(x+1, 0, w-1)] -
+				lum[clampInt(y-1, 0, h-1)*w+x] - lum[clampInt(y+1, 0, h-1)*w+x]
+			score := edgeWeight * math.Min(math.Abs(edge), 1)
+
+			p := rgb[i]
+			max, min := math.Max(p[0], math.Max(p[1], p[2])), math.Min(p[0], math.Min(p[1], p[2]))
+			if max > 0 && l > 0.05 && l < 0.9 {
+				score += saturationWeight * (max - min) / max
+			}
+
+			if norm := math.Sqrt(p[0]*p[0] + p[1]*p[1] + p[2]*p[2]); norm > 0 && l > 0.2 {
+				var d float64
+				for k := range p {
+					d += (p[k]/norm - skinTone[k]/skinNorm) * (p[k]/norm - skinTone[k]/skinNorm)
+				}
+				if skin := 1 - math.Sqrt(d); skin > 0.8 {
+					score += skinWeight * (skin - 0.8) / 0.2
+				}
+			}
+			scores[i] = score
+		}
+	}
+	return scores
+}
+
+var skinNorm = math.Sqrt(skinTone[0]*skinTone[0] + skinTone[1]*skinTone[1] + skinTone[2]*skinTone[2])
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,80 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// flat returns a gray image of the given size with r filled by fill.
+func flat(width, height int, r image.Rectangle, fill func(x, y int) color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{128, 128, 128, 255}), image.Point{}, draw.Src)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.Set(x, y, fill(x, y))
+		}
+	}
+	return img
+}
+
+func TestSmartWindow(t *testing.T) {
+	checkers := func(x, y int) color.Color {
+		if (x/2+y/2)%2 == 0 {
+			return color.Black
+		}
+		return color.White
+	}
+	skin := func(x, y int) color.Color { return color.RGBA{224, 172, 138, 255} }
+	saturated := func(x, y int) color.Color { return color.RGBA{0, 40, 220, 255} }
+
+	tests := []struct {
+		name    string
+		img     image.Image
+		size    image.Point
+		subject image.Rectangle
+	}{
+		{"detail", flat(400, 200, image.Rect(300, 120, 360, 180), checkers), image.Pt(200, 200), image.Rect(300, 120, 360, 180)},
+		{"skin", flat(300, 600, image.Rect(40, 30, 120, 130), skin), image.Pt(300, 300), image.Rect(40, 30, 120, 130)},
+		{"saturation", flat(1000, 500, image.Rect(50, 200, 150, 300), saturated), image.Pt(300, 500), image.Rect(50, 200, 150, 300)},
+		{"offset bounds", flat(400, 200, image.Rect(20, 10, 80, 70), checkers).SubImage(image.Rect(10, 0, 400, 200)), image.Pt(150, 150), image.Rect(20, 10, 80, 70)},
+	}
+	for _, test := range tests {
+		img := test.img
+		window := SmartWindow(&img, test.size)
+		if window.Size() != test.size || !window.In(img.Bounds()) {
+			t.Errorf("%s: window %v is not %v within %v", test.name, window, test.size, img.Bounds())
+		}
+		if !test.subject.In(window) {
+			t.Errorf("%s: window %v misses the subject at %v", test.name, window, test.subject)
+		}
+		if again := SmartWindow(&img, test.size); again != window {
+			t.Errorf("%s: window changed from %v to %v", test.name, window, again)
+		}
+	}
+
+	// A featureless image is cropped in the center.
+	var plain image.Image = flat(300, 100, image.Rectangle{}, nil)
+	if window := SmartWindow(&plain, image.Pt(100, 100)); window != image.Rect(100, 0, 200, 100) {
+		t.Errorf("featureless window = %v, want the center", window)
+	}
+}
+
+func TestSmartCover(t *testing.T) {
+	var img image.Image = flat(600, 300, image.Rect(500, 100, 560, 160), func(x, y int) color.Color {
+		return color.RGBA{224, 172, 138, 255}
+	})
+	result, window := SmartCover(100, 100, &img, Options{})
+	if (*result).Bounds().Size() != image.Pt(100, 100) {
+		t.Errorf("result is %v, want 100x100", (*result).Bounds().Size())
+	}
+	if window.Size() != image.Pt(300, 300) || window.Min.X < 260 {
+		t.Errorf("window %v misses the subject on the right", window)
+	}
+
+	fit := *ResizeWith(100, 100, &img, Options{Fit: Cover, Gravity: Smart, Background: color.White})
+	if fit.Bounds().Size() != image.Pt(100, 100) {
+		t.Errorf("ResizeWith with Smart gravity is %v, want 100x100", fit.Bounds().Size())
+	}
+}
@@ -0,0 +1,199 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// ColorMatrix is an affine map of red, green and blue levels between 0 and
+// 1. Row i gives level i as the dot product of the row with (r, g, b, 1).
+type ColorMatrix [3][4]float64
+
+// IdentityMatrix leaves colors as they are.
+var IdentityMatrix = ColorMatrix{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}}
+
+// GrayMatrix replaces colors by their luma.
+var GrayMatrix = ColorMatrix{{0.299, 0.587, 0.114, 0}, {0.299, 0.587, 0.114, 0}, {0.299, 0.587, 0.114, 0}}
+
+// SepiaMatrix gives colors the brown tone of old photographs.
+var SepiaMatrix = ColorMatrix{{0.393, 0.769, 0.189, 0}, {0.349, 0.686, 0.168, 0}, {0.272, 0.534, 0.131, 0}}
+
+// levels returns the red, green and blue levels of a color divided by its
+// alpha, between 0 and 1, and the alpha.
+func levels(c color.Color) ([3]float64, float64) {
+	r, g, b, a := c.RGBA()
+	if a == 0 {
+		return [3]float64{}, 0
+	}
+	return [3]float64{float64(r) / float64(a), float64(g) / float64(a), float64(b) / float64(a)}, float64(a) / 0xffff
+}
+
+// DuotoneMatrix maps the luma of colors onto the range from dark to light.
+func DuotoneMatrix(dark, light color.Color) ColorMatrix {
+	d, _ := levels(dark)
+	l, _ := levels(light)
+	var m ColorMatrix
+	for i := range m {
+		m[i] = [4]float64{0.299 * (l[i] - d[i]), 0.587 * (l[i] - d[i]), 0.114 * (l[i] - d[i]), d[i]}
+	}
+	return m
+}
+
+// TintMatrix blends colors with tint by the alpha of tint.
+func TintMatrix(tint color.Color) ColorMatrix {
+	t, a := levels(tint)
+	var m ColorMatrix
+	for i := range m {
+		m[i][i] = 1 - a
+		m[i][3] = a * t[i]
+	}
+	return m
+}
+
+// Then returns the matrix that applies m and then next.
+func (m ColorMatrix) Then(next ColorMatrix) ColorMatrix {
+	var result ColorMatrix
+	for i := range result {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 3; k++ {
+				result[i][j] += next[i][k] * m[k][j]
+			}
+		}
+		result[i][3] += next[i][3]
+	}
+	return result
+}
+
+// apply maps levels through m, clamping the result to 0 to 1.
+func (m ColorMatrix) apply(rgb [3]float64) [3]float64 {
+	var out [3]float64
+	for i, row := range m {
+		out[i] = clamp01(row[0]*rgb[0] + row[1]*rgb[1] + row[2]*rgb[2] + row[3])
+	}
+	return out
+}
+
+// Color maps a single color through m, keeping its alpha.
+func (m ColorMatrix) Color(c color.Color) color.Color {
+	rgb, a := levels(c)
+	if a == 0 {
+		return color.NRGBA64{}
+	}
+	rgb = m.apply(rgb)
+	return color.NRGBA64{
+		R: uint16(math.Round(rgb[0] * 0xffff)),
+		G: uint16(math.Round(rgb[1] * 0xffff)),
+		B: uint16(math.Round(rgb[2] * 0xffff)),
+		A: uint16(math.Round(a * 0xffff)),
+	}
+}
+
+// fromYCC returns m as a map from luma and chroma centered on zero, the
+// coordinates of JFIF YCbCr colors, to red, green and blue levels.
+func (m ColorMatrix) fromYCC() ColorMatrix {
+	toRGB := ColorMatrix{{1, 0, 1.402, 0}, {1, -0.344136, -0.714136, 0}, {1, 1.772, 0, 0}}
+	return toRGB.Then(m)
+}
+
+// Recolor maps the colors of an image through m. YCbCr images are mapped
+// in 8 bits and stay YCbCr. Other images are mapped in 16 bits, on their
+// colors divided by alpha, and become RGBA64 images keeping their alpha.
+func Recolor(img *image.Image, m ColorMatrix) *image.Image {
+	if m == IdentityMatrix {
+		return img
+	}
+
+	var result image.Image
+	if input, ok := (*img).(*image.YCbCr); ok {
+		out := imageYCbCrToYCC(input)
+		recolorYCC(out, m.fromYCC())
+		result = out.YCbCr()
+	} else {
+		out := image.NewRGBA64((*img).Bounds())
+		draw.Draw(out, out.Rect, *img, out.Rect.Min, draw.Src)
+		parallel(out, func(slice image.Image) {
+			recolorRGBA64(slice.(*image.RGBA64), m)
+		})
+		result = out
+	}
+	return &result
+}
+
+// recolorYCC maps p in place through m, which takes YCbCr coordinates to
+// red, green and blue levels, in 16.16 fixed point. The levels are clamped
+// before they are turned back into YCbCr, as in the 16-bit path.
+func recolorYCC(p *ycc, m ColorMatrix) {
+	var fixed [3][4]int32
+	for i, row := range m {
+		for j, v := range row {
+			fixed[i][j] = int32(math.Round(v * (1 << 16)))
+		}
+		// The offset is in levels out of 255.
+		fixed[i][3] = int32(math.Round(row[3] * 255 * (1 << 16)))
+	}
+	parallel(p, func(slice image.Image) {
+		r := slice.Bounds()
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i := p.PixOffset(r.Min.X, y)
+			for x := r.Min.X; x < r.Max.X; x, i = x+1, i+3 {
+				in := [3]int32{int32(p.Pix[i]), int32(p.Pix[i+1]) - 128, int32(p.Pix[i+2]) - 128}
+				var rgb [3]uint8
+				for c, row := range fixed {
+					rgb[c] = clampUint8((row[0]*in[0] + row[1]*in[1] + row[2]*in[2] + row[3] + 1<<15) >> 16)
+				}
+				p.Pix[i], p.Pix[i+1], p.Pix[i+2] = color.RGBToYCbCr(rgb[0], rgb[1], rgb[2])
+			}
+		}
+	})
+}
+
+// recolorRGBA64 maps p in place through m.
+func recolorRGBA64(p *image.RGBA64, m ColorMatrix) {
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		i := p.PixOffset(p.Rect.Min.X, y)
+		for x := p.Rect.Min.X; x < p.Rect.Max.X; x, i = x+1, i+8 {
+			alpha := float64(uint16(p.Pix[i+6])<<8 | uint16(p.Pix[i+7]))
+			if alpha == 0 {
+				continue
+			}
+			var rgb [3]float64
+			for c := range rgb {
+				rgb[c] = float64(uint16(p.Pix[i+2*c])<<8|uint16(p.Pix[i+2*c+1])) / alpha
+			}
+			for c, v := range m.apply(rgb) {
+				level := uint16(math.Round(v * alpha))
+				p.Pix[i+2*c], p.Pix[i+2*c+1] = uint8(level>>8), uint8(level)
+			}
+		}
+	}
+}
+
+// Grayscale returns the luma of an image. Opaque images become Gray
+// images, a single byte per pixel; YCbCr images simply lose their chroma.
+// Other images become RGBA64 images keeping their alpha.
+func Grayscale(img *image.Image) *image.Image {
+	var result image.Image
+	switch src := (*img).(type) {
+	case *image.Gray:
+		return img
+	case *image.YCbCr:
+		r := src.Rect
+		dst := image.NewGray(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i := src.YOffset(r.Min.X, y)
+			copy(dst.Pix[dst.PixOffset(r.Min.X, y):], src.Y[i:i+r.Dx()])
+		}
+		result = dst
+	default:
+		if opaque, ok := src.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+			dst := image.NewGray(src.Bounds())
+			draw.Draw(dst, dst.Rect, src, dst.Rect.Min, draw.Src)
+			result = dst
+		} else {
+			return Recolor(img, GrayMatrix)
+		}
+	}
+	return &result
+}
@@ -0,0 +1,87 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestGrayscale(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 4, 4), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = uint8(i * 10)
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = 30, 220
+	}
+	var img image.Image = src.SubImage(image.Rect(1, 1, 4, 4))
+	gray, ok := (*Grayscale(&img)).(*image.Gray)
+	if !ok || gray.Rect != image.Rect(1, 1, 4, 4) {
+		t.Fatalf("YCbCr grayscale is %T of %v", *Grayscale(&img), (*Grayscale(&img)).Bounds())
+	}
+	if got := gray.GrayAt(2, 3).Y; got != 140 {
+		t.Errorf("gray pixel = %d, want the luma 140", got)
+	}
+
+	red := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < len(red.Pix); i += 4 {
+		red.Pix[i], red.Pix[i+3] = 0xff, 0xff
+	}
+	img = red
+	if gray, ok := (*Grayscale(&img)).(*image.Gray); !ok || gray.GrayAt(1, 1).Y != 76 {
+		t.Errorf("opaque red is %v, want Gray 76", (*Grayscale(&img)).At(1, 1))
+	}
+
+	red.Pix[3] = 0x80
+	translucent := *Grayscale(&img)
+	if got := color.NRGBAModel.Convert(translucent.At(0, 0)).(color.NRGBA); !near(got, color.NRGBA{76, 76, 76, 0x80}, 1) {
+		t.Errorf("translucent red is %v, want gray 76 with its alpha", got)
+	}
+}
+
+func TestRecolor(t *testing.T) {
+	tests := []struct {
+		name     string
+		matrix   ColorMatrix
+		in, want color.NRGBA
+	}{
+		{"sepia", SepiaMatrix, color.NRGBA{128, 128, 128, 255}, color.NRGBA{173, 154, 120, 255}},
+		{"sepia", SepiaMatrix, color.NRGBA{255, 255, 255, 255}, color.NRGBA{255, 255, 239, 255}},
+		{"duotone dark", DuotoneMatrix(color.RGBA{0, 0, 80, 255}, color.RGBA{255, 220, 0, 255}), color.NRGBA{0, 0, 0, 255}, color.NRGBA{0, 0, 80, 255}},
+		{"duotone light", DuotoneMatrix(color.RGBA{0, 0, 80, 255}, color.RGBA{255, 220, 0, 255}), color.NRGBA{255, 255, 255, 255}, color.NRGBA{255, 220, 0, 255}},
+		{"duotone middle", DuotoneMatrix(color.RGBA{0, 0, 80, 255}, color.RGBA{255, 220, 0, 255}), color.NRGBA{128, 128, 128, 255}, color.NRGBA{128, 110, 40, 255}},
+		{"tint", TintMatrix(color.NRGBA{255, 0, 0, 0x80}), color.NRGBA{255, 255, 255, 255}, color.NRGBA{255, 127, 127, 255}},
+		{"opaque tint", TintMatrix(color.NRGBA{0, 0, 255, 255}), color.NRGBA{255, 255, 0, 255}, color.NRGBA{0, 0, 255, 255}},
+		{"gray then tint", GrayMatrix.Then(TintMatrix(color.NRGBA{0, 255, 0, 0x40})), color.NRGBA{255, 0, 0, 255}, color.NRGBA{57, 121, 57, 255}},
+	}
+	for _, test := range tests {
+		if got := color.NRGBAModel.Convert(test.matrix.Color(test.in)).(color.NRGBA); !near(got, test.want, 1) {
+			t.Errorf("%s: Color(%v) = %v, want %v", test.name, test.in, got, test.want)
+		}
+
+		// The YCbCr path rounds twice more.
+		y, cb, cr := color.RGBToYCbCr(test.in.R, test.in.G, test.in.B)
+		var img image.Image = grayYCbCr(4, 4, func(x, _ int) uint8 { return y })
+		for i := range img.(*image.YCbCr).Cb {
+			img.(*image.YCbCr).Cb[i], img.(*image.YCbCr).Cr[i] = cb, cr
+		}
+		recolored, ok := (*Recolor(&img, test.matrix)).(*image.YCbCr)
+		if !ok {
+			t.Fatalf("%s: YCbCr image recolored to %T", test.name, *Recolor(&img, test.matrix))
+		}
+		if got := color.NRGBAModel.Convert(recolored.At(2, 2)).(color.NRGBA); !near(got, test.want, 3) {
+			t.Errorf("%s: YCbCr %v recolored to %v, want %v", test.name, test.in, got, test.want)
+		}
+
+		translucent := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+		for i := 0; i < len(translucent.Pix); i += 4 {
+			translucent.Pix[i], translucent.Pix[i+1], translucent.Pix[i+2], translucent.Pix[i+3] = test.in.R, test.in.G, test.in.B, 0x80
+		}
+		img = translucent
+		want := test.want
+		want.A = 0x80
+		if got := color.NRGBAModel.Convert((*Recolor(&img, test.matrix)).At(1, 1)).(color.NRGBA); !near(got, want, 2) {
+			t.Errorf("%s: translucent %v recolored to %v, want %v", test.name, test.in, got, want)
+		}
+	}
+}
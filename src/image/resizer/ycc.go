@@ -100,7 +100,7 @@ func (p *ycc) YCbCr() *image.YCbCr {
 			for x := ycbcr.Rect.Min.X; x < ycbcr.Rect.Max.X; x++ {
 				xx := (x - ycbcr.Rect.Min.X)
 				yi := yy + xx
-				ci := cy + xx/2
+				ci := cy + x/2 - ycbcr.Rect.Min.X/2
 				ycbcr.Y[yi] = p.Pix[off+0]
 				ycbcr.Cb[ci] = p.Pix[off+1]
 				ycbcr.Cr[ci] = p.Pix[off+2]
@@ -114,7 +114,7 @@ func (p *ycc) YCbCr() *image.YCbCr {
 			for x := ycbcr.Rect.Min.X; x < ycbcr.Rect.Max.X; x++ {
 				xx := (x - ycbcr.Rect.Min.X)
 				yi := yy + xx
-				ci := cy + xx/2
+				ci := cy + x/2 - ycbcr.Rect.Min.X/2
 				ycbcr.Y[yi] = p.Pix[off+0]
 				ycbcr.Cb[ci] = p.Pix[off+1]
 				ycbcr.Cr[ci] = p.Pix[off+2]
@@ -170,7 +170,7 @@ func imageYCbCrToYCC(in *image.YCbCr) *ycc {
 			for x := in.Rect.Min.X; x < in.Rect.Max.X; x++ {
 				xx := (x - in.Rect.Min.X)
 				yi := yy + xx
-				ci := cy + xx/2
+				ci := cy + x/2 - in.Rect.Min.X/2
 				p.Pix[off+0] = in.Y[yi]
 				p.Pix[off+1] = in.Cb[ci]
 				p.Pix[off+2] = in.Cr[ci]
@@ -184,7 +184,7 @@ func imageYCbCrToYCC(in *image.YCbCr) *ycc {
 			for x := in.Rect.Min.X; x < in.Rect.Max.X; x++ {
 				xx := (x - in.Rect.Min.X)
 				yi := yy + xx
-				ci := cy + xx/2
+				ci := cy + x/2 - in.Rect.Min.X/2
 				p.Pix[off+0] = in.Y[yi]
 				p.Pix[off+1] = in.Cb[ci]
 				p.Pix[off+2] = in.Cr[ci]
@@ -223,3 +223,32 @@ func imageYCbCrToYCC(in *image.YCbCr) *ycc {
 	}
 	return &p
 }
+
+// imageGrayToYCC converts a gray image to a ycc image with neutral chroma,
+// so that it can be filtered as luma.
+func imageGrayToYCC(in *image.Gray) *ycc {
+	w, h := in.Rect.Dx(), in.Rect.Dy()
+	p := newYCC(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio444)
+	for y := 0; y < h; y++ {
+		row := in.Pix[in.PixOffset(in.Rect.Min.X, in.Rect.Min.Y+y):]
+		for x, off := 0, y*p.Stride; x < w; x, off = x+1, off+3 {
+			p.Pix[off+0] = row[x]
+			p.Pix[off+1] = 128
+			p.Pix[off+2] = 128
+		}
+	}
+	return p
+}
+
+// Gray converts the luma of ycc to a gray image.
+func (p *ycc) Gray() *image.Gray {
+	gray := image.NewGray(p.Rect)
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		row := gray.Pix[gray.PixOffset(p.Rect.Min.X, y):]
+		off := p.PixOffset(p.Rect.Min.X, y)
+		for x := 0; x < p.Rect.Dx(); x, off = x+1, off+3 {
+			row[x] = p.Pix[off]
+		}
+	}
+	return gray
+}
@@ -0,0 +1,98 @@
+package tiff
+
+// TIFF LZW differs from the LZW of compress/lzw: codes are packed most
+// significant bit first and the code width grows one code early.
+
+const (
+	lzwClear = 256
+	lzwEOI   = 257
+)
+
+// decodeLZW decompresses TIFF LZW data into at most max bytes. Data cut
+// off without an end of information code decodes as far as it goes.
+func decodeLZW(src []byte, max int) ([]byte, error) {
+	var (
+		dst      []byte
+		prefix   [4096]uint16
+		suffix   [4096]byte
+		length   [4096]uint16
+		next     = 258
+		width    = uint(9)
+		previous = -1
+		bits     uint32
+		n        uint
+	)
+	for i := 0; i < 256; i++ {
+		suffix[i], length[i] = byte(i), 1
+	}
+
+	// entry appends the string of code to dst.
+	entry := func(code int) {
+		start := len(dst)
+		for i := 0; i < int(length[code]); i++ {
+			dst = append(dst, 0)
+		}
+		for i := len(dst) - 1; i >= start; i-- {
+			dst[i] = suffix[code]
+			code = int(prefix[code])
+		}
+	}
+
+	for _, b := range src {
+		bits = bits<<8 | uint32(b)
+		n += 8
+		for n >= width {
+			code := int(bits >> (n - width) & (1<<width - 1))
+			n -= width
+
+			switch {
+			case code == lzwClear:
+				next, width, previous = 258, 9, -1
+				continue
+			case code == lzwEOI:
+				return dst, nil
+			case previous < 0:
+				if code > 255 {
+					return nil, FormatError("invalid LZW code")
+				}
+				if len(dst) >= max {
+					return nil, FormatError("strip or tile too large")
+				}
+				entry(code)
+				previous = code
+				continue
+			case code > next || code == next && next >= 4096:
+				return nil, FormatError("invalid LZW code")
+			}
+
+			size := int(length[previous]) + 1
+			if code < next {
+				size = int(length[code])
+			}
+			if len(dst)+size > max {
+				return nil, FormatError("strip or tile too large")
+			}
+			start := len(dst)
+			if code < next {
+				entry(code)
+			} else {
+				// The code being defined: the previous string followed by
+				// its own first byte.
+				entry(previous)
+				dst = append(dst, dst[start])
+			}
+			if next < 4096 {
+				prefix[next] = uint16(previous)
+				suffix[next] = dst[start]
+				length[next] = length[previous] + 1
+				next++
+			}
+			previous = code
+
+			if next+1 >= 1<<width && width < 12 {
+				width++
+			}
+		}
+	}
+	return dst, nil
+}
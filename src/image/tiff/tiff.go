@@ -0,0 +1,486 @@
+// Package tiff decodes baseline TIFF images: bilevel, grayscale, palette
+// and RGB(A) images of 1 to 16 bits per sample, stored in strips or tiles,
+// uncompressed or compressed with PackBits, LZW or Deflate. Importing it
+// registers the format with the image package.
+package tiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+// A FormatError reports that the input is not a valid TIFF.
+type FormatError string
+
+func (e FormatError) Error() string { return "tiff: invalid format: " + string(e) }
+
+// An UnsupportedError reports that the input uses a valid but unimplemented
+// TIFF feature.
+type UnsupportedError string
+
+func (e UnsupportedError) Error() string { return "tiff: unsupported feature: " + string(e) }
+
+// Tags of the baseline TIFF and its common extensions.
+const (
+	tagImageWidth                = 256
+	tagImageLength               = 257
+	tagBitsPerSample             = 258
+	tagCompression               = 259
+	tagPhotometricInterpretation = 262
+	tagStripOffsets              = 273
+	tagSamplesPerPixel           = 277
+	tagRowsPerStrip              = 278
+	tagStripByteCounts           = 279
+	tagPlanarConfiguration       = 284
+	tagPredictor                 = 317
+	tagColorMap                  = 320
+	tagTileWidth                 = 322
+	tagTileLength                = 323
+	tagTileOffsets               = 324
+	tagTileByteCounts            = 325
+	tagExtraSamples              = 338
+	tagSampleFormat              = 339
+)
+
+// Field types.
+const (
+	typeByte  = 1
+	typeShort = 3
+	typeLong  = 4
+)
+
+var typeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// Compression schemes.
+const (
+	compressionNone        = 1
+	compressionLZW         = 5
+	compressionDeflate     = 8
+	compressionPackBits    = 32773
+	compressionDeflateOld  = 32946
+	photometricWhiteIsZero = 0
+	photometricBlackIsZero = 1
+	photometricRGB         = 2
+	photometricPalette     = 3
+)
+
+// decoder holds the fields of the first image file directory.
+type decoder struct {
+	data    []byte
+	order   binary.ByteOrder
+	fields  map[uint16][]uint32
+	width   int
+	height  int
+	bps     int // bits per sample
+	spp     int // samples per pixel
+	alpha   uint32
+	palette color.Palette
+}
+
+func init() {
+	image.RegisterFormat("tiff", "II*\x00", Decode, DecodeConfig)
+	image.RegisterFormat("tiff", "MM\x00*", Decode, DecodeConfig)
+}
+
+// DecodeConfig returns the color model and dimensions of a TIFF image
+// without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	d, err := newDecoder(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: d.colorModel(), Width: d.width, Height: d.height}, nil
+}
+
+// Decode reads the first image of a TIFF file from r.
+func Decode(r io.Reader) (image.Image, error) {
+	d, err := newDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.decode()
+}
+
+func newDecoder(r io.Reader) (*decoder, error) {
+	// Offsets point anywhere in the file, so it is read whole.
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, FormatError("truncated header")
+	}
+
+	d := &decoder{data: data, fields: make(map[uint16][]uint32)}
+	switch string(data[:4]) {
+	case "II*\x00":
+		d.order = binary.LittleEndian
+	case "MM\x00*":
+		d.order = binary.BigEndian
+	default:
+		return nil, FormatError("not a TIFF file")
+	}
+
+	ifd := int64(d.order.Uint32(data[4:]))
+	if ifd+2 > int64(len(data)) {
+		return nil, FormatError("directory offset beyond end of file")
+	}
+	entries := int64(d.order.Uint16(data[ifd:]))
+	if ifd+2+12*entries > int64(len(data)) {
+		return nil, FormatError("truncated directory")
+	}
+	for i := int64(0); i < entries; i++ {
+		if err := d.readField(data[ifd+2+12*i:]); err != nil {
+			return nil, err
+		}
+	}
+
+	d.width = int(d.first(tagImageWidth, 0))
+	d.height = int(d.first(tagImageLength, 0))
+	if d.width <= 0 || d.height <= 0 {
+		return nil, FormatError("invalid dimensions")
+	}
+	if d.width > 1<<16 || d.height > 1<<16 || d.width*d.height > 1<<28 {
+		return nil, UnsupportedError("image too large")
+	}
+
+	d.spp = int(d.first(tagSamplesPerPixel, 1))
+	d.bps = int(d.first(tagBitsPerSample, 1))
+	for _, bps := range d.fields[tagBitsPerSample] {
+		if int(bps) != d.bps {
+			return nil, UnsupportedError("mixed bits per sample")
+		}
+	}
+	switch d.bps {
+	case 1, 2, 4, 8, 16:
+	default:
+		return nil, UnsupportedError("bits per sample")
+	}
+	if d.first(tagPlanarConfiguration, 1) != 1 {
+		return nil, UnsupportedError("planar configuration")
+	}
+	if d.first(tagSampleFormat, 1) != 1 {
+		return nil, UnsupportedError("sample format")
+	}
+	if extra := d.fields[tagExtraSamples]; len(extra) > 0 {
+		d.alpha = extra[0]
+	}
+
+	switch d.first(tagPhotometricInterpretation, photometricBlackIsZero) {
+	case photometricWhiteIsZero, photometricBlackIsZero:
+		if d.spp < 1 || d.spp > 2 {
+			return nil, UnsupportedError("gray samples per pixel")
+		}
+	case photometricRGB:
+		if d.bps < 8 || d.spp < 3 || d.spp > 4 {
+			return nil, UnsupportedError("RGB samples")
+		}
+	case photometricPalette:
+		if d.spp != 1 || d.bps > 8 {
+			return nil, UnsupportedError("palette samples")
+		}
+		colorMap := d.fields[tagColorMap]
+		colors := 1 << uint(d.bps)
+		if len(colorMap) != 3*colors {
+			return nil, FormatError("color map size")
+		}
+		d.palette = make(color.Palette, colors)
+		for i := range d.palette {
+			d.palette[i] = color.RGBA64{uint16(colorMap[i]), uint16(colorMap[colors+i]), uint16(colorMap[2*colors+i]), 0xffff}
+		}
+	default:
+		return nil, UnsupportedError("photometric interpretation")
+	}
+	return d, nil
+}
+
+// readField reads a directory entry. Fields of types other than bytes,
+// shorts and longs are skipped, as are values beyond the end of the file.
+func (d *decoder) readField(entry []byte) error {
+	tag := d.order.Uint16(entry)
+	typ := d.order.Uint16(entry[2:])
+	count := d.order.Uint32(entry[4:])
+
+	size, ok := typeSizes[typ]
+	if !ok || typ != typeByte && typ != typeShort && typ != typeLong {
+		return nil
+	}
+	if count > uint32(len(d.data)) {
+		return FormatError("field count")
+	}
+	value := entry[8:12]
+	if size*count > 4 {
+		offset := d.order.Uint32(value)
+		if uint64(offset)+uint64(size*count) > uint64(len(d.data)) {
+			return FormatError("field beyond end of file")
+		}
+		value = d.data[offset : offset+size*count]
+	}
+
+	values := make([]uint32, count)
+	for i := range values {
+		switch typ {
+		case typeByte:
+			values[i] = uint32(value[i])
+		case typeShort:
+			values[i] = uint32(d.order.Uint16(value[2*i:]))
+		case typeLong:
+			values[i] = d.order.Uint32(value[4*i:])
+		}
+	}
+	d.fields[tag] = values
+	return nil
+}
+
+// first returns the first value of a field, or def when it is absent.
+func (d *decoder) first(tag uint16, def uint32) uint32 {
+	if values := d.fields[tag]; len(values) > 0 {
+		return values[0]
+	}
+	return def
+}
+
+func (d *decoder) colorModel() color.Model {
+	photometric := d.first(tagPhotometricInterpretation, photometricBlackIsZero)
+	switch {
+	case d.palette != nil:
+		return d.palette
+	case photometric == photometricRGB && d.spp == 3, d.spp > 1 && d.alpha == 1:
+		// Opaque or with associated, that is premultiplied, alpha.
+		if d.bps == 16 {
+			return color.RGBA64Model
+		}
+		return color.RGBAModel
+	case d.spp > 1:
+		if d.bps == 16 {
+			return color.NRGBA64Model
+		}
+		return color.NRGBAModel
+	case d.bps == 16:
+		return color.Gray16Model
+	}
+	return color.GrayModel
+}
+
+// newImage returns the image the samples are decoded into.
+func (d *decoder) newImage() image.Image {
+	r := image.Rect(0, 0, d.width, d.height)
+	switch d.colorModel() {
+	case color.RGBA64Model:
+		return image.NewRGBA64(r)
+	case color.RGBAModel:
+		return image.NewRGBA(r)
+	case color.NRGBA64Model:
+		return image.NewNRGBA64(r)
+	case color.NRGBAModel:
+		return image.NewNRGBA(r)
+	case color.Gray16Model:
+		return image.NewGray16(r)
+	case color.GrayModel:
+		return image.NewGray(r)
+	}
+	return image.NewPaletted(r, d.palette)
+}
+
+func (d *decoder) decode() (image.Image, error) {
+	img := d.newImage()
+
+	blockWidth, blockHeight := d.width, int(d.first(tagRowsPerStrip, uint32(d.height)))
+	offsets, counts := d.fields[tagStripOffsets], d.fields[tagStripByteCounts]
+	if _, tiled := d.fields[tagTileWidth]; tiled {
+		blockWidth, blockHeight = int(d.first(tagTileWidth, 0)), int(d.first(tagTileLength, 0))
+		offsets, counts = d.fields[tagTileOffsets], d.fields[tagTileByteCounts]
+	}
+	if blockWidth <= 0 || blockHeight <= 0 {
+		return nil, FormatError("invalid strip or tile size")
+	}
+	if blockHeight > d.height {
+		blockHeight = d.height
+	}
+
+	across := (d.width + blockWidth - 1) / blockWidth
+	down := (d.height + blockHeight - 1) / blockHeight
+	if len(offsets) < across*down || len(counts) < across*down {
+		return nil, FormatError("missing strips or tiles")
+	}
+
+	rowBytes := (blockWidth*d.spp*d.bps + 7) / 8
+	for i := 0; i < across*down; i++ {
+		offset, count := uint64(offsets[i]), uint64(counts[i])
+		if offset+count > uint64(len(d.data)) {
+			return nil, FormatError("strip or tile beyond end of file")
+		}
+		block, err := d.decompress(d.data[offset:offset+count], rowBytes*blockHeight)
+		if err != nil {
+			return nil, err
+		}
+
+		x0, y0 := i%across*blockWidth, i/across*blockHeight
+		for row := 0; row < blockHeight && y0+row < d.height; row++ {
+			if len(block) < (row+1)*rowBytes {
+				return nil, FormatError("truncated strip or tile")
+			}
+			samples := block[row*rowBytes : (row+1)*rowBytes]
+			d.unpredict(samples)
+			d.setRow(img, x0, y0+row, blockWidth, samples)
+		}
+	}
+	return img, nil
+}
+
+// decompress decompresses a strip or tile, which holds at most want bytes
+// once decompressed. Blocks that expand beyond that are rejected rather
+// than decompressed in full.
+func (d *decoder) decompress(block []byte, want int) ([]byte, error) {
+	switch d.first(tagCompression, compressionNone) {
+	case compressionNone:
+		return block, nil
+	case compressionPackBits:
+		return decodePackBits(block, want)
+	case compressionLZW:
+		return decodeLZW(block, want)
+	case compressionDeflate, compressionDeflateOld:
+		r, err := zlib.NewReader(bytes.NewReader(block))
+		if err != nil {
+			return nil, FormatError("deflate: " + err.Error())
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(r, int64(want)+1))
+		if err != nil {
+			return nil, FormatError("deflate: " + err.Error())
+		}
+		if len(data) > want {
+			return nil, FormatError("strip or tile too large")
+		}
+		return data, nil
+	}
+	return nil, UnsupportedError("compression")
+}
+
+// decodePackBits decompresses the byte-oriented run-length encoding of
+// Apple's PackBits into at most max bytes.
+func decodePackBits(src []byte, max int) ([]byte, error) {
+	var dst []byte
+	for i := 0; i < len(src); {
+		n := int(int8(src[i]))
+		i++
+		switch {
+		case n >= 0:
+			if i+n+1 > len(src) {
+				return nil, FormatError("truncated PackBits literal")
+			}
+			if len(dst)+n+1 > max {
+				return nil, FormatError("strip or tile too large")
+			}
+			dst = append(dst, src[i:i+n+1]...)
+			i += n + 1
+		case n != -128:
+			if i >= len(src) {
+				return nil, FormatError("truncated PackBits run")
+			}
+			if len(dst)+1-n > max {
+				return nil, FormatError("strip or tile too large")
+			}
+			for j := 0; j < 1-n; j++ {
+				dst = append(dst, src[i])
+			}
+			i++
+		}
+	}
+	return dst, nil
+}
+
+// unpredict undoes horizontal differencing of a row of 8 or 16-bit
+// samples.
+func (d *decoder) unpredict(row []byte) {
+	if d.first(tagPredictor, 1) != 2 {
+		return
+	}
+	switch d.bps {
+	case 8:
+		for i := d.spp; i < len(row); i++ {
+			row[i] += row[i-d.spp]
+		}
+	case 16:
+		for i := 2 * d.spp; i+1 < len(row); i += 2 {
+			v := d.order.Uint16(row[i:]) + d.order.Uint16(row[i-2*d.spp:])
+			d.order.PutUint16(row[i:], v)
+		}
+	}
+}
+
+// sample returns sample i of a row, scaled to 16 bits.
+func (d *decoder) sample(row []byte, i int) uint16 {
+	switch d.bps {
+	case 16:
+		return d.order.Uint16(row[2*i:])
+	case 8:
+		return uint16(row[i]) * 0x101
+	}
+	bit := i * d.bps
+	v := row[bit/8] >> uint(8-d.bps-bit%8) & (1<<uint(d.bps) - 1)
+	return uint16(uint32(v) * 0xffff / (1<<uint(d.bps) - 1))
+}
+
+// setRow stores the samples of width pixels starting at (x0, y).
+func (d *decoder) setRow(img image.Image, x0, y, width int, row []byte) {
+	if x0+width > d.width {
+		width = d.width - x0
+	}
+	whiteIsZero := d.first(tagPhotometricInterpretation, photometricBlackIsZero) == photometricWhiteIsZero
+
+	for i := 0; i < width; i++ {
+		x, s := x0+i, i*d.spp
+		switch m := img.(type) {
+		case *image.Paletted:
+			bit := i * d.bps
+			m.SetColorIndex(x, y, row[bit/8]>>uint(8-d.bps-bit%8)&(1<<uint(d.bps)-1))
+		case *image.Gray16:
+			v := d.sample(row, s)
+			if whiteIsZero {
+				v = 0xffff - v
+			}
+			m.SetGray16(x, y, color.Gray16{v})
+		case *image.Gray:
+			v := d.sample(row, s)
+			if whiteIsZero {
+				v = 0xffff - v
+			}
+			m.SetGray(x, y, color.Gray{uint8(v >> 8)})
+		default:
+			var c [4]uint16
+			if d.spp == 2 {
+				gray := d.sample(row, s)
+				if whiteIsZero {
+					gray = 0xffff - gray
+				}
+				c = [4]uint16{gray, gray, gray, d.sample(row, s+1)}
+			} else {
+				c = [4]uint16{d.sample(row, s), d.sample(row, s+1), d.sample(row, s+2), 0xffff}
+				if d.spp == 4 {
+					c[3] = d.sample(row, s+3)
+				}
+			}
+			setRGBA64(img, x, y, c)
+		}
+	}
+}
+
+// setRGBA64 stores a pixel whose samples are premultiplied or not
+// according to the type of img.
+func setRGBA64(img image.Image, x, y int, c [4]uint16) {
+	switch m := img.(type) {
+	case *image.RGBA64:
+		m.SetRGBA64(x, y, color.RGBA64{c[0], c[1], c[2], c[3]})
+	case *image.RGBA:
+		m.SetRGBA(x, y, color.RGBA{uint8(c[0] >> 8), uint8(c[1] >> 8), uint8(c[2] >> 8), uint8(c[3] >> 8)})
+	case *image.NRGBA64:
+		m.SetNRGBA64(x, y, color.NRGBA64{c[0], c[1], c[2], c[3]})
+	case *image.NRGBA:
+		m.SetNRGBA(x, y, color.NRGBA{uint8(c[0] >> 8), uint8(c[1] >> 8), uint8(c[2] >> 8), uint8(c[3] >> 8)})
+	}
+}
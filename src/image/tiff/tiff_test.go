@@ -0,0 +1,457 @@
+package tiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// field is a directory entry of a fixture.
+type field struct {
+	typ    uint16
+	values []uint32
+}
+
+// tiffFile assembles a single image TIFF holding blocks, which are the
+// strips or tiles of the image. The offsets and byte counts of the blocks
+// are filled into offsetTag and countTag.
+func tiffFile(order binary.ByteOrder, fields map[uint16]field, offsetTag, countTag uint16, blocks [][]byte) []byte {
+	buffer := new(bytes.Buffer)
+	if order == binary.LittleEndian {
+		buffer.WriteString("II*\x00")
+	} else {
+		buffer.WriteString("MM\x00*")
+	}
+	binary.Write(buffer, order, uint32(0)) // directory offset, patched below
+
+	var offsets, counts []uint32
+	for _, block := range blocks {
+		offsets = append(offsets, uint32(buffer.Len()))
+		counts = append(counts, uint32(len(block)))
+		buffer.Write(block)
+		if buffer.Len()%2 == 1 {
+			buffer.WriteByte(0)
+		}
+	}
+	fields[offsetTag] = field{typeLong, offsets}
+	fields[countTag] = field{typeLong, counts}
+
+	tags := make([]int, 0, len(fields))
+	for tag := range fields {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+
+	ifd := buffer.Len()
+	extra := ifd + 2 + 12*len(tags) + 4
+	var values bytes.Buffer
+	binary.Write(buffer, order, uint16(len(tags)))
+	for _, tag := range tags {
+		f := fields[uint16(tag)]
+		data := new(bytes.Buffer)
+		for _, v := range f.values {
+			if f.typ == typeShort {
+				binary.Write(data, order, uint16(v))
+			} else {
+				binary.Write(data, order, v)
+			}
+		}
+
+		binary.Write(buffer, order, uint16(tag))
+		binary.Write(buffer, order, f.typ)
+		binary.Write(buffer, order, uint32(len(f.values)))
+		if data.Len() <= 4 {
+			buffer.Write(append(data.Bytes(), make([]byte, 4-data.Len())...))
+		} else {
+			binary.Write(buffer, order, uint32(extra+values.Len()))
+			values.Write(data.Bytes())
+		}
+	}
+	binary.Write(buffer, order, uint32(0)) // no further directories
+	buffer.Write(values.Bytes())
+
+	data := buffer.Bytes()
+	order.PutUint32(data[4:], uint32(ifd))
+	return data
+}
+
+func short(values ...uint32) field { return field{typeShort, values} }
+
+// samples returns the rows of an image of the given size with channels
+// samples per pixel, as 8-bit samples derived from the position.
+func samples(width, height, channels int, y0, rows int) []byte {
+	var data []byte
+	for y := y0; y < y0+rows && y < height; y++ {
+		for x := 0; x < width; x++ {
+			for c := 0; c < channels; c++ {
+				data = append(data, uint8(40*x+60*y+90*c))
+			}
+		}
+	}
+	return data
+}
+
+func sample(x, y, c int) uint8 { return uint8(40*x + 60*y + 90*c) }
+
+// encodeLZW compresses data with TIFF LZW.
+func encodeLZW(data []byte) []byte {
+	var out []byte
+	var bits uint32
+	var n uint
+	width := uint(9)
+	emit := func(code int) {
+		bits = bits<<width | uint32(code)
+		n += width
+		for n >= 8 {
+			out = append(out, byte(bits>>(n-8)))
+			n -= 8
+		}
+	}
+
+	dict := make(map[string]int)
+	for i := 0; i < 256; i++ {
+		dict[string([]byte{byte(i)})] = i
+	}
+	next := 258
+	emit(lzwClear)
+	s := ""
+	for _, c := range data {
+		t := s + string([]byte{c})
+		if _, ok := dict[t]; ok {
+			s = t
+			continue
+		}
+		emit(dict[s])
+		dict[t] = next
+		next++
+		if next >= 1<<width {
+			width++
+		}
+		s = string([]byte{c})
+	}
+	emit(dict[s])
+	if next+1 >= 1<<width {
+		width++
+	}
+	emit(lzwEOI)
+	if n > 0 {
+		out = append(out, byte(bits<<(8-n)))
+	}
+	return out
+}
+
+func encodePackBits(data []byte) []byte {
+	var out []byte
+	for i := 0; i < len(data); {
+		run := 1
+		for i+run < len(data) && run < 128 && data[i+run] == data[i] {
+			run++
+		}
+		if run > 1 {
+			out = append(out, byte(1-run), data[i])
+			i += run
+			continue
+		}
+		n := 1
+		for i+n < len(data) && n < 128 && (i+n+1 >= len(data) || data[i+n] != data[i+n+1]) {
+			n++
+		}
+		out = append(out, byte(n-1))
+		out = append(out, data[i:i+n]...)
+		i += n
+	}
+	return out
+}
+
+func deflate(data []byte) []byte {
+	buffer := new(bytes.Buffer)
+	w := zlib.NewWriter(buffer)
+	w.Write(data)
+	w.Close()
+	return buffer.Bytes()
+}
+
+// predict applies horizontal differencing to rows of 8-bit samples.
+func predict(data []byte, width, channels int) []byte {
+	out := append([]byte(nil), data...)
+	stride := width * channels
+	for row := 0; row < len(out); row += stride {
+		for i := row + stride - 1; i >= row+channels; i-- {
+			out[i] -= out[i-channels]
+		}
+	}
+	return out
+}
+
+func TestDecodeStrips(t *testing.T) {
+	const width, height = 5, 3
+	strips := func(channels int, compress func([]byte) []byte) [][]byte {
+		return [][]byte{
+			compress(samples(width, height, channels, 0, 2)),
+			compress(samples(width, height, channels, 2, 2)),
+		}
+	}
+	none := func(data []byte) []byte { return data }
+	predicted := func(channels int) func([]byte) []byte {
+		return func(data []byte) []byte { return encodeLZW(predict(data, width, channels)) }
+	}
+	fields := func(photometric, channels, compression uint32, extra ...uint16) map[uint16]field {
+		f := map[uint16]field{
+			tagImageWidth:                short(width),
+			tagImageLength:               short(height),
+			tagCompression:               short(compression),
+			tagPhotometricInterpretation: short(photometric),
+			tagSamplesPerPixel:           short(channels),
+			tagRowsPerStrip:              short(2),
+		}
+		bps := field{typeShort, nil}
+		for i := uint32(0); i < channels; i++ {
+			bps.values = append(bps.values, 8)
+		}
+		f[tagBitsPerSample] = bps
+		for i := 0; i+1 < len(extra); i += 2 {
+			f[extra[i]] = short(uint32(extra[i+1]))
+		}
+		return f
+	}
+
+	tests := []struct {
+		name     string
+		order    binary.ByteOrder
+		fields   map[uint16]field
+		blocks   [][]byte
+		channels int
+	}{
+		{"gray", binary.LittleEndian, fields(photometricBlackIsZero, 1, compressionNone), strips(1, none), 1},
+		{"gray big endian", binary.BigEndian, fields(photometricBlackIsZero, 1, compressionNone), strips(1, none), 1},
+		{"rgb PackBits", binary.LittleEndian, fields(photometricRGB, 3, compressionPackBits), strips(3, encodePackBits), 3},
+		{"rgb LZW", binary.BigEndian, fields(photometricRGB, 3, compressionLZW), strips(3, encodeLZW), 3},
+		{"rgb LZW predictor", binary.LittleEndian, fields(photometricRGB, 3, compressionLZW, tagPredictor, 2), strips(3, predicted(3)), 3},
+		{"rgba deflate", binary.LittleEndian, fields(photometricRGB, 4, compressionDeflate, tagExtraSamples, 2), strips(4, deflate), 4},
+	}
+
+	for _, test := range tests {
+		data := tiffFile(test.order, test.fields, tagStripOffsets, tagStripByteCounts, test.blocks)
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil || format != "tiff" {
+			t.Errorf("%s: image.Decode = %q, %v", test.name, format, err)
+			continue
+		}
+		if img.Bounds() != image.Rect(0, 0, width, height) {
+			t.Errorf("%s: bounds = %v", test.name, img.Bounds())
+			continue
+		}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				var want color.Color
+				switch test.channels {
+				case 1:
+					want = color.Gray{sample(x, y, 0)}
+				case 3:
+					want = color.RGBA{sample(x, y, 0), sample(x, y, 1), sample(x, y, 2), 255}
+				case 4:
+					want = color.NRGBA{sample(x, y, 0), sample(x, y, 1), sample(x, y, 2), sample(x, y, 3)}
+				}
+				if got := img.At(x, y); got != want {
+					t.Errorf("%s: pixel (%d, %d) = %v, want %v", test.name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeTiles(t *testing.T) {
+	const width, height, tile = 20, 18, 16
+	var tiles [][]byte
+	for ty := 0; ty < 2; ty++ {
+		for tx := 0; tx < 2; tx++ {
+			var data []byte
+			for y := ty * tile; y < (ty+1)*tile; y++ {
+				for x := tx * tile; x < (tx+1)*tile; x++ {
+					for c := 0; c < 3; c++ {
+						data = append(data, sample(x, y, c))
+					}
+				}
+			}
+			tiles = append(tiles, deflate(data))
+		}
+	}
+	fields := map[uint16]field{
+		tagImageWidth:                short(width),
+		tagImageLength:               short(height),
+		tagBitsPerSample:             short(8, 8, 8),
+		tagCompression:               short(compressionDeflate),
+		tagPhotometricInterpretation: short(photometricRGB),
+		tagSamplesPerPixel:           short(3),
+		tagTileWidth:                 short(tile),
+		tagTileLength:                short(tile),
+	}
+
+	img, err := Decode(bytes.NewReader(tiffFile(binary.LittleEndian, fields, tagTileOffsets, tagTileByteCounts, tiles)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []image.Point{{0, 0}, {15, 15}, {16, 0}, {19, 17}, {3, 16}} {
+		want := color.RGBA{sample(p.X, p.Y, 0), sample(p.X, p.Y, 1), sample(p.X, p.Y, 2), 255}
+		if got := img.At(p.X, p.Y); got != want {
+			t.Errorf("pixel %v = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestDecodeLowBitDepths(t *testing.T) {
+	// A 4-bit palette image of red, green and blue.
+	colorMap := make([]uint32, 3*16)
+	colorMap[0], colorMap[16+1], colorMap[32+2] = 0xffff, 0xffff, 0xffff
+	fields := map[uint16]field{
+		tagImageWidth:                short(3),
+		tagImageLength:               short(1),
+		tagBitsPerSample:             short(4),
+		tagPhotometricInterpretation: short(photometricPalette),
+		tagColorMap:                  short(colorMap...),
+	}
+	img, err := Decode(bytes.NewReader(tiffFile(binary.BigEndian, fields, tagStripOffsets, tagStripByteCounts, [][]byte{{0x01, 0x20}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}} {
+		if got := color.RGBAModel.Convert(img.At(x, 0)); got != want {
+			t.Errorf("palette pixel %d = %v, want %v", x, got, want)
+		}
+	}
+
+	// A bilevel image where white is zero.
+	fields = map[uint16]field{
+		tagImageWidth:                short(10),
+		tagImageLength:               short(1),
+		tagPhotometricInterpretation: short(photometricWhiteIsZero),
+	}
+	img, err = Decode(bytes.NewReader(tiffFile(binary.LittleEndian, fields, tagStripOffsets, tagStripByteCounts, [][]byte{{0xa0, 0x40}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range []uint8{0, 255, 0, 255, 255, 255, 255, 255, 255, 0} {
+		if got := img.At(x, 0); got != (color.Gray{want}) {
+			t.Errorf("bilevel pixel %d = %v, want %d", x, got, want)
+		}
+	}
+}
+
+func TestDecodeLZWCodeWidths(t *testing.T) {
+	// Enough distinct strings to grow the codes past 9 and 10 bits.
+	data := make([]byte, 6000)
+	seed := uint32(1)
+	for i := range data {
+		seed = seed*1103515245 + 12345
+		data[i] = byte(seed>>16) & 0x8f
+	}
+	got, err := decodeLZW(encodeLZW(data), len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("LZW round trip differs")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	fields := func() map[uint16]field {
+		return map[uint16]field{
+			tagImageWidth:    short(4),
+			tagImageLength:   short(4),
+			tagBitsPerSample: short(8),
+		}
+	}
+	valid := tiffFile(binary.LittleEndian, fields(), tagStripOffsets, tagStripByteCounts, [][]byte{make([]byte, 16)})
+	if _, err := Decode(bytes.NewReader(valid)); err != nil {
+		t.Fatalf("valid fixture: %v", err)
+	}
+
+	unsupported := fields()
+	unsupported[tagCompression] = short(7) // JPEG
+	truncated := fields()
+	truncated[tagBitsPerSample] = short(16)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"signature", append([]byte("XX"), valid[2:]...)},
+		{"truncated header", valid[:6]},
+		{"truncated directory", valid[:len(valid)-10]},
+		{"compression", tiffFile(binary.LittleEndian, unsupported, tagStripOffsets, tagStripByteCounts, [][]byte{make([]byte, 16)})},
+		{"truncated strip", tiffFile(binary.LittleEndian, truncated, tagStripOffsets, tagStripByteCounts, [][]byte{make([]byte, 16)})},
+	}
+	for _, test := range tests {
+		if _, err := Decode(bytes.NewReader(test.data)); err == nil {
+			t.Errorf("%s: Decode succeeded", test.name)
+		}
+	}
+
+	// Strips expanding far beyond the 16 bytes of the image are rejected
+	// before they are decompressed in full.
+	bomb := make([]byte, 1<<20)
+	for compression, strip := range map[uint32][]byte{
+		compressionPackBits: encodePackBits(bomb),
+		compressionLZW:      encodeLZW(bomb),
+		compressionDeflate:  deflate(bomb),
+	} {
+		f := fields()
+		f[tagCompression] = short(compression)
+		_, err := Decode(bytes.NewReader(tiffFile(binary.LittleEndian, f, tagStripOffsets, tagStripByteCounts, [][]byte{strip})))
+		if _, ok := err.(FormatError); !ok {
+			t.Errorf("compression %d bomb: err = %v, want a FormatError", compression, err)
+		}
+	}
+}
+
+// load decodes a fixture written by other tools.
+func load(t *testing.T, name string) image.Image {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return img
+}
+
+func TestDecodeFixtures(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"blue-purple-pink.lzwcompressed.tiff", "blue-purple-pink.png"},
+		{"video-001-tile-64x64.tiff", "video-001.png"},
+		{"bw-packbits.tiff", "bw-uncompressed.tiff"},
+		{"bw-deflate.tiff", "bw-uncompressed.tiff"},
+	}
+	for _, test := range tests {
+		got, want := load(t, test.name), load(t, test.want)
+		if got.Bounds() != want.Bounds() {
+			t.Errorf("%s: bounds = %v, want %v", test.name, got.Bounds(), want.Bounds())
+			continue
+		}
+		b := got.Bounds()
+	pixels:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r0, g0, b0, a0 := got.At(x, y).RGBA()
+				r1, g1, b1, a1 := want.At(x, y).RGBA()
+				if r0 != r1 || g0 != g1 || b0 != b1 || a0 != a1 {
+					t.Errorf("%s: pixel (%d, %d) = %v, want %v", test.name, x, y, got.At(x, y), want.At(x, y))
+					break pixels
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,535 @@
+package webp
+
+import (
+	"image"
+)
+
+// bitReader reads the VP8L bit stream, least significant bit first. Reads
+// past the end return zero bits and record an error.
+type bitReader struct {
+	data []byte
+	pos  int
+	bits uint64
+	n    uint
+	err  error
+}
+
+func (br *bitReader) read(n uint) uint32 {
+	for br.n < n {
+		if br.pos >= len(br.data) {
+			br.fail(FormatError("truncated bit stream"))
+			return 0
+		}
+		br.bits |= uint64(br.data[br.pos]) << br.n
+		br.pos++
+		br.n += 8
+	}
+	v := uint32(br.bits & (1<<n - 1))
+	br.bits >>= n
+	br.n -= n
+	return v
+}
+
+func (br *bitReader) fail(err error) {
+	if br.err == nil {
+		br.err = err
+	}
+}
+
+// Transform types.
+const (
+	transformPredictor     = 0
+	transformColor         = 1
+	transformSubtractGreen = 2
+	transformColorIndexing = 3
+)
+
+// Prefix code groups hold five codes: green with lengths and color cache
+// indexes, red, blue, alpha and distance.
+const (
+	numLiterals     = 256
+	numLengthCodes  = 24
+	numDistanceCode = 40
+)
+
+// codeLengthOrder is the order in which the code lengths of the code
+// length code are sent.
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// distanceMap maps the 120 shortest distance codes to (dx, dy) offsets in
+// the image plane.
+var distanceMap = [120][2]int8{
+	{0, 1}, {1, 0}, {1, 1}, {-1, 1}, {0, 2}, {2, 0}, {1, 2},
+	{-1, 2}, {2, 1}, {-2, 1}, {2, 2}, {-2, 2}, {0, 3}, {3, 0},
+	{1, 3}, {-1, 3}, {3, 1}, {-3, 1}, {2, 3}, {-2, 3}, {3, 2},
+	{-3, 2}, {0, 4}, {4, 0}, {1, 4}, {-1, 4}, {4, 1}, {-4, 1},
+	{3, 3}, {-3, 3}, {2, 4}, {-2, 4}, {4, 2}, {-4, 2}, {0, 5},
+	{3, 4}, {-3, 4}, {4, 3}, {-4, 3}, {5, 0}, {1, 5}, {-1, 5},
+	{5, 1}, {-5, 1}, {2, 5}, {-2, 5}, {5, 2}, {-5, 2}, {4, 4},
+	{-4, 4}, {3, 5}, {-3, 5}, {5, 3}, {-5, 3}, {0, 6}, {6, 0},
+	{1, 6}, {-1, 6}, {6, 1}, {-6, 1}, {2, 6}, {-2, 6}, {6, 2},
+	{-6, 2}, {4, 5}, {-4, 5}, {5, 4}, {-5, 4}, {3, 6}, {-3, 6},
+	{6, 3}, {-6, 3}, {0, 7}, {7, 0}, {1, 7}, {-1, 7}, {5, 5},
+	{-5, 5}, {7, 1}, {-7, 1}, {4, 6}, {-4, 6}, {6, 4}, {-6, 4},
+	{2, 7}, {-2, 7}, {7, 2}, {-7, 2}, {3, 7}, {-3, 7}, {7, 3},
+	{-7, 3}, {5, 6}, {-5, 6}, {6, 5}, {-6, 5}, {8, 0}, {4, 7},
+	{-4, 7}, {7, 4}, {-7, 4}, {8, 1}, {8, 2}, {6, 6}, {-6, 6},
+	{8, 3}, {5, 7}, {-5, 7}, {7, 5}, {-7, 5}, {8, 4}, {6, 7},
+	{-6, 7}, {7, 6}, {-7, 6}, {8, 5}, {7, 7}, {-7, 7}, {8, 6},
+	{8, 7},
+}
+
+// transform is a transform read from the bit stream, applied in reverse
+// after the image is decoded.
+type transform struct {
+	kind   int
+	xsize  int // width of the image the transform applies to
+	bits   int // block size bits, or pixel packing bits for color indexing
+	data   []uint32
+	colors []uint32
+}
+
+func readHeader(br *bitReader) (width, height int, alpha bool, err error) {
+	if br.read(8) != 0x2f {
+		return 0, 0, false, FormatError("bad VP8L signature")
+	}
+	width = int(br.read(14)) + 1
+	height = int(br.read(14)) + 1
+	alpha = br.read(1) == 1
+	if br.read(3) != 0 {
+		return 0, 0, false, UnsupportedError("VP8L version")
+	}
+	return width, height, alpha, br.err
+}
+
+func decodeVP8L(data []byte) (image.Image, error) {
+	br := &bitReader{data: data}
+	width, height, _, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var transforms []transform
+	var seen [4]bool
+	xsize := width
+	for br.err == nil && br.read(1) == 1 {
+		t := transform{kind: int(br.read(2)), xsize: xsize}
+		if seen[t.kind] {
+			return nil, FormatError("repeated transform")
+		}
+		seen[t.kind] = true
+
+		switch t.kind {
+		case transformPredictor, transformColor:
+			t.bits = int(br.read(3)) + 2
+			t.data = decodeImageStream(br, subSampleSize(xsize, t.bits), subSampleSize(height, t.bits), false)
+		case transformColorIndexing:
+			size := int(br.read(8)) + 1
+			t.colors = decodeImageStream(br, size, 1, false)
+			for i := 1; i < len(t.colors); i++ {
+				t.colors[i] = addPixels(t.colors[i], t.colors[i-1])
+			}
+			switch {
+			case size <= 2:
+				t.bits = 3
+			case size <= 4:
+				t.bits = 2
+			case size <= 16:
+				t.bits = 1
+			}
+			xsize = subSampleSize(xsize, t.bits)
+		}
+		transforms = append(transforms, t)
+	}
+
+	pixels := decodeImageStream(br, xsize, height, true)
+	if br.err != nil {
+		return nil, br.err
+	}
+
+	for i := len(transforms) - 1; i >= 0; i-- {
+		pixels = transforms[i].inverse(pixels, height)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, p := range pixels {
+		img.Pix[4*i+0] = uint8(p >> 16)
+		img.Pix[4*i+1] = uint8(p >> 8)
+		img.Pix[4*i+2] = uint8(p)
+		img.Pix[4*i+3] = uint8(p >> 24)
+	}
+	return img, nil
+}
+
+func subSampleSize(size, bits int) int {
+	return (size + 1<<uint(bits) - 1) >> uint(bits)
+}
+
+// decodeImageStream decodes an entropy coded image. Only the main image
+// may use meta prefix codes.
+func decodeImageStream(br *bitReader, xsize, ysize int, main bool) []uint32 {
+	cacheBits := 0
+	if br.read(1) == 1 {
+		cacheBits = int(br.read(4))
+		if cacheBits < 1 || cacheBits > 11 {
+			br.fail(FormatError("color cache size"))
+			return nil
+		}
+	}
+
+	prefixBits, prefixWidth := 0, 0
+	var prefixImage []uint32
+	groups := 1
+	if main && br.read(1) == 1 {
+		prefixBits = int(br.read(3)) + 2
+		prefixWidth = subSampleSize(xsize, prefixBits)
+		prefixImage = decodeImageStream(br, prefixWidth, subSampleSize(ysize, prefixBits), false)
+		for i, p := range prefixImage {
+			prefixImage[i] = p >> 8 & 0xffff
+			if int(prefixImage[i]) >= groups {
+				groups = int(prefixImage[i]) + 1
+			}
+		}
+	}
+
+	cacheSize := 0
+	if cacheBits > 0 {
+		cacheSize = 1 << uint(cacheBits)
+	}
+	alphabets := [5]int{numLiterals + numLengthCodes + cacheSize, numLiterals, numLiterals, numLiterals, numDistanceCode}
+	codes := make([][5]*huffman, groups)
+	for g := range codes {
+		for i, size := range alphabets {
+			codes[g][i] = readCode(br, size)
+			if br.err != nil {
+				return nil
+			}
+		}
+	}
+
+	var cache []uint32
+	if cacheSize > 0 {
+		cache = make([]uint32, cacheSize)
+	}
+	insert := func(p uint32) {
+		if cache != nil {
+			cache[(0x1e35a7bd*p)>>uint(32-cacheBits)] = p
+		}
+	}
+
+	pixels := make([]uint32, xsize*ysize)
+	for pos := 0; pos < len(pixels) && br.err == nil; {
+		group := &codes[0]
+		if prefixImage != nil {
+			x, y := pos%xsize, pos/xsize
+			group = &codes[prefixImage[(y>>uint(prefixBits))*prefixWidth+x>>uint(prefixBits)]]
+		}
+
+		green := group[0].decode(br)
+		switch {
+		case green < numLiterals:
+			red := group[1].decode(br)
+			blue := group[2].decode(br)
+			alpha := group[3].decode(br)
+			pixels[pos] = uint32(alpha)<<24 | uint32(red)<<16 | uint32(green)<<8 | uint32(blue)
+			insert(pixels[pos])
+			pos++
+		case green < numLiterals+numLengthCodes:
+			length := readPrefixed(br, green-numLiterals)
+			code := readPrefixed(br, group[4].decode(br))
+			distance := code - 120
+			if code <= 120 {
+				d := distanceMap[code-1]
+				distance = int(d[0]) + int(d[1])*xsize
+				if distance < 1 {
+					distance = 1
+				}
+			}
+			if distance > pos || pos+length > len(pixels) {
+				br.fail(FormatError("invalid backward reference"))
+				break
+			}
+			for i := 0; i < length; i++ {
+				pixels[pos] = pixels[pos-distance]
+				insert(pixels[pos])
+				pos++
+			}
+		default:
+			index := green - numLiterals - numLengthCodes
+			if cache == nil || index >= len(cache) {
+				br.fail(FormatError("invalid color cache index"))
+				break
+			}
+			pixels[pos] = cache[index]
+			pos++
+		}
+	}
+	return pixels
+}
+
+// readPrefixed reads a length or distance sent as a prefix code followed
+// by extra bits.
+func readPrefixed(br *bitReader, prefix int) int {
+	if prefix < 4 {
+		return prefix + 1
+	}
+	extra := uint(prefix-2) >> 1
+	offset := (2 + prefix&1) << extra
+	return offset + int(br.read(extra)) + 1
+}
+
+// readCode reads a prefix code over an alphabet of size symbols.
+func readCode(br *bitReader, size int) *huffman {
+	lengths := make([]int, size)
+
+	if br.read(1) == 1 {
+		// A simple code of one or two symbols.
+		symbols := int(br.read(1)) + 1
+		first := int(br.read(1 + 7*uint(br.read(1))))
+		if first >= size {
+			br.fail(FormatError("simple code symbol"))
+			return nil
+		}
+		lengths[first] = 1
+		if symbols == 2 {
+			second := int(br.read(8))
+			if second >= size {
+				br.fail(FormatError("simple code symbol"))
+				return nil
+			}
+			lengths[second] = 1
+		}
+	} else {
+		var codeLengthLengths [19]int
+		n := int(br.read(4)) + 4
+		for i := 0; i < n; i++ {
+			codeLengthLengths[codeLengthOrder[i]] = int(br.read(3))
+		}
+		codeLengthCode, err := newHuffman(codeLengthLengths[:])
+		if err != nil {
+			br.fail(err)
+			return nil
+		}
+
+		maxSymbol := size
+		if br.read(1) == 1 {
+			bits := 2 + 2*uint(br.read(3))
+			maxSymbol = 2 + int(br.read(bits))
+			if maxSymbol > size {
+				br.fail(FormatError("code length count"))
+				return nil
+			}
+		}
+
+		previous := 8
+		for symbol := 0; symbol < size && br.err == nil; maxSymbol-- {
+			if maxSymbol == 0 {
+				break
+			}
+			length := codeLengthCode.decode(br)
+			if length < 16 {
+				lengths[symbol] = length
+				symbol++
+				if length != 0 {
+					previous = length
+				}
+				continue
+			}
+
+			repeat, value := 0, 0
+			switch length {
+			case 16:
+				repeat, value = 3+int(br.read(2)), previous
+			case 17:
+				repeat = 3 + int(br.read(3))
+			case 18:
+				repeat = 11 + int(br.read(7))
+			}
+			if symbol+repeat > size {
+				br.fail(FormatError("code length repeat"))
+				return nil
+			}
+			for ; repeat > 0; repeat-- {
+				lengths[symbol] = value
+				symbol++
+			}
+		}
+	}
+	if br.err != nil {
+		return nil
+	}
+
+	h, err := newHuffman(lengths)
+	if err != nil {
+		br.fail(err)
+		return nil
+	}
+	return h
+}
+
+// addPixels adds two pixels channel by channel, modulo 256.
+func addPixels(a, b uint32) uint32 {
+	ag := (a & 0xff00ff00) + (b & 0xff00ff00)
+	rb := (a & 0x00ff00ff) + (b & 0x00ff00ff)
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+// inverse undoes the transform on pixels of the given height.
+func (t *transform) inverse(pixels []uint32, height int) []uint32 {
+	switch t.kind {
+	case transformPredictor:
+		blocksPerRow := subSampleSize(t.xsize, t.bits)
+		for y := 0; y < height; y++ {
+			for x := 0; x < t.xsize; x++ {
+				i := y*t.xsize + x
+				var p uint32
+				switch {
+				case x == 0 && y == 0:
+					p = 0xff000000
+				case y == 0:
+					p = pixels[i-1]
+				case x == 0:
+					p = pixels[i-t.xsize]
+				default:
+					mode := t.data[(y>>uint(t.bits))*blocksPerRow+x>>uint(t.bits)] >> 8 & 0xf
+					p = predict(mode, pixels, i, t.xsize)
+				}
+				pixels[i] = addPixels(pixels[i], p)
+			}
+		}
+	case transformColor:
+		blocksPerRow := subSampleSize(t.xsize, t.bits)
+		for y := 0; y < height; y++ {
+			for x := 0; x < t.xsize; x++ {
+				i := y*t.xsize + x
+				m := t.data[(y>>uint(t.bits))*blocksPerRow+x>>uint(t.bits)]
+				greenToRed, greenToBlue, redToBlue := int8(m), int8(m>>8), int8(m>>16)
+				p := pixels[i]
+				green := int8(p >> 8)
+				red := uint8(p>>16) + uint8(colorTransformDelta(greenToRed, green))
+				blue := uint8(p) + uint8(colorTransformDelta(greenToBlue, green))
+				blue += uint8(colorTransformDelta(redToBlue, int8(red)))
+				pixels[i] = p&0xff00ff00 | uint32(red)<<16 | uint32(blue)
+			}
+		}
+	case transformSubtractGreen:
+		for i, p := range pixels {
+			green := p >> 8 & 0xff
+			pixels[i] = addPixels(p, green<<16|green)
+		}
+	case transformColorIndexing:
+		width := t.xsize
+		packedWidth := subSampleSize(width, t.bits)
+		bitsPerPixel := uint(8 >> uint(t.bits))
+		mask := uint32(1)<<bitsPerPixel - 1
+		out := make([]uint32, width*height)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				packed := pixels[y*packedWidth+x>>uint(t.bits)] >> 8 & 0xff
+				index := packed >> (uint(x&(1<<uint(t.bits)-1)) * bitsPerPixel) & mask
+				if int(index) < len(t.colors) {
+					out[y*width+x] = t.colors[index]
+				}
+			}
+		}
+		return out
+	}
+	return pixels
+}
+
+func colorTransformDelta(t, c int8) int8 {
+	return int8((int(t) * int(c)) >> 5)
+}
+
+// predict returns the prediction of pixel i of a row of xsize pixels
+// which is neither in the first row nor in the first column.
+func predict(mode uint32, pixels []uint32, i, xsize int) uint32 {
+	left, top := pixels[i-1], pixels[i-xsize]
+	topLeft, topRight := pixels[i-xsize-1], pixels[i-xsize+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return left
+	case 2:
+		return top
+	case 3:
+		return topRight
+	case 4:
+		return topLeft
+	case 5:
+		return average2(average2(left, topRight), top)
+	case 6:
+		return average2(left, topLeft)
+	case 7:
+		return average2(left, top)
+	case 8:
+		return average2(topLeft, top)
+	case 9:
+		return average2(top, topRight)
+	case 10:
+		return average2(average2(left, topLeft), average2(top, topRight))
+	case 11:
+		return selectPredictor(left, top, topLeft)
+	case 12:
+		return clampAddSubtractFull(left, top, topLeft)
+	case 13:
+		return clampAddSubtractHalf(average2(left, top), topLeft)
+	}
+	// Modes 14 and 15 are unused and predict like mode 0.
+	return 0xff000000
+}
+
+func channel(p uint32, shift uint) int {
+	return int(p >> shift & 0xff)
+}
+
+func average2(a, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+func selectPredictor(left, top, topLeft uint32) uint32 {
+	var distLeft, distTop int
+	for shift := uint(0); shift < 32; shift += 8 {
+		estimate := channel(left, shift) + channel(top, shift) - channel(topLeft, shift)
+		distLeft += abs(estimate - channel(left, shift))
+		distTop += abs(estimate - channel(top, shift))
+	}
+	if distLeft < distTop {
+		return left
+	}
+	return top
+}
+
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var p uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		p |= uint32(clamp(channel(a, shift)+channel(b, shift)-channel(c, shift))) << shift
+	}
+	return p
+}
+
+func clampAddSubtractHalf(a, b uint32) uint32 {
+	var p uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		p |= uint32(clamp(channel(a, shift)+(channel(a, shift)-channel(b, shift))/2)) << shift
+	}
+	return p
+}
+
+func clamp(v int) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
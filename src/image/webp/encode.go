@@ -0,0 +1,516 @@
+package webp
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math/bits"
+	"sort"
+)
+
+// Encoder settings.
+const (
+	predictorBits = 4  // predictor modes are chosen per 16x16 block
+	cacheBits     = 10 // color cache of the main image
+	hashBits      = 16 // hash table of the match finder
+	chainDepth    = 8  // candidates the match finder tries per pixel
+	maxLength     = 4096
+	maxDistance   = 1<<20 - 120
+)
+
+// bitWriter writes the VP8L bit stream, least significant bit first.
+type bitWriter struct {
+	buf  []byte
+	bits uint64
+	n    uint
+}
+
+func (bw *bitWriter) write(v uint32, n uint) {
+	bw.bits |= uint64(v&(1<<n-1)) << bw.n
+	bw.n += n
+	for bw.n >= 8 {
+		bw.buf = append(bw.buf, byte(bw.bits))
+		bw.bits >>= 8
+		bw.n -= 8
+	}
+}
+
+func (bw *bitWriter) bytes() []byte {
+	if bw.n > 0 {
+		bw.buf = append(bw.buf, byte(bw.bits))
+		bw.bits, bw.n = 0, 0
+	}
+	return bw.buf
+}
+
+// encodeVP8L returns the VP8L bit stream of img. Images of at most 256
+// colors are sent as palette indexes, other images with the subtract green
+// and predictor transforms. The pixels are then compressed with backward
+// references, a color cache and prefix codes.
+func encodeVP8L(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > maxDimension || height > maxDimension {
+		return nil, errors.New("webp: invalid image size")
+	}
+
+	pixels := argbPixels(img)
+	alpha := uint32(0)
+	for _, p := range pixels {
+		if p>>24 != 0xff {
+			alpha = 1
+			break
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	bw.write(alpha, 1)
+	bw.write(0, 3)
+
+	xsize := width
+	if palette := paletteOf(pixels, 256); palette != nil {
+		bw.write(1, 1)
+		bw.write(transformColorIndexing, 2)
+		bw.write(uint32(len(palette)-1), 8)
+		deltas := make([]uint32, len(palette))
+		deltas[0] = palette[0]
+		for i := 1; i < len(palette); i++ {
+			deltas[i] = subPixels(palette[i], palette[i-1])
+		}
+		writeImageStream(bw, deltas, len(palette), 1, false)
+		pixels, xsize = packIndexes(pixels, width, height, palette)
+	} else {
+		bw.write(1, 1)
+		bw.write(transformSubtractGreen, 2)
+		for i, p := range pixels {
+			green := p >> 8 & 0xff
+			pixels[i] = subPixels(p, green<<16|green)
+		}
+
+		bw.write(1, 1)
+		bw.write(transformPredictor, 2)
+		bw.write(predictorBits-2, 3)
+		modes, residuals := applyPredictors(pixels, width, height)
+		writeImageStream(bw, modes, subSampleSize(width, predictorBits), subSampleSize(height, predictorBits), false)
+		pixels = residuals
+	}
+	bw.write(0, 1)
+
+	writeImageStream(bw, pixels, xsize, height, true)
+	return bw.bytes(), nil
+}
+
+// argbPixels returns the non-premultiplied 8-bit pixels of img.
+func argbPixels(img image.Image) []uint32 {
+	bounds := img.Bounds()
+	pixels := make([]uint32, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			pixels = append(pixels, uint32(c.A)<<24|uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+		}
+	}
+	return pixels
+}
+
+// subPixels subtracts b from a channel by channel, modulo 256.
+func subPixels(a, b uint32) uint32 {
+	ag := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	rb := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+// paletteOf returns the sorted colors of pixels, or nil if there are more
+// than max.
+func paletteOf(pixels []uint32, max int) []uint32 {
+	seen := make(map[uint32]bool)
+	for _, p := range pixels {
+		if !seen[p] {
+			if len(seen) == max {
+				return nil
+			}
+			seen[p] = true
+		}
+	}
+	palette := make([]uint32, 0, len(seen))
+	for p := range seen {
+		palette = append(palette, p)
+	}
+	sort.Slice(palette, func(i, j int) bool { return palette[i] < palette[j] })
+	return palette
+}
+
+// packIndexes replaces pixels by their palette indexes, packing several
+// indexes into the green channel of a pixel for small palettes.
+func packIndexes(pixels []uint32, width, height int, palette []uint32) ([]uint32, int) {
+	packing := 0
+	switch {
+	case len(palette) <= 2:
+		packing = 3
+	case len(palette) <= 4:
+		packing = 2
+	case len(palette) <= 16:
+		packing = 1
+	}
+	indexes := make(map[uint32]uint32, len(palette))
+	for i, p := range palette {
+		indexes[p] = uint32(i)
+	}
+
+	xsize := subSampleSize(width, packing)
+	bitsPerPixel := uint(8 >> uint(packing))
+	packed := make([]uint32, xsize*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			shift := uint(x&(1<<uint(packing)-1))*bitsPerPixel + 8
+			packed[y*xsize+x>>uint(packing)] |= indexes[pixels[y*width+x]] << shift
+		}
+	}
+	for i := range packed {
+		packed[i] |= 0xff000000
+	}
+	return packed, xsize
+}
+
+// applyPredictors chooses the predictor of every block with the smallest
+// residuals and returns the predictor modes and the residuals.
+func applyPredictors(pixels []uint32, width, height int) ([]uint32, []uint32) {
+	blocksPerRow := subSampleSize(width, predictorBits)
+	modes := make([]uint32, blocksPerRow*subSampleSize(height, predictorBits))
+	residuals := make([]uint32, len(pixels))
+
+	prediction := func(mode uint32, x, y int) uint32 {
+		i := y*width + x
+		switch {
+		case x == 0 && y == 0:
+			return 0xff000000
+		case y == 0:
+			return pixels[i-1]
+		case x == 0:
+			return pixels[i-width]
+		}
+		return predict(mode, pixels, i, width)
+	}
+
+	size := 1 << predictorBits
+	for by := 0; by*size < height; by++ {
+		for bx := 0; bx*size < width; bx++ {
+			best, bestCost := uint32(0), -1
+			for mode := uint32(0); mode < 14; mode++ {
+				cost := 0
+				for y := by * size; y < (by+1)*size && y < height; y++ {
+					for x := bx * size; x < (bx+1)*size && x < width; x++ {
+						r := subPixels(pixels[y*width+x], prediction(mode, x, y))
+						for shift := uint(0); shift < 32; shift += 8 {
+							cost += residualCost[r>>shift&0xff]
+						}
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[by*blocksPerRow+bx] = 0xff000000 | best<<8
+			for y := by * size; y < (by+1)*size && y < height; y++ {
+				for x := bx * size; x < (bx+1)*size && x < width; x++ {
+					residuals[y*width+x] = subPixels(pixels[y*width+x], prediction(best, x, y))
+				}
+			}
+		}
+	}
+	return modes, residuals
+}
+
+// residualCost estimates the cost of a residual by its magnitude.
+var residualCost [256]int
+
+func init() {
+	for i := range residualCost {
+		residualCost[i] = bits.Len(uint(abs(int(int8(i)))))
+	}
+}
+
+// token is a literal pixel, a color cache hit or a backward reference.
+type token struct {
+	pixel    uint32
+	cache    int // color cache index, or -1
+	length   int // length of a backward reference, or 0
+	distance int // distance code of a backward reference
+}
+
+// backwardReferences splits pixels into literals, color cache hits and
+// copies of earlier pixels found through a hash chain.
+func backwardReferences(pixels []uint32, xsize, cacheBits int) []token {
+	n := len(pixels)
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	chain := make([]int32, n)
+	hash := func(i int) uint32 {
+		return (pixels[i]*0x1e35a7bd + pixels[i+1]*0x9e3779b1) >> (32 - hashBits)
+	}
+	insertHash := func(i int) {
+		if i+1 < n {
+			h := hash(i)
+			chain[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+
+	var cache []uint32
+	if cacheBits > 0 {
+		cache = make([]uint32, 1<<uint(cacheBits))
+	}
+	cacheIndex := func(p uint32) int {
+		return int((0x1e35a7bd * p) >> uint(32-cacheBits))
+	}
+
+	var tokens []token
+	for i := 0; i < n; {
+		bestLength, bestDistance := 0, 0
+		try := func(j int) {
+			if j < 0 || j >= i || i-j > maxDistance {
+				return
+			}
+			length, max := 0, n-i
+			if max > maxLength {
+				max = maxLength
+			}
+			// Only a longer match can win.
+			if bestLength >= max || pixels[i+bestLength] != pixels[j+bestLength] {
+				return
+			}
+			for length < max && pixels[i+length] == pixels[j+length] {
+				length++
+			}
+			if length > bestLength {
+				bestLength, bestDistance = length, i-j
+			}
+		}
+		try(i - 1)
+		try(i - xsize)
+		if i+1 < n {
+			j := head[hash(i)]
+			for depth := 0; j >= 0 && depth < chainDepth && bestLength < maxLength; depth++ {
+				try(int(j))
+				j = chain[j]
+			}
+		}
+
+		if bestLength >= 3 {
+			distance := bestDistance + 120
+			switch bestDistance {
+			case xsize:
+				distance = 1
+			case 1:
+				distance = 2
+			}
+			tokens = append(tokens, token{cache: -1, length: bestLength, distance: distance})
+			for k := 0; k < bestLength; k++ {
+				insertHash(i + k)
+				if cache != nil {
+					cache[cacheIndex(pixels[i+k])] = pixels[i+k]
+				}
+			}
+			i += bestLength
+			continue
+		}
+
+		p := pixels[i]
+		t := token{pixel: p, cache: -1}
+		if cache != nil {
+			index := cacheIndex(p)
+			if cache[index] == p {
+				t.cache = index
+			}
+			cache[index] = p
+		}
+		tokens = append(tokens, t)
+		insertHash(i)
+		i++
+	}
+	return tokens
+}
+
+// prefixed returns the prefix code and extra bits of a length or distance.
+func prefixed(v int) (prefix int, extra uint32, extraBits uint) {
+	if v <= 4 {
+		return v - 1, 0, 0
+	}
+	d := v - 1
+	high := uint(bits.Len(uint(d))) - 1
+	second := d >> (high - 1) & 1
+	extraBits = high - 1
+	return int(2*high) + second, uint32(d) & (1<<extraBits - 1), extraBits
+}
+
+// prefixCode is a prefix code being written.
+type prefixCode struct {
+	lengths []int
+	codes   []uint32
+	single  bool // the code has one symbol, which takes no bits
+}
+
+func newPrefixCode(counts []int, limit int) *prefixCode {
+	lengths := codeLengths(counts, limit)
+	used := 0
+	for _, length := range lengths {
+		if length > 0 {
+			used++
+		}
+	}
+	return &prefixCode{lengths: lengths, codes: canonicalCodes(lengths), single: used <= 1}
+}
+
+func (c *prefixCode) write(bw *bitWriter, symbol int) {
+	if !c.single {
+		bw.write(c.codes[symbol], uint(c.lengths[symbol]))
+	}
+}
+
+// writeImageStream writes an entropy coded image. Only the main image uses
+// the color cache.
+func writeImageStream(bw *bitWriter, pixels []uint32, xsize, ysize int, main bool) {
+	cache := 0
+	if main {
+		cache = cacheBits
+		bw.write(1, 1)
+		bw.write(cacheBits, 4)
+		bw.write(0, 1) // no meta prefix codes
+	} else {
+		bw.write(0, 1)
+	}
+
+	tokens := backwardReferences(pixels, xsize, cache)
+	cacheSize := 0
+	if cache > 0 {
+		cacheSize = 1 << uint(cache)
+	}
+	counts := [5][]int{
+		make([]int, numLiterals+numLengthCodes+cacheSize),
+		make([]int, numLiterals),
+		make([]int, numLiterals),
+		make([]int, numLiterals),
+		make([]int, numDistanceCode),
+	}
+	for _, t := range tokens {
+		switch {
+		case t.length > 0:
+			prefix, _, _ := prefixed(t.length)
+			counts[0][numLiterals+prefix]++
+			prefix, _, _ = prefixed(t.distance)
+			counts[4][prefix]++
+		case t.cache >= 0:
+			counts[0][numLiterals+numLengthCodes+t.cache]++
+		default:
+			counts[0][t.pixel>>8&0xff]++
+			counts[1][t.pixel>>16&0xff]++
+			counts[2][t.pixel&0xff]++
+			counts[3][t.pixel>>24]++
+		}
+	}
+
+	var codes [5]*prefixCode
+	for i := range codes {
+		codes[i] = writeCode(bw, counts[i])
+	}
+
+	for _, t := range tokens {
+		switch {
+		case t.length > 0:
+			prefix, extra, extraBits := prefixed(t.length)
+			codes[0].write(bw, numLiterals+prefix)
+			bw.write(extra, extraBits)
+			prefix, extra, extraBits = prefixed(t.distance)
+			codes[4].write(bw, prefix)
+			bw.write(extra, extraBits)
+		case t.cache >= 0:
+			codes[0].write(bw, numLiterals+numLengthCodes+t.cache)
+		default:
+			codes[0].write(bw, int(t.pixel>>8&0xff))
+			codes[1].write(bw, int(t.pixel>>16&0xff))
+			codes[2].write(bw, int(t.pixel&0xff))
+			codes[3].write(bw, int(t.pixel>>24))
+		}
+	}
+}
+
+// writeCode writes the prefix code for the symbol counts and returns it.
+func writeCode(bw *bitWriter, counts []int) *prefixCode {
+	used, last := 0, 0
+	for symbol, count := range counts {
+		if count > 0 {
+			used, last = used+1, symbol
+		}
+	}
+
+	switch {
+	case used == 0 || used == 1 && last < numLiterals:
+		// A simple code of a single symbol, which takes no bits.
+		bw.write(1, 1)
+		bw.write(0, 1)
+		bw.write(1, 1)
+		bw.write(uint32(last), 8)
+		return &prefixCode{single: true}
+	case used == 1:
+		// Simple codes cannot hold symbols beyond 255, and a normal code
+		// needs a second symbol to take any bits.
+		counts = append([]int(nil), counts...)
+		counts[0] = 1
+	}
+	code := newPrefixCode(counts, maxCodeLength)
+
+	// Send the code lengths, with zero runs coded as 17 and 18.
+	type lengthToken struct {
+		symbol    int
+		extra     uint32
+		extraBits uint
+	}
+	var tokens []lengthToken
+	var lengthCounts [19]int
+	for i := 0; i < len(code.lengths); {
+		if code.lengths[i] != 0 {
+			tokens = append(tokens, lengthToken{symbol: code.lengths[i]})
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(code.lengths) && code.lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+		switch {
+		case run >= 11:
+			tokens = append(tokens, lengthToken{18, uint32(run - 11), 7})
+		case run >= 3:
+			tokens = append(tokens, lengthToken{17, uint32(run - 3), 3})
+		default:
+			run = 1
+			tokens = append(tokens, lengthToken{symbol: 0})
+		}
+		i += run
+	}
+	for _, t := range tokens {
+		lengthCounts[t.symbol]++
+	}
+	lengthCode := newPrefixCode(lengthCounts[:], 7)
+
+	bw.write(0, 1)
+	n := len(codeLengthOrder)
+	for n > 4 && lengthCode.lengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(uint32(n-4), 4)
+	for _, symbol := range codeLengthOrder[:n] {
+		bw.write(uint32(lengthCode.lengths[symbol]), 3)
+	}
+	bw.write(0, 1) // code lengths for the whole alphabet follow
+	for _, t := range tokens {
+		lengthCode.write(bw, t.symbol)
+		bw.write(t.extra, t.extraBits)
+	}
+	return code
+}
@@ -0,0 +1,181 @@
+package webp
+
+import "sort"
+
+// maxCodeLength is the longest prefix code of VP8L.
+const maxCodeLength = 15
+
+// huffman decodes a canonical prefix code. Codes are sent starting with
+// their most significant bit.
+type huffman struct {
+	count  [maxCodeLength + 1]uint16 // number of codes of each length
+	symbol []uint16                  // symbols ordered by code
+	// single is the only symbol of a code with one symbol, which takes
+	// no bits at all, or -1.
+	single int
+}
+
+func newHuffman(lengths []int) (*huffman, error) {
+	h := &huffman{single: -1}
+	used := 0
+	for symbol, length := range lengths {
+		if length > 0 {
+			h.count[length]++
+			h.single = symbol
+			used++
+		}
+	}
+	if used == 0 {
+		return nil, FormatError("empty prefix code")
+	}
+	if used > 1 {
+		h.single = -1
+	}
+
+	left := 1
+	for length := 1; length <= maxCodeLength; length++ {
+		left = left<<1 - int(h.count[length])
+		if left < 0 {
+			return nil, FormatError("over-subscribed prefix code")
+		}
+	}
+
+	var offsets [maxCodeLength + 2]int
+	for length := 1; length <= maxCodeLength; length++ {
+		offsets[length+1] = offsets[length] + int(h.count[length])
+	}
+	h.symbol = make([]uint16, used)
+	for symbol, length := range lengths {
+		if length > 0 {
+			h.symbol[offsets[length]] = uint16(symbol)
+			offsets[length]++
+		}
+	}
+	return h, nil
+}
+
+func (h *huffman) decode(br *bitReader) int {
+	if h.single >= 0 {
+		return h.single
+	}
+	code, first, index := 0, 0, 0
+	for length := 1; length <= maxCodeLength; length++ {
+		code |= int(br.read(1))
+		count := int(h.count[length])
+		if code-first < count {
+			return int(h.symbol[index+code-first])
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	br.fail(FormatError("invalid prefix code"))
+	return 0
+}
+
+// codeLengths returns the lengths of an optimal prefix code for the symbol
+// counts, limited to limit bits. Unused symbols get length zero; a single
+// used symbol gets length one.
+func codeLengths(counts []int, limit int) []int {
+	lengths := make([]int, len(counts))
+	weights := append([]int(nil), counts...)
+	for {
+		type node struct {
+			weight      int
+			symbol      int // or -1 for internal nodes
+			left, right int
+		}
+		var nodes []node
+		for symbol, weight := range weights {
+			if weight > 0 {
+				nodes = append(nodes, node{weight, symbol, -1, -1})
+			}
+		}
+		switch len(nodes) {
+		case 0:
+			return lengths
+		case 1:
+			lengths[nodes[0].symbol] = 1
+			return lengths
+		}
+		sort.Slice(nodes, func(i, j int) bool {
+			if nodes[i].weight != nodes[j].weight {
+				return nodes[i].weight < nodes[j].weight
+			}
+			return nodes[i].symbol < nodes[j].symbol
+		})
+
+		// Merge the two lightest trees, taking them from the sorted
+		// leaves and from the internal nodes, which are created in order
+		// of weight.
+		leaves := len(nodes)
+		leaf, internal := 0, leaves
+		lightest := func() int {
+			if leaf < leaves && (internal >= len(nodes) || nodes[leaf].weight <= nodes[internal].weight) {
+				leaf++
+				return leaf - 1
+			}
+			internal++
+			return internal - 1
+		}
+		for i := 0; i < leaves-1; i++ {
+			a := lightest()
+			b := lightest()
+			nodes = append(nodes, node{nodes[a].weight + nodes[b].weight, -1, a, b})
+		}
+
+		longest := 0
+		var walk func(i, depth int)
+		walk = func(i, depth int) {
+			if nodes[i].symbol >= 0 {
+				lengths[nodes[i].symbol] = depth
+				if depth > longest {
+					longest = depth
+				}
+				return
+			}
+			walk(nodes[i].left, depth+1)
+			walk(nodes[i].right, depth+1)
+		}
+		walk(len(nodes)-1, 0)
+		if longest <= limit {
+			return lengths
+		}
+
+		// Flatten the distribution and try again.
+		for i, weight := range weights {
+			if weight > 0 {
+				weights[i] = weight>>1 | 1
+			}
+		}
+	}
+}
+
+// canonicalCodes returns the codes of the canonical prefix code with the
+// given lengths, bit reversed so they can be written least significant
+// bit first.
+func canonicalCodes(lengths []int) []uint32 {
+	var count, next [maxCodeLength + 2]uint32
+	for _, length := range lengths {
+		count[length]++
+	}
+	count[0] = 0
+	for length := 1; length <= maxCodeLength; length++ {
+		next[length+1] = (next[length] + count[length]) << 1
+	}
+
+	codes := make([]uint32, len(lengths))
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		code := next[length]
+		next[length]++
+		var reversed uint32
+		for i := 0; i < length; i++ {
+			reversed = reversed<<1 | code>>uint(i)&1
+		}
+		codes[symbol] = reversed
+	}
+	return codes
+}
@@ -0,0 +1,106 @@
+// Package webp encodes and decodes lossless WebP images, the VP8L format.
+// Lossy WebP is not supported. Importing it registers the format with the
+// image package.
+package webp
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+// A FormatError reports that the input is not a valid WebP.
+type FormatError string
+
+func (e FormatError) Error() string { return "webp: invalid format: " + string(e) }
+
+// An UnsupportedError reports that the input uses a valid but unimplemented
+// WebP feature.
+type UnsupportedError string
+
+func (e UnsupportedError) Error() string { return "webp: unsupported feature: " + string(e) }
+
+// maxDimension is the largest width and height VP8L can describe.
+const maxDimension = 1 << 14
+
+func init() {
+	image.RegisterFormat("webp", "RIFF????WEBP", Decode, DecodeConfig)
+}
+
+// Decode reads a lossless WebP image from r.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := readVP8L(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeVP8L(data)
+}
+
+// DecodeConfig returns the color model and dimensions of a lossless WebP
+// image without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	data, err := readVP8L(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	br := &bitReader{data: data}
+	width, height, _, err := readHeader(br)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: width, Height: height}, nil
+}
+
+// readVP8L returns the payload of the VP8L chunk of a RIFF container.
+func readVP8L(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, FormatError("not a WebP file")
+	}
+
+	for p := 12; p+8 <= len(data); {
+		fourCC := string(data[p : p+4])
+		size := int(binary.LittleEndian.Uint32(data[p+4:]))
+		p += 8
+		if size < 0 || size > len(data)-p {
+			return nil, FormatError("truncated chunk")
+		}
+		switch fourCC {
+		case "VP8L":
+			return data[p : p+size], nil
+		case "VP8 ":
+			return nil, UnsupportedError("lossy WebP")
+		}
+		// Skip VP8X, ICCP, EXIF and the other chunks, padded to even size.
+		p += size + size&1
+	}
+	return nil, FormatError("no image data")
+}
+
+// Encode writes img to w as a lossless WebP.
+func Encode(w io.Writer, img image.Image) error {
+	data, err := encodeVP8L(img)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 20)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)+len(data)&1))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
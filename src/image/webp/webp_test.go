@@ -0,0 +1,164 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/resizer"
+	"math/rand"
+	"testing"
+)
+
+// roundTrip encodes img and decodes the result.
+func roundTrip(t *testing.T, img image.Image) image.Image {
+	buffer := new(bytes.Buffer)
+	if err := Encode(buffer, img); err != nil {
+		t.Fatal(err)
+	}
+	decoded, format, err := image.Decode(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "webp" {
+		t.Errorf("format = %q, want webp", format)
+	}
+	return decoded
+}
+
+// assertSame fails unless got has the non-premultiplied 8-bit pixels of
+// want.
+func assertSame(t *testing.T, name string, got, want image.Image) {
+	if got.Bounds().Size() != want.Bounds().Size() {
+		t.Fatalf("%s: size = %v, want %v", name, got.Bounds().Size(), want.Bounds().Size())
+	}
+	dx := want.Bounds().Min.Sub(got.Bounds().Min)
+	for y := got.Bounds().Min.Y; y < got.Bounds().Max.Y; y++ {
+		for x := got.Bounds().Min.X; x < got.Bounds().Max.X; x++ {
+			g := color.NRGBAModel.Convert(got.At(x, y))
+			w := color.NRGBAModel.Convert(want.At(x+dx.X, y+dx.Y))
+			if g != w {
+				t.Fatalf("%s: pixel (%d, %d) = %v, want %v", name, x, y, g, w)
+			}
+		}
+	}
+}
+
+func gradient(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), uint8((x + y) % 256), 255})
+		}
+	}
+	return img
+}
+
+func noise(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	random := rand.New(rand.NewSource(1))
+	random.Read(img.Pix)
+	return img
+}
+
+func TestRoundTrip(t *testing.T) {
+	translucent := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for i := range translucent.Pix {
+		translucent.Pix[i] = uint8(i * 7)
+	}
+	paletted := image.NewPaletted(image.Rect(0, 0, 33, 17), color.Palette{
+		color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}, color.Transparent,
+	})
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(i % 3)
+	}
+	few := image.NewRGBA(image.Rect(0, 0, 21, 9))
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 21; x++ {
+			few.Set(x, y, color.RGBA{uint8(x % 5 * 50), uint8(y % 2 * 200), 0, 255})
+		}
+	}
+	gray := image.NewGray(image.Rect(0, 0, 50, 50))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i)
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"gradient", gradient(64, 48)},
+		{"translucent", translucent},
+		{"two colors and transparent", paletted},
+		{"ten colors", few},
+		{"gray", gray},
+		{"noise", noise(37, 23)},
+		{"single pixel", noise(1, 1)},
+		{"single row", noise(300, 1)},
+		{"single column", gradient(1, 70)},
+		{"uniform", image.NewRGBA(image.Rect(0, 0, 200, 100))},
+		{"offset bounds", gradient(80, 60).SubImage(image.Rect(13, 7, 50, 41))},
+	}
+	for _, test := range tests {
+		assertSame(t, test.name, roundTrip(t, test.img), test.img)
+	}
+}
+
+// TestRoundTripResized checks that the outputs of both resizer paths come
+// back unchanged.
+func TestRoundTripResized(t *testing.T) {
+	ycbcr := image.NewYCbCr(image.Rect(0, 0, 90, 60), image.YCbCrSubsampleRatio420)
+	for i := range ycbcr.Y {
+		ycbcr.Y[i] = uint8(i * 3)
+	}
+	for i := range ycbcr.Cb {
+		ycbcr.Cb[i], ycbcr.Cr[i] = uint8(i), uint8(255-i)
+	}
+
+	sources := []image.Image{gradient(120, 80), noise(50, 50), ycbcr}
+	for _, source := range sources {
+		for _, size := range [][2]uint{{40, 0}, {0, 33}, {200, 150}} {
+			resized := *resizer.Resize(size[0], size[1], &source)
+			assertSame(t, "resized", roundTrip(t, resized), resized)
+		}
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	if err := Encode(buffer, noise(17, 5)); err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "webp" || config.Width != 17 || config.Height != 5 {
+		t.Errorf("DecodeConfig = %s %dx%d, want webp 17x5", format, config.Width, config.Height)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	if err := Encode(buffer, gradient(30, 30)); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+
+	for _, n := range []int{0, 12, 20, 30, len(data) / 2, len(data) - 2} {
+		if _, err := Decode(bytes.NewReader(data[:n])); err == nil {
+			t.Errorf("decoding %d of %d bytes succeeded", n, len(data))
+		}
+	}
+
+	lossy := append([]byte(nil), data...)
+	copy(lossy[12:16], "VP8 ")
+	if _, err := Decode(bytes.NewReader(lossy)); err != UnsupportedError("lossy WebP") {
+		t.Errorf("lossy WebP: err = %v", err)
+	}
+}
+
+func TestEncodeLargeImage(t *testing.T) {
+	if err := Encode(new(bytes.Buffer), image.NewGray(image.Rect(0, 0, maxDimension+1, 1))); err == nil {
+		t.Error("encoding an image wider than 16384 pixels succeeded")
+	}
+}
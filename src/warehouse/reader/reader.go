@@ -1,22 +1,173 @@
 package reader
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"image"
-	"log"
+	"io"
 	"os"
+
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"image/bmp"
+	"image/codec"
+	"image/exif"
+	"image/resizer"
+	"image/tiff"
+	"image/webp"
+	"warehouse/storage"
 )
 
+// Warehouse is the default root of the local storage.
 const Warehouse = "warehouse/"
 
-func Decode(filename string) *image.Image {
-	f, err := os.Open(Warehouse + filename)
+// Kinds of errors returned by Decode. Use errors.Is to tell them apart.
+var (
+	ErrNotFound          = errors.New("image not found")
+	ErrPermission        = errors.New("permission denied")
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrCorrupt           = errors.New("corrupt image data")
+)
+
+// Error records a failure to read an image from the warehouse.
+type Error struct {
+	Filename string
+	Kind     error // one of the Err* kinds above
+	Err      error // the underlying error
+}
+
+func (e *Error) Error() string {
+	return e.Filename + ": " + e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// exifPeek is how much of a JPEG is searched for EXIF metadata, enough
+// for an APP0 and an APP1 segment of the largest size.
+const exifPeek = 1 << 17
+
+// Decode reads and decodes an image from the storage. It returns the
+// image together with the format name reported by the decoder. JPEGs are
+// turned upright as their EXIF orientation tells.
+func Decode(store storage.Storage, filename string) (*image.Image, string, error) {
+	return decodeFile(store, filename, true)
+}
+
+func decodeFile(store storage.Storage, filename string, orient bool) (*image.Image, string, error) {
+	f, err := store.Open(filename)
 	if err != nil {
-		log.Println("File not found")
-		return nil
+		return nil, "", openError(filename, err)
 	}
+	defer f.Close()
+
+	return decode(f, filename, orient)
+}
 
-	image, _, _ := image.Decode(f)
+// DecodeIfModified is like Decode, but when the storage supports
+// conditional reads it skips an original that still matches cached and
+// returns storage.ErrNotModified. It also describes the decoded original,
+// or returns a nil *storage.Object when the storage cannot revalidate.
+// Unless orient is set, the EXIF orientation is ignored.
+func DecodeIfModified(store storage.Storage, filename string, cached *storage.Object, orient bool) (*image.Image, string, *storage.Object, error) {
+	conditional, ok := store.(storage.ConditionalStorage)
+	if !ok {
+		image, format, err := decodeFile(store, filename, orient)
+		return image, format, nil, err
+	}
 
+	f, object, err := conditional.OpenIfModified(filename, cached)
+	if err == storage.ErrNotModified {
+		return nil, "", cached, err
+	}
+	if err != nil {
+		return nil, "", nil, openError(filename, err)
+	}
 	defer f.Close()
-	return &image
+
+	image, format, err := decode(f, filename, orient)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return image, format, object, nil
+}
+
+// openError classifies an error returned by opening an original.
+func openError(filename string, err error) error {
+	switch {
+	case os.IsNotExist(err):
+		return &Error{filename, ErrNotFound, err}
+	case os.IsPermission(err):
+		return &Error{filename, ErrPermission, err}
+	}
+	return err
+}
+
+func decode(r io.Reader, filename string, orient bool) (*image.Image, string, error) {
+	br := bufio.NewReaderSize(r, exifPeek)
+	magic, _ := br.Peek(4)
+	if string(magic) == "GIF8" {
+		return decodeGIF(br, filename)
+	}
+	var header []byte
+	if orient && bytes.HasPrefix(magic, []byte{0xff, 0xd8}) {
+		header, _ = br.Peek(exifPeek)
+	}
+
+	img, format, err := image.Decode(br)
+	if err != nil {
+		return nil, "", &Error{filename, decodeErrorKind(err), err}
+	}
+
+	result := &img
+	if header != nil {
+		result = orientJPEG(header, result)
+	}
+	return result, format, nil
+}
+
+// orientJPEG turns a JPEG upright as the EXIF metadata at the start of its
+// data tells. Missing or broken metadata leaves it as it is.
+func orientJPEG(header []byte, img *image.Image) *image.Image {
+	x, err := exif.Decode(bytes.NewReader(header))
+	if err != nil {
+		return img
+	}
+	transpose, flipX, flipY := x.Orientation().Correction()
+	return resizer.Reorient(img, resizer.Orientation{Transpose: transpose, FlipX: flipX, FlipY: flipY})
+}
+
+// decodeGIF decodes all frames of a GIF. Animations are returned as a
+// *codec.Animation, single frames as they are.
+func decodeGIF(r io.Reader, filename string) (*image.Image, string, error) {
+	g, err := gif.DecodeAll(r)
+	if err == nil && len(g.Image) == 0 {
+		err = errors.New("gif: no frames")
+	}
+	if err != nil {
+		return nil, "", &Error{filename, ErrCorrupt, err}
+	}
+
+	var img image.Image = g.Image[0]
+	if len(g.Image) > 1 {
+		img = codec.NewAnimation(g)
+	}
+	return &img, "gif", nil
+}
+
+// decodeErrorKind classifies an error returned by image.Decode.
+func decodeErrorKind(err error) error {
+	switch err.(type) {
+	case jpeg.UnsupportedError, png.UnsupportedError, bmp.UnsupportedError, tiff.UnsupportedError,
+		webp.UnsupportedError:
+		return ErrUnsupportedFormat
+	}
+	if err == image.ErrFormat {
+		return ErrUnsupportedFormat
+	}
+	return ErrCorrupt
 }
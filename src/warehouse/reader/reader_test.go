@@ -0,0 +1,216 @@
+package reader
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"image/codec"
+	"warehouse/storage"
+)
+
+func encodeJPEG(t *testing.T) []byte {
+	buffer := new(bytes.Buffer)
+	if err := jpeg.Encode(buffer, image.NewGray(image.Rect(0, 0, 64, 64)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	jpg := encodeJPEG(t)
+	store := storage.NewMemory()
+	for name, data := range map[string][]byte{
+		"valid.jpg":     jpg,
+		"truncated.jpg": jpg[:len(jpg)/2],
+		"header.jpg":    jpg[:2],
+		"garbage.jpg":   []byte("this is not an image at all"),
+		"empty.png":     {},
+	} {
+		store.Put(name, data, time.Now())
+	}
+
+	tests := []struct {
+		filename string
+		kind     error
+	}{
+		{"valid.jpg", nil},
+		{"missing.jpg", ErrNotFound},
+		{"truncated.jpg", ErrCorrupt},
+		{"header.jpg", ErrCorrupt},
+		{"garbage.jpg", ErrUnsupportedFormat},
+		{"empty.png", ErrUnsupportedFormat},
+	}
+
+	for _, test := range tests {
+		img, format, err := Decode(store, test.filename)
+		if test.kind == nil {
+			if err != nil || img == nil || *img == nil || format != "jpeg" {
+				t.Errorf("Decode(%q) = %v, %q, %v", test.filename, img, format, err)
+			}
+			continue
+		}
+		if !errors.Is(err, test.kind) {
+			t.Errorf("Decode(%q) error = %v, want kind %v", test.filename, err, test.kind)
+		}
+		if img != nil {
+			t.Errorf("Decode(%q) returned an image with error %v", test.filename, err)
+		}
+	}
+}
+
+func TestDecodeDirectory(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "folder"), 0755)
+
+	if _, _, err := Decode(storage.NewLocal(root), "folder"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Decode error = %v, want kind %v", err, ErrNotFound)
+	}
+}
+
+func TestDecodePermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	root := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(root, "secret.jpg"), encodeJPEG(t), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Decode(storage.NewLocal(root), "secret.jpg"); !errors.Is(err, ErrPermission) {
+		t.Errorf("Decode error = %v, want kind %v", err, ErrPermission)
+	}
+}
+
+func TestDecodeGIF(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	frame := func() *image.Paletted { return image.NewPaletted(image.Rect(0, 0, 8, 8), palette) }
+	encode := func(g *gif.GIF) []byte {
+		buffer := new(bytes.Buffer)
+		if err := gif.EncodeAll(buffer, g); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.Bytes()
+	}
+
+	store := storage.NewMemory()
+	store.Put("still.gif", encode(&gif.GIF{Image: []*image.Paletted{frame()}, Delay: []int{0}}), time.Now())
+	store.Put("animated.gif", encode(&gif.GIF{Image: []*image.Paletted{frame(), frame()}, Delay: []int{5, 5}, LoopCount: 2}), time.Now())
+
+	img, format, err := Decode(store, "still.gif")
+	if err != nil || format != "gif" {
+		t.Fatalf("Decode(still.gif) = %q, %v", format, err)
+	}
+	if _, ok := (*img).(*image.Paletted); !ok {
+		t.Errorf("still GIF decoded as %T", *img)
+	}
+
+	img, format, err = Decode(store, "animated.gif")
+	if err != nil || format != "gif" {
+		t.Fatalf("Decode(animated.gif) = %q, %v", format, err)
+	}
+	a, ok := (*img).(*codec.Animation)
+	if !ok {
+		t.Fatalf("animated GIF decoded as %T", *img)
+	}
+	if a.Len() != 2 || a.GIF.LoopCount != 2 {
+		t.Errorf("animation has %d frames and loop count %d", a.Len(), a.GIF.LoopCount)
+	}
+}
+
+func TestDecodeBMPAndTIFF(t *testing.T) {
+	bmpHeader := func(bpp, compression byte) []byte {
+		return []byte{
+			'B', 'M', 58, 0, 0, 0, 0, 0, 0, 0, 54, 0, 0, 0,
+			40, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, bpp, 0, compression, 0, 0, 0,
+			4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 255, 0,
+		}
+	}
+	// A 1x1 8-bit gray TIFF whose strip follows the header.
+	tiff := []byte{
+		'I', 'I', 42, 0, 10, 0, 0, 0, 200, 0,
+		5, 0,
+		0, 1, 3, 0, 1, 0, 0, 0, 1, 0, 0, 0,
+		1, 1, 3, 0, 1, 0, 0, 0, 1, 0, 0, 0,
+		2, 1, 3, 0, 1, 0, 0, 0, 8, 0, 0, 0,
+		17, 1, 4, 0, 1, 0, 0, 0, 8, 0, 0, 0,
+		23, 1, 4, 0, 1, 0, 0, 0, 1, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+
+	store := storage.NewMemory()
+	store.Put("red.bmp", bmpHeader(24, 0), time.Now())
+	store.Put("jpeg.bmp", bmpHeader(24, 4), time.Now())
+	store.Put("gray.tif", tiff, time.Now())
+
+	for name, format := range map[string]string{"red.bmp": "bmp", "gray.tif": "tiff"} {
+		if _, got, err := Decode(store, name); err != nil || got != format {
+			t.Errorf("Decode(%s) = %q, %v, want %q", name, got, err, format)
+		}
+	}
+	if _, _, err := Decode(store, "jpeg.bmp"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Decode(jpeg.bmp) error = %v, want kind %v", err, ErrUnsupportedFormat)
+	}
+}
+
+// withOrientation inserts an EXIF APP1 segment holding only an orientation
+// into a JPEG.
+func withOrientation(jpg []byte, orientation byte) []byte {
+	segment := []byte{
+		0xff, 0xe1, 0, 34, 'E', 'x', 'i', 'f', 0, 0,
+		'I', 'I', 42, 0, 8, 0, 0, 0,
+		1, 0,
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, orientation, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+func TestDecodeOrientation(t *testing.T) {
+	// Stored dark on the left and light on the right, and turned 90
+	// degrees counterclockwise.
+	src := image.NewGray(image.Rect(0, 0, 16, 8))
+	for i := range src.Pix {
+		if i%16 >= 8 {
+			src.Pix[i] = 0xff
+		}
+	}
+	buffer := new(bytes.Buffer)
+	if err := jpeg.Encode(buffer, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewMemory()
+	store.Put("turned.jpg", withOrientation(buffer.Bytes(), 6), time.Now())
+
+	img, _, err := Decode(store, "turned.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := (*img).Bounds().Size(); size != image.Pt(8, 16) {
+		t.Fatalf("oriented image is %v, want 8x16", size)
+	}
+	top := color.GrayModel.Convert((*img).At(4, 2)).(color.Gray).Y
+	bottom := color.GrayModel.Convert((*img).At(4, 13)).(color.Gray).Y
+	if top > 0x20 || bottom < 0xe0 {
+		t.Errorf("oriented image is %d at the top and %d at the bottom, want dark over light", top, bottom)
+	}
+
+	img, _, _, err = DecodeIfModified(store, "turned.jpg", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := (*img).Bounds().Size(); size != image.Pt(16, 8) {
+		t.Errorf("image read without orientation is %v, want 16x8", size)
+	}
+}
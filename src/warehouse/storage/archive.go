@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Zip serves originals from a zip archive.
+type Zip struct {
+	archive *zip.ReadCloser
+	files   map[string]*zip.File
+}
+
+// NewZip opens the zip archive at filename. The archive stays open for
+// the lifetime of the storage.
+func NewZip(filename string) (*Zip, error) {
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	z := &Zip{archive: archive, files: make(map[string]*zip.File)}
+	for _, f := range archive.File {
+		if !f.FileInfo().IsDir() {
+			z.files[archiveName(f.Name)] = f
+		}
+	}
+	return z, nil
+}
+
+func (z *Zip) Open(name string) (io.ReadCloser, error) {
+	f, ok := z.files[name]
+	if !ok {
+		return nil, notExist(name)
+	}
+	return f.Open()
+}
+
+func (z *Zip) Stat(name string) (*Object, error) {
+	f, ok := z.files[name]
+	if !ok {
+		return nil, notExist(name)
+	}
+	return &Object{Name: name, Size: int64(f.UncompressedSize64), ModTime: f.Modified}, nil
+}
+
+func (z *Zip) List() ([]*Object, error) {
+	objects := make([]*Object, 0, len(z.files))
+	for name, f := range z.files {
+		objects = append(objects, &Object{Name: name, Size: int64(f.UncompressedSize64), ModTime: f.Modified})
+	}
+	sortObjects(objects)
+	return objects, nil
+}
+
+// Close closes the underlying archive.
+func (z *Zip) Close() error {
+	return z.archive.Close()
+}
+
+// Tar serves originals from a tar archive. Uncompressed archives are
+// indexed once and read in place, gzip-compressed ones are held in memory.
+type Tar struct {
+	file    *os.File
+	objects map[string]*tarObject
+}
+
+type tarObject struct {
+	Object
+	offset int64
+	data   []byte
+}
+
+// NewTar opens and indexes the tar archive at filename. Archives named
+// *.tar.gz or *.tgz are decompressed into memory.
+func NewTar(filename string) (*Tar, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tar{objects: make(map[string]*tarObject)}
+	if strings.HasSuffix(filename, ".gz") || strings.HasSuffix(filename, ".tgz") {
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.index(gz, true); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+
+	t.file = f
+	if err := t.index(f, false); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// index records the objects of the archive read from r, either with their
+// offsets in the file or with their contents.
+func (t *Tar) index(r io.Reader, load bool) error {
+	counter := &countingReader{r: r}
+	archive := tar.NewReader(counter)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		object := &tarObject{
+			Object: Object{Name: archiveName(header.Name), Size: header.Size, ModTime: header.ModTime},
+			offset: counter.n,
+		}
+		if load {
+			if object.data, err = ioutil.ReadAll(archive); err != nil {
+				return err
+			}
+		}
+		t.objects[object.Name] = object
+	}
+}
+
+func (t *Tar) Open(name string) (io.ReadCloser, error) {
+	object, ok := t.objects[name]
+	if !ok {
+		return nil, notExist(name)
+	}
+	if t.file == nil {
+		return ioutil.NopCloser(bytes.NewReader(object.data)), nil
+	}
+	return ioutil.NopCloser(io.NewSectionReader(t.file, object.offset, object.Size)), nil
+}
+
+func (t *Tar) Stat(name string) (*Object, error) {
+	object, ok := t.objects[name]
+	if !ok {
+		return nil, notExist(name)
+	}
+	o := object.Object
+	return &o, nil
+}
+
+func (t *Tar) List() ([]*Object, error) {
+	objects := make([]*Object, 0, len(t.objects))
+	for _, object := range t.objects {
+		o := object.Object
+		objects = append(objects, &o)
+	}
+	sortObjects(objects)
+	return objects, nil
+}
+
+// Close closes the underlying archive file.
+func (t *Tar) Close() error {
+	if t.file == nil {
+		return nil
+	}
+	return t.file.Close()
+}
+
+// countingReader counts the bytes read through it, which tells the offset
+// of an entry's content once tar.Reader has consumed its header.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// archiveName normalizes the name of an archive entry to a clean,
+// slash-separated name without a leading "./" or "/".
+func archiveName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func sortObjects(objects []*Object) {
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local serves originals from a directory of the local filesystem.
+type Local struct {
+	root string
+}
+
+// NewLocal returns a storage rooted at the directory root.
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// path returns the filesystem path of the named object. Names that lead
+// outside the root, lexically or through symbolic links, do not exist.
+func (l *Local) path(name string) (string, error) {
+	p := filepath.Join(l.root, filepath.FromSlash(name))
+	if !within(filepath.Clean(l.root), p) {
+		return "", notExist(name)
+	}
+
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(l.root)
+	if err != nil {
+		return "", err
+	}
+	if !within(root, resolved) {
+		return "", notExist(name)
+	}
+	return resolved, nil
+}
+
+// within reports whether the clean path p lies inside the directory root.
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (l *Local) Open(name string) (io.ReadCloser, error) {
+	p, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		f.Close()
+		return nil, notExist(name)
+	}
+	return f, nil
+}
+
+func (l *Local) Stat(name string) (*Object, error) {
+	p, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, notExist(name)
+	}
+	return &Object{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) List() ([]*Object, error) {
+	var objects []*Object
+	err := filepath.Walk(l.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, &Object{Name: filepath.ToSlash(name), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
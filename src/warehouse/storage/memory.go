@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// Memory keeps originals in memory. It is mostly useful in tests.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory returns an empty in-memory storage.
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string]*memoryObject)}
+}
+
+// Put stores data under name, replacing any previous object.
+func (m *Memory) Put(name string, data []byte, modTime time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[name] = &memoryObject{data, modTime}
+}
+
+func (m *Memory) Open(name string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[name]
+	if !ok {
+		return nil, notExist(name)
+	}
+	return ioutil.NopCloser(bytes.NewReader(object.data)), nil
+}
+
+func (m *Memory) Stat(name string) (*Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[name]
+	if !ok {
+		return nil, notExist(name)
+	}
+	return &Object{Name: name, Size: int64(len(object.data)), ModTime: object.modTime}, nil
+}
+
+func (m *Memory) List() ([]*Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	objects := make([]*Object, 0, len(m.objects))
+	for name, object := range m.objects {
+		objects = append(objects, &Object{Name: name, Size: int64(len(object.data)), ModTime: object.modTime})
+	}
+	sortObjects(objects)
+	return objects, nil
+}
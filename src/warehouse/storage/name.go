@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrInvalidName is returned by CleanName for names that are malformed or
+// try to leave the storage root.
+var ErrInvalidName = errors.New("storage: invalid name")
+
+// DefaultExtensions lists the file extensions served by default.
+var DefaultExtensions = []string{"jpg", "jpeg", "png", "gif", "bmp", "tif", "tiff", "webp"}
+
+// CleanName validates a requested object name and returns it in canonical
+// slash-separated form. Names that are malformed or contain ".." segments,
+// including percent-encoded ones, are rejected with ErrInvalidName. Names
+// of hidden files, or with an extension not listed in extensions, are
+// reported as not existing, so they cannot be told apart from absent files.
+// Names without any extension are allowed.
+func CleanName(name string, extensions []string) (string, error) {
+	name = strings.TrimPrefix(name, "/")
+	if name == "" || strings.ContainsAny(name, "\\\x00") {
+		return "", ErrInvalidName
+	}
+	for _, c := range name {
+		if c < ' ' || c == 0x7f {
+			return "", ErrInvalidName
+		}
+	}
+
+	// Reject dot segments even when they are still encoded, so a second
+	// decoding further down cannot turn them into a traversal.
+	unescaped, err := url.PathUnescape(name)
+	if err != nil {
+		unescaped = name
+	}
+	for _, candidate := range []string{name, unescaped} {
+		for _, segment := range strings.Split(strings.Replace(candidate, "\\", "/", -1), "/") {
+			if segment == ".." {
+				return "", ErrInvalidName
+			}
+		}
+	}
+
+	clean := strings.TrimPrefix(path.Clean("/"+name), "/")
+	for _, segment := range strings.Split(clean, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", notExist(name)
+		}
+	}
+
+	if ext := path.Ext(clean); ext != "" {
+		allowed := false
+		for _, e := range extensions {
+			if strings.EqualFold(ext[1:], strings.TrimPrefix(e, ".")) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", notExist(name)
+		}
+	}
+
+	return clean, nil
+}
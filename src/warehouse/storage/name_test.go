@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanName(t *testing.T) {
+	tests := []struct {
+		name  string
+		clean string
+		err   string // "invalid", "notexist" or ""
+	}{
+		{"photo.jpg", "photo.jpg", ""},
+		{"/photo.jpg", "photo.jpg", ""},
+		{"folder/photo.JPG", "folder/photo.JPG", ""},
+		{"folder//./photo.png", "folder/photo.png", ""},
+		{"noextension", "noextension", ""},
+		{"folder/", "folder", ""},
+
+		{"", "", "invalid"},
+		{"/", "", "invalid"},
+		{"../etc/passwd", "", "invalid"},
+		{"/../../etc/passwd", "", "invalid"},
+		{"folder/../../etc/passwd.jpg", "", "invalid"},
+		{"folder/..", "", "invalid"},
+		{"..", "", "invalid"},
+		{"%2e%2e/etc/passwd", "", "invalid"},
+		{"%2E%2E/%2e%2e/secret.jpg", "", "invalid"},
+		{"folder/%2e%2e/%2e%2e/x.jpg", "", "invalid"},
+		{"..%2fetc%2fpasswd", "", "invalid"},
+		{"..\\..\\windows\\win.ini", "", "invalid"},
+		{"folder\\photo.jpg", "", "invalid"},
+		{"photo.jpg\x00.png", "", "invalid"},
+		{"photo\n.jpg", "", "invalid"},
+
+		{".htaccess", "", "notexist"},
+		{".hidden/photo.jpg", "", "notexist"},
+		{"folder/.photo.jpg", "", "notexist"},
+		{"folder/./.git/config", "", "notexist"},
+		{"etc/passwd.txt", "", "notexist"},
+		{"script.php", "", "notexist"},
+		{"photo.jpg.exe", "", "notexist"},
+		{"...jpg", "", "notexist"},
+	}
+
+	for _, test := range tests {
+		clean, err := CleanName(test.name, DefaultExtensions)
+		switch test.err {
+		case "":
+			if err != nil || clean != test.clean {
+				t.Errorf("CleanName(%q) = %q, %v, want %q", test.name, clean, err, test.clean)
+			}
+		case "invalid":
+			if err != ErrInvalidName {
+				t.Errorf("CleanName(%q) = %q, %v, want ErrInvalidName", test.name, clean, err)
+			}
+		case "notexist":
+			if !os.IsNotExist(err) {
+				t.Errorf("CleanName(%q) = %q, %v, want not exist", test.name, clean, err)
+			}
+		}
+	}
+}
+
+func TestLocalConfinesToRoot(t *testing.T) {
+	outside := t.TempDir()
+	ioutil.WriteFile(filepath.Join(outside, "secret.jpg"), []byte("secret"), 0644)
+
+	root := filepath.Join(t.TempDir(), "warehouse")
+	os.MkdirAll(filepath.Join(root, "inner"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "photo.jpg"), []byte("photo"), 0644)
+	links := map[string]string{
+		"escape.jpg":       filepath.Join(outside, "secret.jpg"),
+		"escapedir":        outside,
+		"inner/up.jpg":     filepath.Join("..", "..", filepath.Base(outside), "secret.jpg"),
+		"inner/inside.jpg": filepath.Join("..", "photo.jpg"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("symlinks are not supported: %v", err)
+		}
+	}
+
+	l := NewLocal(root)
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"photo.jpg", true},
+		{"inner/inside.jpg", true},
+		{"escape.jpg", false},
+		{"escapedir/secret.jpg", false},
+		{"inner/up.jpg", false},
+		{"../" + filepath.Base(outside) + "/secret.jpg", false},
+		{"inner/../../secret.jpg", false},
+	}
+	for _, test := range tests {
+		r, err := l.Open(test.name)
+		if test.ok {
+			if err != nil {
+				t.Errorf("Open(%q): %v", test.name, err)
+			} else {
+				r.Close()
+			}
+			continue
+		}
+		if err == nil {
+			r.Close()
+			t.Errorf("Open(%q) escaped the root", test.name)
+		} else if !os.IsNotExist(err) {
+			t.Errorf("Open(%q) error = %v, want not exist", test.name, err)
+		}
+		if _, err := l.Stat(test.name); !os.IsNotExist(err) {
+			t.Errorf("Stat(%q) error = %v, want not exist", test.name, err)
+		}
+	}
+}
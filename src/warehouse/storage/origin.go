@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// OriginConfig configures an Origin.
+type OriginConfig struct {
+	// URLTemplate maps an image name to its upstream URL by replacing
+	// every "{name}" with the escaped name, as in
+	// "https://images.example.com/originals/{name}".
+	URLTemplate string
+	// AllowedHosts lists the upstream hosts that may be contacted. An
+	// entry of the form "*.example.com" allows all subdomains. Requests and
+	// redirects to any other host are refused.
+	AllowedHosts []string
+	// Timeout bounds every upstream request including reading its body.
+	Timeout time.Duration
+	// MaxSize is the largest original that is fetched, in bytes.
+	MaxSize int64
+}
+
+// Origin fetches originals from an upstream HTTP server, turning the
+// imageserver into a resizing proxy.
+type Origin struct {
+	template string
+	allowed  []string
+	maxSize  int64
+	client   *http.Client
+}
+
+// NewOrigin returns a storage fetching originals as described by config.
+func NewOrigin(config OriginConfig) (*Origin, error) {
+	if !strings.Contains(config.URLTemplate, "{name}") {
+		return nil, fmt.Errorf("origin: URL template %q has no {name}", config.URLTemplate)
+	}
+	if len(config.AllowedHosts) == 0 {
+		return nil, errors.New("origin: no allowed hosts")
+	}
+
+	o := &Origin{
+		template: config.URLTemplate,
+		maxSize:  config.MaxSize,
+	}
+	for _, host := range config.AllowedHosts {
+		o.allowed = append(o.allowed, strings.ToLower(strings.TrimSpace(host)))
+	}
+	o.client = &http.Client{
+		Timeout: config.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("origin: too many redirects")
+			}
+			if !o.allows(req.URL) {
+				return fmt.Errorf("origin: redirect to %s is not allowed", req.URL.Host)
+			}
+			return nil
+		},
+	}
+	return o, nil
+}
+
+// allows reports whether u may be fetched.
+func (o *Origin) allows(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	for _, allowed := range o.allowed {
+		// Entries with a port only match that port.
+		host := u.Hostname()
+		if strings.Contains(allowed, ":") {
+			host = u.Host
+		}
+		host = strings.ToLower(host)
+		if host == allowed || strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// url returns the upstream URL of the named object. Every path segment of
+// name is escaped, so a name cannot add a query or change the host unless
+// the template itself places the name there.
+func (o *Origin) url(name string) (string, error) {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	raw := strings.Replace(o.template, "{name}", strings.Join(segments, "/"), -1)
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", notExist(name)
+	}
+	if !o.allows(u) {
+		return "", &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return u.String(), nil
+}
+
+func (o *Origin) Open(name string) (io.ReadCloser, error) {
+	body, _, err := o.OpenIfModified(name, nil)
+	return body, err
+}
+
+func (o *Origin) OpenIfModified(name string, cached *Object) (io.ReadCloser, *Object, error) {
+	u, err := o.url(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if !cached.ModTime.IsZero() {
+			req.Header.Set("If-Modified-Since", cached.ModTime.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	resp, err := o.do(req, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, nil, ErrNotModified
+	}
+
+	if o.maxSize > 0 && resp.ContentLength > o.maxSize {
+		resp.Body.Close()
+		return nil, nil, ErrTooLarge
+	}
+	var body io.ReadCloser = upstreamBody{resp.Body}
+	if o.maxSize > 0 {
+		body = &limitedBody{ReadCloser: body, remaining: o.maxSize}
+	}
+	return body, responseObject(name, resp), nil
+}
+
+func (o *Origin) Stat(name string) (*Object, error) {
+	u, err := o.url(name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("HEAD", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := o.do(req, name)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return responseObject(name, resp), nil
+}
+
+// List is not supported by HTTP origins.
+func (o *Origin) List() ([]*Object, error) {
+	return nil, errors.New("origin: listing is not supported")
+}
+
+// do sends req and translates error responses for the named object into
+// os.ErrNotExist, os.ErrPermission or ErrUpstream.
+func (o *Origin) do(req *http.Request, name string) (*http.Response, error) {
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("origin: %w: %w", ErrUpstream, err)
+	}
+	if resp.StatusCode < 300 || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return nil, notExist(name)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return nil, fmt.Errorf("origin: %w: %s returned %s", ErrUpstream, req.URL.Host, resp.Status)
+}
+
+func responseObject(name string, resp *http.Response) *Object {
+	object := &Object{Name: name, Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}
+	object.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return object
+}
+
+// upstreamBody reports failures to read a response body, such as
+// timeouts, as ErrUpstream.
+type upstreamBody struct {
+	io.ReadCloser
+}
+
+func (b upstreamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("origin: %w: %w", ErrUpstream, err)
+	}
+	return n, err
+}
+
+// limitedBody fails with ErrTooLarge once more than remaining bytes are
+// read, which catches bodies without or with a wrong Content-Length.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
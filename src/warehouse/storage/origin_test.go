@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestOrigin(t *testing.T, handler http.HandlerFunc, config OriginConfig) *Origin {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	if config.URLTemplate == "" {
+		config.URLTemplate = server.URL + "/originals/{name}"
+	}
+	if config.AllowedHosts == nil {
+		u, _ := url.Parse(server.URL)
+		config.AllowedHosts = []string{u.Host}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+	o, err := NewOrigin(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestOriginOpen(t *testing.T) {
+	modTime := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	var paths []string
+	o := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath()+"?"+r.URL.RawQuery)
+		if !strings.HasPrefix(r.URL.Path, "/originals/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+		w.Write([]byte("image data"))
+	}, OriginConfig{})
+
+	body, object, err := o.OpenIfModified("a b/c.jpg?x=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(body)
+	body.Close()
+
+	if string(data) != "image data" {
+		t.Errorf("read %q", data)
+	}
+	if object.ETag != `"abc"` || !object.ModTime.Equal(modTime) {
+		t.Errorf("object = %+v", object)
+	}
+	if want := "/originals/a%20b/c.jpg%3Fx=1?"; paths[0] != want {
+		t.Errorf("upstream path = %q, want %q", paths[0], want)
+	}
+}
+
+func TestOriginConditionalRequest(t *testing.T) {
+	cached := &Object{ETag: `"abc"`, ModTime: time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)}
+	o := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != cached.ETag || r.Header.Get("If-Modified-Since") != cached.ModTime.Format(http.TimeFormat) {
+			t.Errorf("missing validators: %v", r.Header)
+		}
+		w.WriteHeader(http.StatusNotModified)
+	}, OriginConfig{})
+
+	if _, _, err := o.OpenIfModified("a.jpg", cached); err != ErrNotModified {
+		t.Errorf("OpenIfModified error = %v, want %v", err, ErrNotModified)
+	}
+}
+
+func TestOriginErrors(t *testing.T) {
+	o := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/originals/forbidden.jpg":
+			w.WriteHeader(http.StatusForbidden)
+		case "/originals/broken.jpg":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	}, OriginConfig{})
+
+	if _, err := o.Open("missing.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Open(missing) error = %v, want not exist", err)
+	}
+	if _, err := o.Open("forbidden.jpg"); !os.IsPermission(err) {
+		t.Errorf("Open(forbidden) error = %v, want permission denied", err)
+	}
+	if _, err := o.Open("broken.jpg"); !errors.Is(err, ErrUpstream) {
+		t.Errorf("Open(broken) error = %v, want ErrUpstream", err)
+	}
+	if _, err := o.List(); err == nil {
+		t.Error("List succeeded")
+	}
+}
+
+func TestOriginAllowList(t *testing.T) {
+	if _, err := NewOrigin(OriginConfig{URLTemplate: "https://{name}"}); err == nil {
+		t.Error("NewOrigin accepted an empty allow-list")
+	}
+
+	o, err := NewOrigin(OriginConfig{URLTemplate: "https://{name}", AllowedHosts: []string{"images.example.com", "*.cdn.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rawurl  string
+		allowed bool
+	}{
+		{"https://images.example.com/a.jpg", true},
+		{"http://IMAGES.example.com/a.jpg", true},
+		{"https://eu.cdn.example.com/a.jpg", true},
+		{"https://cdn.example.com/a.jpg", false},
+		{"https://evilcdn.example.com/a.jpg", false},
+		{"https://example.com/a.jpg", false},
+		{"https://images.example.com.evil.org/a.jpg", false},
+		{"ftp://images.example.com/a.jpg", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.rawurl)
+		if got := o.allows(u); got != test.allowed {
+			t.Errorf("allows(%s) = %v, want %v", test.rawurl, got, test.allowed)
+		}
+	}
+
+	if _, err := o.Open("metadata.internal/latest"); !os.IsPermission(err) {
+		t.Errorf("Open of a disallowed host error = %v, want permission denied", err)
+	}
+}
+
+func TestOriginRefusesRedirectToDisallowedHost(t *testing.T) {
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("disallowed host was contacted")
+	}))
+	defer elsewhere.Close()
+
+	o := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(elsewhere.URL, "127.0.0.1", "localhost", 1)+"/secret", http.StatusFound)
+	}, OriginConfig{})
+
+	if _, err := o.Open("a.jpg"); err == nil {
+		t.Error("redirect to a disallowed host was followed")
+	}
+}
+
+func TestOriginSizeLimit(t *testing.T) {
+	o := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/originals/chunked.jpg" {
+			// Flushing forces a chunked response without Content-Length.
+			w.Write(make([]byte, 600))
+			w.(http.Flusher).Flush()
+			w.Write(make([]byte, 600))
+			return
+		}
+		w.Write(make([]byte, 1200))
+	}, OriginConfig{MaxSize: 1000})
+
+	if _, err := o.Open("big.jpg"); err != ErrTooLarge {
+		t.Errorf("Open(big) error = %v, want %v", err, ErrTooLarge)
+	}
+
+	body, err := o.Open("chunked.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if _, err := ioutil.ReadAll(body); err != ErrTooLarge {
+		t.Errorf("reading chunked body error = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestOriginTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	o := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}, OriginConfig{Timeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	_, err := o.Open("slow.jpg")
+	var netErr net.Error
+	if !errors.Is(err, ErrUpstream) || !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("slow upstream: error = %v, want an upstream timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timeout took %v", elapsed)
+	}
+}
@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config configures an S3 storage.
+type S3Config struct {
+	// Endpoint is the base URL of the S3-compatible service, such as
+	// https://s3.eu-west-1.amazonaws.com. Buckets are addressed path-style.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to object names, so a storage can be confined
+	// to a "folder" of the bucket.
+	Prefix string
+
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	// Client performs the requests. A client with a 30 second timeout is
+	// used when nil.
+	Client *http.Client
+}
+
+// S3ConfigFromEnv reads the endpoint, region and credentials from the
+// S3_ENDPOINT, AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables.
+func S3ConfigFromEnv() S3Config {
+	config := S3Config{
+		Endpoint:     os.Getenv("S3_ENDPOINT"),
+		Region:       os.Getenv("AWS_REGION"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+	return config
+}
+
+// S3 serves originals from a bucket of an S3-compatible object storage.
+type S3 struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	client   *http.Client
+	signer   *signer
+
+	// now returns the signing time; replaced in tests.
+	now func() time.Time
+}
+
+// NewS3 returns a storage reading from the bucket described by config.
+func NewS3(config S3Config) (*S3, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("s3: no bucket")
+	}
+
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	prefix := strings.Trim(config.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3{
+		endpoint: endpoint,
+		bucket:   config.Bucket,
+		prefix:   prefix,
+		client:   client,
+		signer: &signer{
+			accessKey:    config.AccessKey,
+			secretKey:    config.SecretKey,
+			sessionToken: config.SessionToken,
+			region:       config.Region,
+			service:      "s3",
+		},
+		now: time.Now,
+	}, nil
+}
+
+// S3Error is an error response of the S3 API.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func (s *S3) Open(name string) (io.ReadCloser, error) {
+	return s.OpenRange(name, 0, -1)
+}
+
+// OpenRange returns length bytes of the named object starting at offset.
+// A negative length reads to the end of the object and a zero length
+// returns an empty reader without a request. Negative offsets are invalid.
+func (s *S3) OpenRange(name string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("negative offset")}
+	}
+	if length == 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+
+	req := s.request("GET", s.objectPath(name), nil)
+	if offset > 0 || length > 0 {
+		r := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if length > 0 {
+			r += strconv.FormatInt(offset+length-1, 10)
+		}
+		req.Header.Set("Range", r)
+	}
+
+	resp, err := s.do(req, name)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// OpenIfModified makes GetObject conditional on the ETag and modification
+// time of cached.
+func (s *S3) OpenIfModified(name string, cached *Object) (io.ReadCloser, *Object, error) {
+	req := s.request("GET", s.objectPath(name), nil)
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if !cached.ModTime.IsZero() {
+			req.Header.Set("If-Modified-Since", cached.ModTime.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	resp, err := s.do(req, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, nil, ErrNotModified
+	}
+	return resp.Body, responseObject(name, resp), nil
+}
+
+func (s *S3) Stat(name string) (*Object, error) {
+	resp, err := s.do(s.request("HEAD", s.objectPath(name), nil), name)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return responseObject(name, resp), nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List describes the objects below the prefix using ListObjectsV2,
+// following continuation tokens until the listing is complete.
+func (s *S3) List() ([]*Object, error) {
+	var objects []*Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if s.prefix != "" {
+			query.Set("prefix", s.prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(s.request("GET", "/"+s.bucket, query), "")
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			if strings.HasSuffix(c.Key, "/") {
+				continue
+			}
+			objects = append(objects, &Object{
+				Name:    strings.TrimPrefix(c.Key, s.prefix),
+				Size:    c.Size,
+				ModTime: c.LastModified,
+				ETag:    c.ETag,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sortObjects(objects)
+	return objects, nil
+}
+
+func (s *S3) objectPath(name string) string {
+	return "/" + s.bucket + "/" + s.prefix + name
+}
+
+func (s *S3) request(method, path string, query url.Values) *http.Request {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawPath = ""
+	if query != nil {
+		u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+	}
+	u.RawPath = canonicalURI(&u)
+
+	req, _ := http.NewRequest(method, u.String(), nil)
+	return req
+}
+
+// do signs and sends req, translating error responses for the named
+// object into os.ErrNotExist, os.ErrPermission or an *S3Error. A 304 Not
+// Modified response is returned as is.
+func (s *S3) do(req *http.Request, name string) (*http.Response, error) {
+	s.signer.sign(req, emptyPayloadHash, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, notExist(name)
+	case http.StatusForbidden:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+
+	s3Err := &S3Error{StatusCode: resp.StatusCode}
+	if body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10)); err == nil {
+		xml.Unmarshal(body, s3Err)
+	}
+	return nil, s3Err
+}
@@ -0,0 +1,86 @@
+// Package storage provides access to the original images served by the
+// imageserver, independently of where they are kept.
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Object describes a stored original.
+type Object struct {
+	// Name is the slash-separated name of the object within its storage.
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// ETag is the entity tag reported by backends that have one.
+	ETag string
+}
+
+// Storage is a read-only collection of originals. Implementations report
+// missing objects with errors satisfying os.IsNotExist and inaccessible
+// ones with errors satisfying os.IsPermission. They must be safe for
+// concurrent use.
+type Storage interface {
+	// Open returns the content of the named object. The caller must close it.
+	Open(name string) (io.ReadCloser, error)
+	// Stat describes the named object.
+	Stat(name string) (*Object, error)
+	// List describes all objects in the storage.
+	List() ([]*Object, error)
+}
+
+// ErrNotModified is returned by OpenIfModified when the object still
+// matches the caller's copy.
+var ErrNotModified = errors.New("storage: not modified")
+
+// ErrTooLarge is returned when an object exceeds the configured size limit.
+var ErrTooLarge = errors.New("storage: object too large")
+
+// ErrUpstream is returned when an upstream server cannot be reached, times
+// out or fails to answer with the object. Timeouts also satisfy net.Error.
+var ErrUpstream = errors.New("storage: upstream failed")
+
+// ConditionalStorage is implemented by storages that can skip
+// transferring an object the caller already holds.
+type ConditionalStorage interface {
+	Storage
+	// OpenIfModified opens the named object unless it still matches
+	// cached, in which case it returns ErrNotModified. It also describes
+	// the returned content, so the caller can revalidate it later.
+	OpenIfModified(name string, cached *Object) (io.ReadCloser, *Object, error)
+}
+
+// New creates the storage described by spec. A spec of the form
+// "zip:path" or "tar:path" serves the originals from an archive,
+// "s3:bucket/prefix" from an S3-compatible bucket configured by the
+// environment (see S3ConfigFromEnv), anything else is taken as the root
+// directory of a local warehouse.
+func New(spec string) (Storage, error) {
+	kind, location := "", spec
+	if i := strings.Index(spec, ":"); i > 0 {
+		kind, location = spec[:i], spec[i+1:]
+	}
+
+	switch kind {
+	case "zip":
+		return NewZip(location)
+	case "tar":
+		return NewTar(location)
+	case "s3":
+		config := S3ConfigFromEnv()
+		config.Bucket = location
+		if i := strings.Index(location, "/"); i >= 0 {
+			config.Bucket, config.Prefix = location[:i], location[i+1:]
+		}
+		return NewS3(config)
+	}
+	return NewLocal(spec), nil
+}
+
+func notExist(name string) error {
+	return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testObjects = map[string]string{
+	"a.jpg":          "first image",
+	"b.png":          "second",
+	"nested/c.jpg":   "third image in a folder",
+	"nested/d/e.gif": "",
+}
+
+var testTime = time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func writeLocal(t *testing.T) string {
+	root := t.TempDir()
+	for name, content := range testObjects {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func writeZip(t *testing.T) string {
+	buffer := new(bytes.Buffer)
+	w := zip.NewWriter(buffer)
+	w.Create("nested/")
+	for name, content := range testObjects {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: testTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writeTemp(t, "originals.zip", buffer.Bytes())
+}
+
+func tarBytes(t *testing.T) []byte {
+	buffer := new(bytes.Buffer)
+	w := tar.NewWriter(buffer)
+	w.WriteHeader(&tar.Header{Name: "./nested/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: testTime})
+	for name, content := range testObjects {
+		header := &tar.Header{Name: "./" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content)), ModTime: testTime}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func writeTemp(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func backends(t *testing.T) map[string]Storage {
+	memory := NewMemory()
+	for name, content := range testObjects {
+		memory.Put(name, []byte(content), testTime)
+	}
+
+	gz := new(bytes.Buffer)
+	w := gzip.NewWriter(gz)
+	w.Write(tarBytes(t))
+	w.Close()
+
+	specs := map[string]string{
+		"local":  writeLocal(t),
+		"zip":    "zip:" + writeZip(t),
+		"tar":    "tar:" + writeTemp(t, "originals.tar", tarBytes(t)),
+		"tar.gz": "tar:" + writeTemp(t, "originals.tar.gz", gz.Bytes()),
+	}
+	result := map[string]Storage{"memory": memory}
+	for kind, spec := range specs {
+		s, err := New(spec)
+		if err != nil {
+			t.Fatalf("New(%q): %v", spec, err)
+		}
+		result[kind] = s
+	}
+	return result
+}
+
+func TestOpen(t *testing.T) {
+	for kind, s := range backends(t) {
+		for name, content := range testObjects {
+			r, err := s.Open(name)
+			if err != nil {
+				t.Errorf("%s: Open(%q): %v", kind, name, err)
+				continue
+			}
+			data, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil || string(data) != content {
+				t.Errorf("%s: Open(%q) read %q, %v, want %q", kind, name, data, err, content)
+			}
+		}
+
+		for _, name := range []string{"missing.jpg", "nested", "nested/d"} {
+			if _, err := s.Open(name); !os.IsNotExist(err) {
+				t.Errorf("%s: Open(%q) error = %v, want not exist", kind, name, err)
+			}
+		}
+	}
+}
+
+func TestStat(t *testing.T) {
+	for kind, s := range backends(t) {
+		object, err := s.Stat("nested/c.jpg")
+		if err != nil {
+			t.Errorf("%s: Stat: %v", kind, err)
+			continue
+		}
+		if object.Name != "nested/c.jpg" || object.Size != int64(len(testObjects["nested/c.jpg"])) {
+			t.Errorf("%s: Stat = %+v", kind, object)
+		}
+		if object.ModTime.IsZero() {
+			t.Errorf("%s: Stat reported no modification time", kind)
+		}
+
+		if _, err := s.Stat("missing.jpg"); !os.IsNotExist(err) {
+			t.Errorf("%s: Stat(missing) error = %v, want not exist", kind, err)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	for kind, s := range backends(t) {
+		objects, err := s.List()
+		if err != nil {
+			t.Errorf("%s: List: %v", kind, err)
+			continue
+		}
+		if len(objects) != len(testObjects) {
+			t.Errorf("%s: List returned %d objects, want %d", kind, len(objects), len(testObjects))
+		}
+		for i, object := range objects {
+			if _, ok := testObjects[object.Name]; !ok {
+				t.Errorf("%s: List returned unexpected %q", kind, object.Name)
+			}
+			if i > 0 && objects[i-1].Name >= object.Name {
+				t.Errorf("%s: List is not sorted by name", kind)
+			}
+		}
+	}
+}